@@ -0,0 +1,130 @@
+package shprotos
+
+import (
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+)
+
+const wktAny = "google.protobuf.Any"
+
+// MessageResolver looks up messages by full name. It's used to resolve google.protobuf.Any type URLs.
+// Both *File and *Parser implement it.
+type MessageResolver interface {
+	FindMessageByName(fullName string) (*Message, bool)
+}
+
+// defaultResolver returns resolver, which looks up messages in file of msg and its imports.
+func defaultResolver(msg *Message) MessageResolver {
+	if file := msg.File(); file != nil {
+		return file
+	}
+
+	return nil
+}
+
+// resolveAnyMessage returns message, referenced by type URL, like "type.googleapis.com/pkg.Message".
+func resolveAnyMessage(resolver MessageResolver, typeURL string) (*Message, error) {
+	name := typeURL[strings.LastIndex(typeURL, "/")+1:]
+	if name == "" || resolver == nil {
+		return nil, &UnknownTypeError{TypeURL: typeURL}
+	}
+	msg, ok := resolver.FindMessageByName(name)
+	if !ok {
+		return nil, &UnknownTypeError{TypeURL: typeURL}
+	}
+
+	return msg, nil
+}
+
+// isWrappedInAny reports whether message is put to "value" key of expanded google.protobuf.Any,
+// as its canonical representation may be not an object.
+func isWrappedInAny(msg *Message) bool {
+	_, _, ok := wellKnownTypeOf(msg)
+
+	return ok || msg.GetFullName() == wktAny
+}
+
+// messageValue converts decoded message map to canonical representation of well-known message
+// or expands google.protobuf.Any. depth is nesting level of message.
+func (o UnmarshalOptions) messageValue(data map[string]interface{}, msg *Message, depth int) (interface{}, error) {
+	if wkt, _, ok := wellKnownTypeOf(msg); ok {
+		value, err := wkt.fromMessage(data, msg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to convert %s", msg.GetFullName())
+		}
+		return value, nil
+	}
+	if msg.GetFullName() == wktAny {
+		value, err := o.expandAny(data, depth)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to expand %s", wktAny)
+		}
+		return value, nil
+	}
+
+	return data, nil
+}
+
+// expandAny decodes embedded message of google.protobuf.Any and returns it with AnyTypeKey set to type URL.
+// Messages, wrapped in Any, are returned as {"@type": url, "value": value}. Embedded message is nested
+// one level deeper, than Any of given depth.
+func (o UnmarshalOptions) expandAny(data map[string]interface{}, depth int) (map[string]interface{}, error) {
+	typeURL, _ := data["type_url"].(string)
+	if typeURL == "" {
+		return map[string]interface{}{}, nil
+	}
+	msg, err := resolveAnyMessage(o.Resolver, typeURL)
+	if err != nil {
+		return nil, err
+	}
+	var raw []byte
+	if value, ok := data["value"]; ok {
+		if raw, err = bytesFromInterface(value); err != nil {
+			return nil, errors.Wrap(err, "failed to resolve value")
+		}
+	}
+	embedded, err := o.unmarshalMessageBytesToMap(proto.NewBuffer(raw), msg, nil, depth+1)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal %s", msg.GetFullName())
+	}
+	if o.EmitDefaults {
+		o.populateDefaults(embedded, msg, nil, nil)
+	}
+	if isWrappedInAny(msg) {
+		value, err := o.messageValue(embedded, msg, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{AnyTypeKey: typeURL, "value": value}, nil
+	}
+	embedded[AnyTypeKey] = typeURL
+
+	return embedded, nil
+}
+
+// packAny encodes expanded google.protobuf.Any value to message map with type_url and value fields.
+// Values without AnyTypeKey are considered already packed.
+func (o MarshalOptions) packAny(data map[string]interface{}, path string) (map[string]interface{}, error) {
+	typeURL, ok := data[AnyTypeKey].(string)
+	if !ok {
+		return data, nil
+	}
+	msg, err := resolveAnyMessage(o.Resolver, typeURL)
+	if err != nil {
+		return nil, newFieldError(path, wktAny, data, err)
+	}
+	msgData := data
+	if isWrappedInAny(msg) {
+		if msgData, err = o.messageData(data["value"], msg, joinFieldPath(path, "value")); err != nil {
+			return nil, err
+		}
+	}
+	value, err := o.marshalAppend(nil, msgData, msg, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"type_url": typeURL, "value": value}, nil
+}
@@ -0,0 +1,119 @@
+package shprotos
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/any"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAny(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/any.proto", nil, []string{"./testdata"})
+	require.NoError(t, err)
+	_, err = parser.Parse("./testdata/wkt.proto", nil, []string{"./testdata"})
+	require.NoError(t, err)
+	event, ok := parsedFile.Message(TypeName{"Event"})
+	require.True(t, ok)
+	created, ok := parsedFile.Message(TypeName{"Created"})
+	require.True(t, ok)
+
+	createdData, err := MarshalMessage(map[string]interface{}{"id": "1", "at": int64(10)}, created)
+	require.NoError(t, err)
+	ts := &timestamp.Timestamp{Seconds: 1}
+	tsAny, err := ptypes.MarshalAny(ts)
+	require.NoError(t, err)
+	anyAny, err := ptypes.MarshalAny(tsAny)
+	require.NoError(t, err)
+	buffer := proto.NewBuffer(nil)
+	payloadLen := 0
+	for number, value := range []proto.Message{
+		&any.Any{TypeUrl: "type.googleapis.com/example.any.Created", Value: createdData},
+		tsAny,
+		anyAny,
+	} {
+		data, err := proto.Marshal(value)
+		require.NoError(t, err)
+		fieldNumber := uint64(3)
+		if number == 0 {
+			fieldNumber = 2
+		}
+		require.NoError(t, buffer.EncodeVarint(messageKeyVarint(fieldNumber, WireTypeLengthDelimited)))
+		require.NoError(t, buffer.EncodeRawBytes(data))
+		if number == 0 {
+			payloadLen = len(buffer.Bytes())
+		}
+	}
+
+	expected := map[string]interface{}{
+		"payload": map[string]interface{}{
+			AnyTypeKey: "type.googleapis.com/example.any.Created",
+			"id":       "1",
+			"at":       int64(10),
+		},
+		"details": []interface{}{
+			map[string]interface{}{
+				AnyTypeKey: "type.googleapis.com/google.protobuf.Timestamp",
+				"value":    "1970-01-01T00:00:01Z",
+			},
+			map[string]interface{}{
+				AnyTypeKey: "type.googleapis.com/google.protobuf.Any",
+				"value": map[string]interface{}{
+					AnyTypeKey: "type.googleapis.com/google.protobuf.Timestamp",
+					"value":    "1970-01-01T00:00:01Z",
+				},
+			},
+		},
+	}
+	res, err := UnmarshalOptions{Resolver: &parser}.Unmarshal(buffer.Bytes(), event)
+	require.NoError(t, err)
+	require.Equal(t, expected, res)
+
+	marshalled, err := MarshalOptions{Resolver: &parser}.Marshal(expected, event)
+	require.NoError(t, err)
+	require.Equal(t, buffer.Bytes(), marshalled)
+
+	// json keeps embedded fields next to @type, well-known values are wrapped to value
+	jsonData, err := JSONMarshaler{Resolver: &parser}.Marshal(expected, event)
+	require.NoError(t, err)
+	tsJSON, err := (&jsonpb.Marshaler{}).MarshalToString(tsAny)
+	require.NoError(t, err)
+	anyJSON, err := (&jsonpb.Marshaler{}).MarshalToString(anyAny)
+	require.NoError(t, err)
+	requireJSONEq(t, []byte(`{
+		"payload": {"@type": "type.googleapis.com/example.any.Created", "id": "1", "at": "10"},
+		"details": [`+tsJSON+`, `+anyJSON+`]
+	}`), jsonData)
+	res, err = JSONUnmarshaler{Resolver: &parser}.Unmarshal(jsonData, event)
+	require.NoError(t, err)
+	require.Equal(t, expected, res)
+
+	// @type may follow fields of embedded message
+	res, err = JSONUnmarshaler{Resolver: &parser}.Unmarshal([]byte(`{"payload": {
+		"id": "1", "at": "10", "@type": "type.googleapis.com/example.any.Created"
+	}}`), event)
+	require.NoError(t, err)
+	require.Equal(t, expected["payload"], res["payload"])
+	_, err = JSONUnmarshaler{Resolver: &parser}.Unmarshal([]byte(`{"payload": {"id": "1"}}`), event)
+	require.IsType(t, &FieldError{}, err)
+
+	// file resolver finds only messages of the file and its imports
+	_, err = UnmarshalMessage(buffer.Bytes(), event)
+	require.IsType(t, &UnknownTypeError{}, errors.Cause(err))
+	_, err = MarshalMessage(expected, event)
+	fieldErr, ok := err.(*FieldError)
+	require.True(t, ok)
+	require.Equal(t, "details[0]", fieldErr.Path)
+	require.IsType(t, &UnknownTypeError{}, fieldErr.Err)
+	_, err = JSONUnmarshaler{}.Unmarshal(jsonData, event)
+	require.Error(t, err)
+
+	res, err = UnmarshalMessage(buffer.Bytes()[:payloadLen], event)
+	require.NoError(t, err)
+	require.Equal(t, expected["payload"], res["payload"])
+}
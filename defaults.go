@@ -0,0 +1,132 @@
+package shprotos
+
+// populateDefaults sets missing fields of decoded message to their default values and does the same
+// for nested messages. Fields, which aren't selected by projection, are left missing. ancestors holds messages,
+// which are populated up the stack, to stop on recursive types.
+func (o UnmarshalOptions) populateDefaults(data map[string]interface{}, msg *Message, projection *Projection, ancestors []*Message) {
+	ancestors = append(ancestors, msg)
+	for _, field := range msg.GetFields() {
+		fieldProjection, selected := projection.field(field.GetKeyNumber())
+		if !selected {
+			continue
+		}
+		if value, ok := data[field.GetName()]; ok {
+			o.populateNestedDefaults(value, field, fieldProjection, ancestors)
+			continue
+		}
+		if fld, ok := field.(*NormalField); ok && fld.OneOf != nil {
+			continue
+		}
+		// nil google.protobuf.Value is a null value, so unset Value field is left missing
+		if holdsNullValue(field) {
+			continue
+		}
+		data[field.GetName()] = o.fieldDefaultValue(field, fieldProjection, ancestors)
+	}
+}
+
+// populateNestedDefaults populates defaults of messages, contained in field value.
+func (o UnmarshalOptions) populateNestedDefaults(value interface{}, field Field, projection *Projection, ancestors []*Message) {
+	switch fld := field.(type) {
+	case *MapField:
+		msg, ok := fld.Map.ValueType.(*Message)
+		if !ok || !isPlainMessage(msg) {
+			return
+		}
+		switch values := value.(type) {
+		case map[string]interface{}:
+			for _, item := range values {
+				o.populateMessageDefaults(item, msg, projection, ancestors)
+			}
+		case map[interface{}]interface{}:
+			for _, item := range values {
+				o.populateMessageDefaults(item, msg, projection, ancestors)
+			}
+		}
+	case *NormalField:
+		msg, ok := fld.Type.(*Message)
+		if !ok || !isPlainMessage(msg) {
+			return
+		}
+		if !fld.Repeated {
+			o.populateMessageDefaults(value, msg, projection, ancestors)
+			return
+		}
+		values, _ := value.([]interface{})
+		for _, item := range values {
+			o.populateMessageDefaults(item, msg, projection, ancestors)
+		}
+	}
+}
+
+func (o UnmarshalOptions) populateMessageDefaults(value interface{}, msg *Message, projection *Projection, ancestors []*Message) {
+	if data, ok := value.(map[string]interface{}); ok {
+		o.populateDefaults(data, msg, projection, ancestors)
+	}
+}
+
+// fieldDefaultValue returns value of field, missing on the wire.
+func (o UnmarshalOptions) fieldDefaultValue(field Field, projection *Projection, ancestors []*Message) interface{} {
+	switch fld := field.(type) {
+	case *MapField:
+		switch {
+		case o.TypedMapKeys && o.NilContainers:
+			return map[interface{}]interface{}(nil)
+		case o.TypedMapKeys:
+			return map[interface{}]interface{}{}
+		case o.NilContainers:
+			return map[string]interface{}(nil)
+		}
+		return map[string]interface{}{}
+	case *NormalField:
+		if fld.Repeated {
+			_, isEnum := fld.Type.(*Enum)
+			switch {
+			case isEnum && !o.EnumsAsNames && o.NilContainers:
+				return []int32(nil)
+			case isEnum && !o.EnumsAsNames:
+				return []int32{}
+			case o.NilContainers:
+				return []interface{}(nil)
+			}
+			return []interface{}{}
+		}
+		if msg, ok := fld.Type.(*Message); ok {
+			return o.emptyMessageValue(msg, projection, ancestors)
+		}
+		if fld.HasPresence() {
+			return nil
+		}
+		value := o.defaultValue(fld.Type)
+		if enum, ok := fld.Type.(*Enum); ok {
+			value, _ = o.enumValue(value.(int32), enum)
+		}
+		return value
+	}
+
+	return nil
+}
+
+// emptyMessageValue returns value of unset message field: nil or empty message, if EmptyMessages is set.
+func (o UnmarshalOptions) emptyMessageValue(msg *Message, projection *Projection, ancestors []*Message) interface{} {
+	if !o.EmptyMessages {
+		return nil
+	}
+	for _, ancestor := range ancestors {
+		if ancestor == msg {
+			return nil
+		}
+	}
+	if !isPlainMessage(msg) {
+		return mapEntryDefaultValue(msg)
+	}
+	data := map[string]interface{}{}
+	o.populateDefaults(data, msg, projection, ancestors)
+
+	return data
+}
+
+// isPlainMessage reports whether message is decoded to map of its fields, unlike well-known types and Any.
+func isPlainMessage(msg *Message) bool {
+	return !isWrappedInAny(msg)
+}
@@ -0,0 +1,863 @@
+package shprotos
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// DynamicMessage is a value of message, described by *Message. Field values have the same representation,
+// as values in maps, returned by UnmarshalMessage, except that set fields of message type are returned
+// as *DynamicMessage.
+type DynamicMessage struct {
+	desc   *Message
+	values map[string]interface{}
+}
+
+func NewDynamicMessage(desc *Message) *DynamicMessage {
+	return &DynamicMessage{desc: desc, values: make(map[string]interface{})}
+}
+
+// DynamicMessageFromMap wraps message value, like one returned by UnmarshalMessage. The map isn't copied,
+// so changes of dynamic message are visible in it.
+func DynamicMessageFromMap(desc *Message, values map[string]interface{}) *DynamicMessage {
+	if values == nil {
+		values = make(map[string]interface{})
+	}
+
+	return &DynamicMessage{desc: desc, values: values}
+}
+
+func (m *DynamicMessage) Descriptor() *Message {
+	return m.desc
+}
+
+// AsMap returns underlying message value, accepted by MarshalMessage.
+func (m *DynamicMessage) AsMap() map[string]interface{} {
+	return m.values
+}
+
+// marshalOptions returns options, used to validate field values.
+func (m *DynamicMessage) marshalOptions() MarshalOptions {
+	return MarshalOptions{Resolver: defaultResolver(m.desc)}
+}
+
+func (m *DynamicMessage) field(name string) (Field, error) {
+	field, ok := m.desc.GetFieldByName(name)
+	if !ok {
+		return nil, errors.Errorf("message %s has no field %s", m.desc.GetFullName(), name)
+	}
+
+	return field, nil
+}
+
+func (m *DynamicMessage) fieldByNumber(number uint64) (Field, error) {
+	field, ok := m.desc.FieldByKeyNumber(number)
+	if !ok {
+		return nil, errors.Errorf("message %s has no field with number %d", m.desc.GetFullName(), number)
+	}
+
+	return field, nil
+}
+
+// Get returns field value or its default value, if field isn't set. Unset message fields are returned as nil.
+// Lazy messages are decoded, error of decoding is returned.
+func (m *DynamicMessage) Get(name string) (interface{}, error) {
+	field, err := m.field(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.get(field)
+}
+
+func (m *DynamicMessage) GetByNumber(number uint64) (interface{}, error) {
+	field, err := m.fieldByNumber(number)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.get(field)
+}
+
+func (m *DynamicMessage) get(field Field) (interface{}, error) {
+	value, ok := m.values[field.GetName()]
+	if !ok || value == nil {
+		return fieldZeroValue(field), nil
+	}
+	if fld, ok := field.(*NormalField); ok && !fld.Repeated {
+		return dynamicValue(value, fld.Type, fld.Name)
+	}
+
+	return value, nil
+}
+
+// dynamicValue wraps value of message type to *DynamicMessage. Lazy messages are decoded, their wrappers
+// share the decoded map, so changes are marshalled. Values of other types are returned as is.
+func dynamicValue(value interface{}, typ Type, path string) (interface{}, error) {
+	msg, ok := typ.(*Message)
+	if !ok || !isPlainMessage(msg) {
+		return value, nil
+	}
+	switch value.(type) {
+	case map[string]interface{}, *LazyMessage:
+		data, err := messageMap(value, msg, path)
+		if err != nil {
+			return nil, err
+		}
+		return DynamicMessageFromMap(msg, data), nil
+	}
+
+	return value, nil
+}
+
+// fieldZeroValue returns value of unset field: its declared default value or zero value of its type.
+func fieldZeroValue(field Field) interface{} {
+	switch fld := field.(type) {
+	case *MapField:
+		return map[string]interface{}{}
+	case *NormalField:
+		if fld.Repeated {
+			return []interface{}{}
+		}
+		if _, ok := fld.Type.(*Message); ok {
+			return nil
+		}
+		if value, ok := declaredDefaultValue(fld); ok {
+			return value
+		}
+		return mapEntryDefaultValue(fld.Type)
+	}
+
+	return nil
+}
+
+// declaredDefaultValue parses [default = ...] option of proto2 field. Bytes default is returned
+// as base64 string and enum default as int32, like UnmarshalMessage returns them.
+func declaredDefaultValue(fld *NormalField) (interface{}, bool) {
+	option, ok := findOption(fld.GetOptions(), "default")
+	if !ok {
+		return nil, false
+	}
+	source := option.Constant.Source
+	switch typ := fld.Type.(type) {
+	case *Enum:
+		enumValue, ok := typ.ValueByName(source)
+		if !ok {
+			return nil, false
+		}
+		return int32(enumValue.Value), true
+	case *Scalar:
+		var value interface{}
+		var err error
+		switch kind := typ.scalarKind(); kind {
+		case ScalarString:
+			return source, true
+		case ScalarBytes:
+			return base64.StdEncoding.EncodeToString([]byte(source)), true
+		case ScalarBool:
+			value, err = strconv.ParseBool(source)
+		case ScalarFloat, ScalarDouble:
+			value, err = strconv.ParseFloat(source, 64)
+		case ScalarUint32, ScalarUint64, ScalarFixed32, ScalarFixed64:
+			value, err = strconv.ParseUint(source, 0, 64)
+		default:
+			value, err = strconv.ParseInt(source, 0, 64)
+		}
+		if err != nil {
+			return nil, false
+		}
+		result, err := scalarValue(value, typ.scalarKind())
+		return result, err == nil
+	}
+
+	return nil, false
+}
+
+// Set validates value against field type and stores its copy in representation, returned by UnmarshalMessage.
+// Setting oneof member clears other members of the oneof.
+// Messages may be given as *DynamicMessage or map[string]interface{}.
+func (m *DynamicMessage) Set(name string, value interface{}) error {
+	field, err := m.field(name)
+	if err != nil {
+		return err
+	}
+
+	return m.set(field, value)
+}
+
+func (m *DynamicMessage) SetByNumber(number uint64, value interface{}) error {
+	field, err := m.fieldByNumber(number)
+	if err != nil {
+		return err
+	}
+
+	return m.set(field, value)
+}
+
+func (m *DynamicMessage) set(field Field, value interface{}) error {
+	value = unwrapDynamicMessages(value)
+	if value == nil {
+		m.clear(field)
+		return nil
+	}
+	value, err := m.normalize(field, value)
+	if err != nil {
+		return err
+	}
+	if oneOf := field.GetOneOf(); oneOf != nil {
+		for _, member := range oneOf.Fields {
+			if member.Name != field.GetName() {
+				m.clear(member)
+			}
+		}
+	}
+	m.values[field.GetName()] = value
+
+	return nil
+}
+
+// normalize validates field value the same way, as Marshal does, and converts it to representation,
+// returned by UnmarshalMessage.
+func (m *DynamicMessage) normalize(field Field, value interface{}) (interface{}, error) {
+	return m.marshalOptions().normalizeField(value, field, field.GetName())
+}
+
+func (o MarshalOptions) normalizeField(value interface{}, field Field, path string) (interface{}, error) {
+	switch fld := field.(type) {
+	case *MapField:
+		return o.normalizeMap(value, fld, path)
+	case *NormalField:
+		if fld.Repeated {
+			return o.normalizeList(value, fld, path)
+		}
+		return o.normalizeValue(value, fld.Type, path)
+	}
+
+	return nil, newFieldError(path, protoTypeName(field.GetType()), value, errors.Errorf("unexpected field %T", field))
+}
+
+// normalizeList converts elements of repeated field. Enum values are collected to []int32.
+func (o MarshalOptions) normalizeList(value interface{}, fld *NormalField, path string) (interface{}, error) {
+	values := reflect.ValueOf(value)
+	if values.Kind() != reflect.Slice && values.Kind() != reflect.Array {
+		return nil, newFieldError(path, "repeated "+protoTypeName(fld.Type), value, nil)
+	}
+	elements := make([]interface{}, values.Len())
+	for i := range elements {
+		element, err := o.normalizeValue(values.Index(i).Interface(), fld.Type, fmt.Sprintf("%s[%d]", path, i))
+		if err != nil {
+			return nil, err
+		}
+		elements[i] = element
+	}
+	if _, ok := fld.Type.(*Enum); ok {
+		numbers := make([]int32, len(elements))
+		for i, element := range elements {
+			numbers[i] = element.(int32)
+		}
+		return numbers, nil
+	}
+
+	return elements, nil
+}
+
+// normalizeMap converts entries of map field, formatting keys to canonical strings.
+// Missing values are replaced with default value of map value type.
+func (o MarshalOptions) normalizeMap(value interface{}, fld *MapField, path string) (interface{}, error) {
+	mapValue := reflect.ValueOf(value)
+	if mapValue.Kind() != reflect.Map {
+		return nil, newFieldError(path, protoTypeName(fld.Map), value, nil)
+	}
+	result := make(map[string]interface{}, mapValue.Len())
+	for _, key := range mapValue.MapKeys() {
+		mapKey := key.Interface()
+		entryPath := fmt.Sprintf("%s[%v]", path, mapKey)
+		str, err := formatMapKey(mapKey, fld.Map.KeyType)
+		if err != nil {
+			return nil, newFieldError(entryPath, protoTypeName(fld.Map.KeyType), mapKey, err)
+		}
+		entryValue := mapValue.MapIndex(key).Interface()
+		// nil google.protobuf.Value is a null value, not a missing one
+		if entryValue == nil && !isWellKnownType(fld.Map.ValueType, wktValue) {
+			result[str] = mapEntryDefaultValue(fld.Map.ValueType)
+			continue
+		}
+		if result[str], err = o.normalizeValue(entryValue, fld.Map.ValueType, entryPath); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// normalizeValue converts single value of given type. Bytes are converted to base64 strings and enums to int32.
+func (o MarshalOptions) normalizeValue(value interface{}, typ Type, path string) (interface{}, error) {
+	switch typ := typ.(type) {
+	case *Scalar:
+		result, err := scalarValue(value, typ.scalarKind())
+		if err != nil {
+			return nil, newFieldError(path, protoTypeName(typ), value, err)
+		}
+		if data, ok := result.([]byte); ok {
+			return base64.StdEncoding.EncodeToString(data), nil
+		}
+		return result, nil
+	case *Enum:
+		number, name, err := resolveEnumValue(value, typ)
+		if err != nil {
+			return nil, newFieldError(path, protoTypeName(typ), value, err)
+		}
+		if name == "" && typ.IsClosed() {
+			return nil, newFieldError(path, protoTypeName(typ), value, errors.Errorf("unknown %s value %d", typ.Name, number))
+		}
+		return number, nil
+	case *Message:
+		return o.normalizeMessage(value, typ, path)
+	}
+
+	return nil, newFieldError(path, protoTypeName(typ), value, errors.Errorf("unexpected type %T", typ))
+}
+
+// scalarValue converts value, accepted by Marshal, to Go type of scalar kind.
+func scalarValue(value interface{}, kind ScalarKind) (interface{}, error) {
+	switch kind {
+	case ScalarString:
+		str, ok := value.(string)
+		if !ok {
+			return nil, errors.Errorf("can't convert %T to string", value)
+		}
+		return str, nil
+	case ScalarBytes:
+		data, err := bytesFromInterface(value)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), data...), nil
+	case ScalarBool:
+		return boolFromInterface(value)
+	case ScalarFloat:
+		return float32FromInterface(value)
+	case ScalarDouble:
+		return float64FromInterface(value)
+	}
+	number, err := uint64FromInterface(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return reflect.ValueOf(number).Convert(kind.GoType()).Interface(), nil
+}
+
+// normalizeMessage converts message value. Well-known messages are converted through their message maps,
+// so values in any accepted form get canonical representation.
+func (o MarshalOptions) normalizeMessage(value interface{}, typ *Message, path string) (interface{}, error) {
+	if wkt, _, ok := wellKnownTypeOf(typ); ok {
+		data, err := wkt.toMessage(value, typ)
+		if err != nil {
+			return nil, newFieldError(path, protoTypeName(typ), value, err)
+		}
+		if data, err = o.normalizeFields(data, typ, path); err != nil {
+			return nil, err
+		}
+		result, err := wkt.fromMessage(data, typ)
+		if err != nil {
+			return nil, newFieldError(path, protoTypeName(typ), value, err)
+		}
+		return result, nil
+	}
+	data, err := messageMap(value, typ, path)
+	if err != nil {
+		return nil, err
+	}
+	if typ.GetFullName() == wktAny {
+		return o.normalizeAny(data, typ, path)
+	}
+
+	return o.normalizeFields(data, typ, path)
+}
+
+// normalizeFields converts set fields of message map. Unknown fields and oneof cases are dropped,
+// as UnmarshalMessage doesn't return them by default.
+func (o MarshalOptions) normalizeFields(data map[string]interface{}, typ *Message, path string) (map[string]interface{}, error) {
+	skipFields, err := o.resolveOneOfs(data, typ, path)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]interface{}, len(data))
+	for _, fp := range planOf(typ).fields {
+		value, ok := data[fp.name]
+		if !ok || (value == nil && !holdsNullValue(fp.field)) || skipFields[fp.name] {
+			continue
+		}
+		if result[fp.name], err = o.normalizeField(value, fp.field, joinFieldPath(path, fp.name)); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// normalizeAny converts google.protobuf.Any to expanded form. Packed values are expanded, expanded ones are
+// converted by embedded message type.
+func (o MarshalOptions) normalizeAny(data map[string]interface{}, typ *Message, path string) (interface{}, error) {
+	typeURL, ok := data[AnyTypeKey].(string)
+	if !ok {
+		packed, err := o.normalizeFields(data, typ, path)
+		if err != nil {
+			return nil, err
+		}
+		expanded, err := UnmarshalOptions{Resolver: o.Resolver}.expandAny(packed, 0)
+		if err != nil {
+			return nil, newFieldError(path, wktAny, data, err)
+		}
+		return expanded, nil
+	}
+	msg, err := resolveAnyMessage(o.Resolver, typeURL)
+	if err != nil {
+		return nil, newFieldError(path, wktAny, data, err)
+	}
+	if isWrappedInAny(msg) {
+		value, err := o.normalizeMessage(data["value"], msg, joinFieldPath(path, "value"))
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{AnyTypeKey: typeURL, "value": value}, nil
+	}
+	result, err := o.normalizeFields(data, msg, path)
+	if err != nil {
+		return nil, err
+	}
+	result[AnyTypeKey] = typeURL
+
+	return result, nil
+}
+
+// unwrapDynamicMessages replaces *DynamicMessage values, including ones in slices and maps, with their maps.
+func unwrapDynamicMessages(value interface{}) interface{} {
+	switch value := value.(type) {
+	case *DynamicMessage:
+		if value == nil {
+			return nil
+		}
+		return value.values
+	case []*DynamicMessage:
+		result := make([]interface{}, len(value))
+		for i, item := range value {
+			result[i] = unwrapDynamicMessages(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, item := range value {
+			result[i] = unwrapDynamicMessages(item)
+		}
+		return result
+	case map[string]*DynamicMessage:
+		result := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			result[key] = unwrapDynamicMessages(item)
+		}
+		return result
+	}
+
+	return value
+}
+
+// Has reports whether field is set. Fields without explicit presence are considered set, when they have
+// non-zero value, and repeated and map fields, when they aren't empty.
+func (m *DynamicMessage) Has(name string) bool {
+	field, ok := m.desc.GetFieldByName(name)
+
+	return ok && m.has(field)
+}
+
+func (m *DynamicMessage) HasByNumber(number uint64) bool {
+	field, ok := m.desc.FieldByKeyNumber(number)
+
+	return ok && m.has(field)
+}
+
+func (m *DynamicMessage) has(field Field) bool {
+	value, ok := m.values[field.GetName()]
+	if !ok || value == nil {
+		return false
+	}
+	if field.HasPresence() && !field.IsRepeated() {
+		return true
+	}
+
+	return !isZeroValue(reflect.ValueOf(value))
+}
+
+func isZeroValue(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String, reflect.Array:
+		return value.Len() == 0
+	case reflect.Bool:
+		return !value.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return value.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return value.Float() == 0
+	case reflect.Ptr, reflect.Interface:
+		return value.IsNil()
+	}
+
+	return false
+}
+
+// Clear unsets field.
+func (m *DynamicMessage) Clear(name string) error {
+	field, err := m.field(name)
+	if err != nil {
+		return err
+	}
+	m.clear(field)
+
+	return nil
+}
+
+func (m *DynamicMessage) ClearByNumber(number uint64) error {
+	field, err := m.fieldByNumber(number)
+	if err != nil {
+		return err
+	}
+	m.clear(field)
+
+	return nil
+}
+
+func (m *DynamicMessage) clear(field Field) {
+	delete(m.values, field.GetName())
+	oneOf := field.GetOneOf()
+	if oneOf == nil {
+		return
+	}
+	if cases, ok := m.values[OneOfCasesKey].(map[string]string); ok && cases[oneOf.Name] == field.GetName() {
+		delete(cases, oneOf.Name)
+	}
+}
+
+// Range calls f for every set field in key number order, until f returns false. Lazy messages, which fail
+// to decode, are passed as is.
+func (m *DynamicMessage) Range(f func(field Field, value interface{}) bool) {
+	for _, field := range m.desc.GetFieldsByNumber() {
+		if !m.has(field) {
+			continue
+		}
+		value, err := m.get(field)
+		if err != nil {
+			value = m.values[field.GetName()]
+		}
+		if !f(field, value) {
+			return
+		}
+	}
+}
+
+// Reset unsets all fields.
+func (m *DynamicMessage) Reset() {
+	m.values = make(map[string]interface{})
+}
+
+// Clone returns deep copy of message.
+func (m *DynamicMessage) Clone() *DynamicMessage {
+	return &DynamicMessage{desc: m.desc, values: cloneValue(m.values).(map[string]interface{})}
+}
+
+func cloneValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			result[key] = cloneValue(item)
+		}
+		return result
+	case map[interface{}]interface{}:
+		result := make(map[interface{}]interface{}, len(value))
+		for key, item := range value {
+			result[key] = cloneValue(item)
+		}
+		return result
+	case map[string]string:
+		result := make(map[string]string, len(value))
+		for key, item := range value {
+			result[key] = item
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, item := range value {
+			result[i] = cloneValue(item)
+		}
+		return result
+	case []int32:
+		return append([]int32(nil), value...)
+	case []byte:
+		return append([]byte(nil), value...)
+	}
+	slice := reflect.ValueOf(value)
+	if slice.Kind() == reflect.Slice && !slice.IsNil() {
+		result := reflect.MakeSlice(slice.Type(), slice.Len(), slice.Len())
+		for i := 0; i < slice.Len(); i++ {
+			result.Index(i).Set(reflect.ValueOf(cloneValue(slice.Index(i).Interface())))
+		}
+		return result.Interface()
+	}
+
+	return value
+}
+
+func (m *DynamicMessage) Marshal() ([]byte, error) {
+	return MarshalMessage(m.values, m.desc)
+}
+
+// Unmarshal replaces message fields with decoded ones. Unknown fields are kept, so they are marshalled back.
+func (m *DynamicMessage) Unmarshal(data []byte) error {
+	values, err := UnmarshalOptions{KeepUnknownFields: true}.Unmarshal(data, m.desc)
+	if err != nil {
+		return err
+	}
+	m.values = values
+
+	return nil
+}
+
+// List returns accessor of repeated field.
+func (m *DynamicMessage) List(name string) (*DynamicList, error) {
+	field, err := m.field(name)
+	if err != nil {
+		return nil, err
+	}
+	fld, ok := field.(*NormalField)
+	if !ok || !fld.Repeated {
+		return nil, errors.Errorf("field %s of message %s isn't repeated", name, m.desc.GetFullName())
+	}
+
+	return &DynamicList{msg: m, field: fld}, nil
+}
+
+// Map returns accessor of map field.
+func (m *DynamicMessage) Map(name string) (*DynamicMap, error) {
+	field, err := m.field(name)
+	if err != nil {
+		return nil, err
+	}
+	fld, ok := field.(*MapField)
+	if !ok {
+		return nil, errors.Errorf("field %s of message %s isn't a map", name, m.desc.GetFullName())
+	}
+
+	return &DynamicMap{msg: m, field: fld}, nil
+}
+
+// DynamicList gives access to elements of repeated field of dynamic message. Elements are validated
+// against field type on set.
+type DynamicList struct {
+	msg   *DynamicMessage
+	field *NormalField
+}
+
+func (l *DynamicList) values() reflect.Value {
+	value := reflect.ValueOf(l.msg.values[l.field.Name])
+	if value.Kind() != reflect.Slice {
+		return reflect.ValueOf([]interface{}{})
+	}
+
+	return value
+}
+
+// mutableValues returns list elements as []interface{}, converting list to it, if needed.
+func (l *DynamicList) mutableValues() []interface{} {
+	if values, ok := l.msg.values[l.field.Name].([]interface{}); ok {
+		return values
+	}
+	slice := l.values()
+	values := make([]interface{}, slice.Len())
+	for i := range values {
+		values[i] = slice.Index(i).Interface()
+	}
+	l.msg.values[l.field.Name] = values
+
+	return values
+}
+
+func (l *DynamicList) Len() int {
+	return l.values().Len()
+}
+
+// Get returns element by index. Message elements are returned as *DynamicMessage. Lazy messages,
+// which fail to decode, are returned as is, so error is available from their Map.
+func (l *DynamicList) Get(i int) interface{} {
+	value := l.values().Index(i).Interface()
+	if result, err := dynamicValue(value, l.field.Type, fmt.Sprintf("%s[%d]", l.field.Name, i)); err == nil {
+		return result
+	}
+
+	return value
+}
+
+func (l *DynamicList) Set(i int, value interface{}) error {
+	if i < 0 || i >= l.Len() {
+		return errors.Errorf("index %d is out of range of %s", i, l.field.Name)
+	}
+	values, err := l.normalize([]interface{}{value}, i)
+	if err != nil {
+		return err
+	}
+	l.mutableValues()[i] = values[0]
+
+	return nil
+}
+
+func (l *DynamicList) Append(values ...interface{}) error {
+	values, err := l.normalize(values, l.Len())
+	if err != nil {
+		return err
+	}
+	l.msg.values[l.field.Name] = append(l.mutableValues(), values...)
+
+	return nil
+}
+
+// normalize validates elements, which are going to be put to the list starting from given index,
+// and converts them to representation, returned by UnmarshalMessage.
+func (l *DynamicList) normalize(values []interface{}, start int) ([]interface{}, error) {
+	o := l.msg.marshalOptions()
+	elements := make([]interface{}, len(values))
+	for i, value := range values {
+		path := fmt.Sprintf("%s[%d]", l.field.Name, start+i)
+		value = unwrapDynamicMessages(value)
+		if value == nil {
+			return nil, newFieldError(path, protoTypeName(l.field.Type), value, nil)
+		}
+		element, err := o.normalizeValue(value, l.field.Type, path)
+		if err != nil {
+			return nil, err
+		}
+		elements[i] = element
+	}
+
+	return elements, nil
+}
+
+// Truncate keeps first n elements of the list.
+func (l *DynamicList) Truncate(n int) {
+	if n < l.Len() {
+		l.msg.values[l.field.Name] = l.mutableValues()[:n]
+	}
+}
+
+// DynamicMap gives access to entries of map field of dynamic message. Keys may be given in any form,
+// accepted by marshal, like int32 or "10" for integral keys, and are stored in canonical string form.
+type DynamicMap struct {
+	msg   *DynamicMessage
+	field *MapField
+}
+
+func (d *DynamicMap) key(key interface{}) (string, error) {
+	str, err := formatMapKey(key, d.field.Map.KeyType)
+	if err != nil {
+		return "", newFieldError(fmt.Sprintf("%s[%v]", d.field.Name, key), protoTypeName(d.field.Map.KeyType), key, err)
+	}
+
+	return str, nil
+}
+
+// entries returns map entries with canonical string keys, converting map to such form, if needed.
+func (d *DynamicMap) entries() map[string]interface{} {
+	switch values := d.msg.values[d.field.Name].(type) {
+	case map[string]interface{}:
+		if values != nil {
+			return values
+		}
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(values))
+		for key, value := range values {
+			if str, err := formatMapKey(key, d.field.Map.KeyType); err == nil {
+				result[str] = value
+			}
+		}
+		d.msg.values[d.field.Name] = result
+		return result
+	}
+	result := make(map[string]interface{})
+	d.msg.values[d.field.Name] = result
+
+	return result
+}
+
+func (d *DynamicMap) Len() int {
+	switch values := d.msg.values[d.field.Name].(type) {
+	case map[string]interface{}:
+		return len(values)
+	case map[interface{}]interface{}:
+		return len(values)
+	}
+
+	return 0
+}
+
+// Get returns value by key. Message values are returned as *DynamicMessage. Lazy messages, which fail
+// to decode, are returned as is, so error is available from their Map.
+func (d *DynamicMap) Get(key interface{}) (interface{}, bool) {
+	str, err := d.key(key)
+	if err != nil {
+		return nil, false
+	}
+	value, ok := d.entries()[str]
+	if !ok {
+		return nil, false
+	}
+	if result, err := dynamicValue(value, d.field.Map.ValueType, fmt.Sprintf("%s[%s]", d.field.Name, str)); err == nil {
+		return result, true
+	}
+
+	return value, true
+}
+
+func (d *DynamicMap) Has(key interface{}) bool {
+	_, ok := d.Get(key)
+
+	return ok
+}
+
+func (d *DynamicMap) Set(key interface{}, value interface{}) error {
+	str, err := d.key(key)
+	if err != nil {
+		return err
+	}
+	value = unwrapDynamicMessages(value)
+	if value == nil && !isWellKnownType(d.field.Map.ValueType, wktValue) {
+		return newFieldError(fmt.Sprintf("%s[%s]", d.field.Name, str), protoTypeName(d.field.Map.ValueType), value, nil)
+	}
+	normalized, err := d.msg.normalize(d.field, map[string]interface{}{str: value})
+	if err != nil {
+		return err
+	}
+	d.entries()[str] = normalized.(map[string]interface{})[str]
+
+	return nil
+}
+
+func (d *DynamicMap) Delete(key interface{}) {
+	if str, err := d.key(key); err == nil {
+		delete(d.entries(), str)
+	}
+}
+
+// Range calls f for every entry with canonical string key, until f returns false. Order is unspecified.
+func (d *DynamicMap) Range(f func(key string, value interface{}) bool) {
+	for key := range d.entries() {
+		value, _ := d.Get(key)
+		if !f(key, value) {
+			return
+		}
+	}
+}
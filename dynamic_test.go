@@ -0,0 +1,273 @@
+package shprotos
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	full "github.com/saturn4er/shprotos/testdata"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDynamicMessage(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/full.proto", nil, nil)
+	require.NoError(t, err)
+	desc, ok := parsedFile.Message(TypeName{"ComplexMessage"})
+	require.True(t, ok)
+	simpleDesc, ok := parsedFile.Message(TypeName{"ComplexMessage", "SimpleMessage"})
+	require.True(t, ok)
+
+	msg := NewDynamicMessage(desc)
+	require.False(t, msg.Has("scalar_int32"))
+	value, err := msg.Get("scalar_int32")
+	require.NoError(t, err)
+	require.Equal(t, int32(0), value)
+	value, err = msg.Get("message")
+	require.NoError(t, err)
+	require.Nil(t, value)
+	_, err = msg.Get("missing")
+	require.Error(t, err)
+
+	require.NoError(t, msg.Set("scalar_int32", 10))
+	require.NoError(t, msg.SetByNumber(15, "hello"))
+	require.NoError(t, msg.Set("enum", "VALUE_A"))
+	require.True(t, msg.Has("scalar_int32"))
+	require.True(t, msg.HasByNumber(2))
+	value, err = msg.GetByNumber(15)
+	require.NoError(t, err)
+	require.Equal(t, "hello", value)
+	require.IsType(t, &FieldError{}, msg.Set("scalar_int32", "ten"))
+	require.Error(t, msg.Set("missing", 1))
+
+	// zero value of field without presence isn't considered set
+	require.NoError(t, msg.Set("scalar_bool", false))
+	require.False(t, msg.Has("scalar_bool"))
+
+	simple := NewDynamicMessage(simpleDesc)
+	require.NoError(t, simple.Set("some_field", 1))
+	require.NoError(t, msg.Set("message", simple))
+	require.True(t, msg.Has("message"))
+	value, err = msg.Get("message")
+	require.NoError(t, err)
+	nested := value.(*DynamicMessage)
+	require.NoError(t, nested.Set("some_field2", "nested"))
+	// values are copied on set, so source message stays untouched
+	require.False(t, simple.Has("some_field2"))
+
+	// setting oneof member clears other ones
+	require.NoError(t, msg.Set("oneof_scalar", 1))
+	require.NoError(t, msg.Set("oneof_enum", 2))
+	require.False(t, msg.Has("oneof_scalar"))
+	require.True(t, msg.Has("oneof_enum"))
+
+	list, err := msg.List("r_msg")
+	require.NoError(t, err)
+	require.Equal(t, 0, list.Len())
+	require.NoError(t, list.Append(simple, map[string]interface{}{"some_field": 2}))
+	require.Equal(t, 2, list.Len())
+	require.Equal(t, int32(1), list.Get(0).(*DynamicMessage).AsMap()["some_field"])
+	require.IsType(t, &FieldError{}, list.Append(1))
+	require.IsType(t, &FieldError{}, list.Set(1, "x"))
+	require.Error(t, list.Set(5, simple))
+	_, err = msg.List("scalar_int32")
+	require.Error(t, err)
+
+	mapField, err := msg.Map("map_scalar")
+	require.NoError(t, err)
+	require.NoError(t, mapField.Set(int32(-1), 5))
+	require.NoError(t, mapField.Set("10", int64(6)))
+	require.IsType(t, &FieldError{}, mapField.Set("x", 1))
+	require.IsType(t, &FieldError{}, mapField.Set(1, "x"))
+	require.Equal(t, 2, mapField.Len())
+	value, ok = mapField.Get(10)
+	require.True(t, ok)
+	require.Equal(t, int32(6), value)
+	require.True(t, mapField.Has("-1"))
+	mapField.Delete(-1)
+	require.False(t, mapField.Has(-1))
+	_, err = msg.Map("r_msg")
+	require.Error(t, err)
+
+	var fields []string
+	msg.Range(func(field Field, value interface{}) bool {
+		fields = append(fields, field.GetName())
+		return true
+	})
+	require.Equal(t, []string{"enum", "scalar_int32", "scalar_string", "message", "map_scalar", "r_msg", "oneof_enum"}, fields)
+
+	clone := msg.Clone()
+	data, err := msg.Marshal()
+	require.NoError(t, err)
+	require.NoError(t, msg.Clear("scalar_int32"))
+	require.NoError(t, msg.ClearByNumber(16))
+	require.False(t, msg.Has("message"))
+	require.True(t, clone.Has("scalar_int32"))
+	require.True(t, clone.Has("message"))
+
+	resultMsg := &full.ComplexMessage{}
+	require.NoError(t, proto.Unmarshal(data, resultMsg))
+	require.Equal(t, &full.ComplexMessage{
+		Enum:         full.ComplexMessage_VALUE_A,
+		ScalarInt32:  10,
+		ScalarString: "hello",
+		Message:      &full.ComplexMessage_SimpleMessage{SomeField: 1, SomeField2: "nested"},
+		MapScalar:    map[int32]int32{10: 6},
+		RMsg: []*full.ComplexMessage_SimpleMessage{
+			{SomeField: 1},
+			{SomeField: 2},
+		},
+		Oneof: &full.ComplexMessage_OneofEnum{OneofEnum: full.ComplexMessage_VALUE_B},
+	}, resultMsg)
+
+	unmarshalled := NewDynamicMessage(desc)
+	require.NoError(t, unmarshalled.Unmarshal(data))
+	value, err = unmarshalled.Get("scalar_int32")
+	require.NoError(t, err)
+	require.Equal(t, int32(10), value)
+	unmarshalled.Reset()
+	require.False(t, unmarshalled.Has("scalar_int32"))
+}
+
+func TestDynamicMessageDefaults(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/defaults.proto", nil, nil)
+	require.NoError(t, err)
+	desc, ok := parsedFile.Message(TypeName{"Defaults"})
+	require.True(t, ok)
+
+	msg := NewDynamicMessage(desc)
+	for name, expected := range map[string]interface{}{
+		"count":   int32(-5),
+		"mask":    uint64(16),
+		"ratio":   1.5,
+		"limit":   float32(math.Inf(1)),
+		"enabled": true,
+		"name":    "unnamed",
+		"payload": base64.StdEncoding.EncodeToString([]byte("raw")),
+		"color":   int32(2),
+		"plain":   int32(0),
+	} {
+		value, err := msg.Get(name)
+		require.NoError(t, err)
+		require.Equal(t, expected, value, name)
+		require.False(t, msg.Has(name))
+	}
+
+	require.NoError(t, msg.Set("count", 0))
+	require.True(t, msg.Has("count"))
+	value, err := msg.Get("count")
+	require.NoError(t, err)
+	require.Equal(t, int32(0), value)
+	require.IsType(t, &FieldError{}, msg.Set("color", 3))
+}
+
+func TestDynamicMessageLazy(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/full.proto", nil, nil)
+	require.NoError(t, err)
+	desc, ok := parsedFile.Message(TypeName{"ComplexMessage"})
+	require.True(t, ok)
+
+	data, err := proto.Marshal(&full.ComplexMessage{
+		Message: &full.ComplexMessage_SimpleMessage{SomeField: 1},
+		MapMsg:  map[string]*full.ComplexMessage_SimpleMessage{"a": {SomeField: 2}},
+		RMsg:    []*full.ComplexMessage_SimpleMessage{{SomeField: 3}},
+	})
+	require.NoError(t, err)
+	res, err := UnmarshalOptions{LazyMessages: true}.Unmarshal(data, desc)
+	require.NoError(t, err)
+	require.IsType(t, &LazyMessage{}, res["message"])
+	msg := DynamicMessageFromMap(desc, res)
+
+	value, err := msg.Get("message")
+	require.NoError(t, err)
+	require.IsType(t, &DynamicMessage{}, value)
+	require.Equal(t, int32(1), value.(*DynamicMessage).AsMap()["some_field"])
+	// decoded map is shared, so changes are marshalled
+	require.NoError(t, value.(*DynamicMessage).Set("some_field", 10))
+
+	list, err := msg.List("r_msg")
+	require.NoError(t, err)
+	require.IsType(t, &DynamicMessage{}, list.Get(0))
+	require.Equal(t, int32(3), list.Get(0).(*DynamicMessage).AsMap()["some_field"])
+
+	entries, err := msg.Map("map_msg")
+	require.NoError(t, err)
+	entry, ok := entries.Get("a")
+	require.True(t, ok)
+	require.IsType(t, &DynamicMessage{}, entry)
+	require.Equal(t, int32(2), entry.(*DynamicMessage).AsMap()["some_field"])
+
+	marshalled, err := MarshalMessage(msg.AsMap(), desc)
+	require.NoError(t, err)
+	decoded, err := UnmarshalMessage(marshalled, desc)
+	require.NoError(t, err)
+	require.Equal(t, int32(10), decoded["message"].(map[string]interface{})["some_field"])
+
+	// error of decoding is returned
+	res["message"] = NewLazyMessage([]byte{0x08}, res["message"].(*LazyMessage).Message(), UnmarshalOptions{})
+	_, err = msg.Get("message")
+	require.Error(t, err)
+}
+
+func TestDynamicMessageNormalize(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/wkt.proto", nil, []string{"./testdata"})
+	require.NoError(t, err)
+	desc, ok := parsedFile.Message(TypeName{"WellKnown"})
+	require.True(t, ok)
+
+	values := map[string]interface{}{
+		"timestamp":   time.Date(2019, 1, 2, 15, 4, 5, 500000000, time.UTC),
+		"duration":    90 * time.Second,
+		"int64_value": 7,
+		"bytes_value": []byte("data"),
+		"struct":      map[string]interface{}{"a": 1, "b": []interface{}{"x", true, nil}},
+		"value":       json.Number("2.5"),
+		"list":        []interface{}{map[string]interface{}{}},
+		"field_mask":  []string{"a.b", "c"},
+		"timestamps":  []interface{}{"2019-01-02T15:04:05Z"},
+		"values":      map[string]interface{}{"null": nil, "one": 1},
+	}
+	msg := NewDynamicMessage(desc)
+	for name, value := range values {
+		require.NoError(t, msg.Set(name, value), name)
+	}
+	data, err := MarshalMessage(values, desc)
+	require.NoError(t, err)
+	expected, err := UnmarshalMessage(data, desc)
+	require.NoError(t, err)
+	require.Equal(t, expected, msg.AsMap())
+
+	require.IsType(t, &FieldError{}, msg.Set("timestamps", []interface{}{"yesterday"}))
+	require.IsType(t, &FieldError{}, msg.Set("struct", 1))
+
+	anyFile, err := parser.Parse("./testdata/any.proto", nil, []string{"./testdata"})
+	require.NoError(t, err)
+	event, ok := anyFile.Message(TypeName{"Event"})
+	require.True(t, ok)
+	created, ok := anyFile.Message(TypeName{"Created"})
+	require.True(t, ok)
+	createdData, err := MarshalMessage(map[string]interface{}{"id": "1", "at": int64(10)}, created)
+	require.NoError(t, err)
+	values = map[string]interface{}{
+		"payload": map[string]interface{}{AnyTypeKey: "type.googleapis.com/example.any.Created", "id": "2", "at": 5},
+		"details": []interface{}{
+			map[string]interface{}{"type_url": "type.googleapis.com/example.any.Created", "value": createdData},
+		},
+	}
+	msg = NewDynamicMessage(event)
+	for name, value := range values {
+		require.NoError(t, msg.Set(name, value), name)
+	}
+	data, err = MarshalMessage(values, event)
+	require.NoError(t, err)
+	expected, err = UnmarshalMessage(data, event)
+	require.NoError(t, err)
+	require.Equal(t, expected, msg.AsMap())
+	require.Error(t, msg.Set("payload", map[string]interface{}{AnyTypeKey: "type.googleapis.com/example.any.Missing"}))
+}
@@ -1,9 +1,11 @@
 package shprotos
 
 import (
+	"math"
 	"strings"
 
 	"github.com/emicklei/proto"
+	"github.com/pkg/errors"
 )
 
 type Enum struct {
@@ -63,3 +65,51 @@ func (e Enum) GetFullName() string {
 
 	return e.file.PkgName + "." + strings.Join(e.TypeName, ".")
 }
+
+func (e Enum) ValueByName(name string) (*EnumValue, bool) {
+	for _, value := range e.Values {
+		if value.Name == name {
+			return value, true
+		}
+	}
+
+	return nil, false
+}
+
+// ValueByNumber returns the first enum value with given number, as several values may share it with allow_alias.
+func (e Enum) ValueByNumber(number int) (*EnumValue, bool) {
+	for _, value := range e.Values {
+		if value.Value == number {
+			return value, true
+		}
+	}
+
+	return nil, false
+}
+
+// IsClosed reports whether enum is declared in proto2 file, so fields of its type can't hold unknown numbers.
+func (e Enum) IsClosed() bool {
+	return e.file != nil && e.file.Syntax == SyntaxProto2
+}
+
+// resolveEnumValue returns number and name of enum value, given as a number or a name.
+// Name is empty for numbers, unknown to enum.
+func resolveEnumValue(value interface{}, enum *Enum) (int32, string, error) {
+	if name, ok := value.(string); ok {
+		if enumValue, ok := enum.ValueByName(name); ok {
+			return int32(enumValue.Value), enumValue.Name, nil
+		}
+	}
+	number, err := uint64FromInterface(value)
+	if err != nil {
+		return 0, "", errors.Errorf("unknown %s value %v", enum.Name, value)
+	}
+	if int64(number) < math.MinInt32 || int64(number) > math.MaxInt32 {
+		return 0, "", errors.Errorf("%s value %v overflows int32", enum.Name, value)
+	}
+	if enumValue, ok := enum.ValueByNumber(int(int32(number))); ok {
+		return int32(number), enumValue.Name, nil
+	}
+
+	return int32(number), "", nil
+}
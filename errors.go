@@ -0,0 +1,104 @@
+package shprotos
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldError is returned, when field value has Go type or value, which can't be used as field proto type.
+type FieldError struct {
+	// Path is a path to the field value, like r_msg[1].some_field.
+	Path string
+	// ProtoType is an expected proto type, like int32, repeated string or map<string, SimpleMessage>.
+	ProtoType string
+	// GoType is an actual Go type of the value.
+	GoType string
+	Err    error
+}
+
+func newFieldError(path string, protoType string, value interface{}, err error) *FieldError {
+	return &FieldError{
+		Path:      path,
+		ProtoType: protoType,
+		GoType:    fmt.Sprintf("%T", value),
+		Err:       err,
+	}
+}
+
+func (e *FieldError) Error() string {
+	msg := fmt.Sprintf("field %s: can't use %s as %s", e.Path, e.GoType, e.ProtoType)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+
+	return msg
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// UnknownTypeError is returned, when type URL of google.protobuf.Any value can't be resolved to a message.
+type UnknownTypeError struct {
+	TypeURL string
+}
+
+func (e *UnknownTypeError) Error() string {
+	return fmt.Sprintf("can't resolve message type %s", e.TypeURL)
+}
+
+// OneOfError is returned, when several members of the same oneof are set.
+type OneOfError struct {
+	// Path is a path to the message, which contains oneof. It's empty for root message.
+	Path   string
+	OneOf  string
+	Fields []string
+}
+
+func (e *OneOfError) Error() string {
+	oneOf := e.OneOf
+	if e.Path != "" {
+		oneOf = e.Path + "." + oneOf
+	}
+
+	return fmt.Sprintf("oneof %s has several fields set: %s", oneOf, strings.Join(e.Fields, ", "))
+}
+
+// DepthLimitError is returned, when nesting of unmarshalled messages exceeds UnmarshalOptions.MaxDepth.
+type DepthLimitError struct {
+	Limit int
+}
+
+func (e *DepthLimitError) Error() string {
+	return fmt.Sprintf("message nesting exceeds limit of %d", e.Limit)
+}
+
+// SizeLimitError is returned, when length of unmarshalled data exceeds UnmarshalOptions.MaxSize.
+type SizeLimitError struct {
+	Size  int
+	Limit int
+}
+
+func (e *SizeLimitError) Error() string {
+	return fmt.Sprintf("data size %d exceeds limit of %d", e.Size, e.Limit)
+}
+
+// ElementsLimitError is returned, when repeated or map field has more elements, than UnmarshalOptions.MaxElements.
+type ElementsLimitError struct {
+	Field string
+	Limit int
+}
+
+func (e *ElementsLimitError) Error() string {
+	return fmt.Sprintf("field %s has more than %d elements", e.Field, e.Limit)
+}
+
+// LengthLimitError is returned, when string or bytes value is longer, than UnmarshalOptions.MaxLength.
+type LengthLimitError struct {
+	Length uint64
+	Limit  int
+}
+
+func (e *LengthLimitError) Error() string {
+	return fmt.Sprintf("value length %d exceeds limit of %d", e.Length, e.Limit)
+}
@@ -2,21 +2,30 @@ package shprotos
 
 import (
 	"strings"
+	"sync"
 
 	"github.com/emicklei/proto"
 	"github.com/pkg/errors"
 )
 
+const (
+	SyntaxProto2 = "proto2"
+	SyntaxProto3 = "proto3"
+)
+
 type File struct {
 	GoPackage   string
 	FilePath    string
 	protoFile   *proto.Proto
 	PkgName     string
+	Syntax      string
 	Services    []*Service
 	Messages    []*Message
 	Enums       []*Enum
 	Imports     []*File
 	Descriptors map[string]Type
+	reflectOnce sync.Once
+	reflect     reflectDescriptors
 }
 
 func (f *File) Message(typename TypeName) (*Message, bool) {
@@ -29,6 +38,17 @@ func (f *File) Message(typename TypeName) (*Message, bool) {
 	return nil, false
 }
 
+// FindMessageByName looks up message by its full name, like "pkg.Message.Nested", in file and its imports.
+func (f *File) FindMessageByName(fullName string) (*Message, bool) {
+	symbol, ok := f.findSymbol(fullName)
+	if !ok {
+		return nil, false
+	}
+	msg, ok := symbol.(*Message)
+
+	return msg, ok
+}
+
 func (f *File) parseGoPackage() {
 	for _, el := range f.protoFile.Elements {
 		option, ok := el.(*proto.Option)
@@ -43,8 +63,8 @@ func (f *File) parseGoPackage() {
 }
 
 func (f *File) findTypeInMessage(msg *Message, typ string) (Type, bool) {
-	if typeIsScalar(typ) {
-		return &Scalar{ScalarName: typ, file: f}, true
+	if scalar, ok := ScalarByName(typ); ok {
+		return scalar, true
 	}
 
 	return f.findType(typ, msg.GetFullName())
@@ -111,6 +131,19 @@ func (f *File) findType(name string, relativeToFullName string) (Type, bool) {
 	return result, ok
 }
 
+func (f *File) findMethodMessage(typeName string) (*Message, error) {
+	typ, ok := f.findType(typeName, f.PkgName)
+	if !ok {
+		return nil, errors.Errorf("can't find message %s", typeName)
+	}
+	msg, ok := typ.(*Message)
+	if !ok {
+		return nil, errors.Errorf("%s is not a message", typ)
+	}
+
+	return msg, nil
+}
+
 func (f *File) parseServices() error {
 	for _, el := range f.protoFile.Elements {
 		service, ok := el.(*proto.Service)
@@ -121,28 +154,30 @@ func (f *File) parseServices() error {
 			Name:          service.Name,
 			QuotedComment: quoteComment(service.Comment, nil),
 			File:          f,
+			Descriptor:    service,
 		}
 		for _, el := range service.Elements {
 			method, ok := el.(*proto.RPC)
 			if !ok {
 				continue
 			}
-			reqTyp, ok := f.findType(method.RequestType, f.PkgName)
-			if !ok {
-				return errors.Errorf("can't find request message %s", method.RequestType)
+			inputMessage, err := f.findMethodMessage(method.RequestType)
+			if err != nil {
+				return errors.Wrapf(err, "failed to resolve %s.%s request type", service.Name, method.Name)
 			}
-			retTyp, ok := f.findType(method.ReturnsType, f.PkgName)
-			if !ok {
-				return errors.Errorf("can't find request message %s", method.RequestType)
+			outputMessage, err := f.findMethodMessage(method.ReturnsType)
+			if err != nil {
+				return errors.Wrapf(err, "failed to resolve %s.%s response type", service.Name, method.Name)
 			}
 			mtd := &Method{
 				Name:           method.Name,
 				QuotedComment:  quoteComment(method.Comment, method.InlineComment),
-				InputMessage:   reqTyp.(*Message),
-				OutputMessage:  retTyp.(*Message),
+				InputMessage:   inputMessage,
+				OutputMessage:  outputMessage,
 				StreamRequest:  method.StreamsRequest,
 				StreamResponse: method.StreamsReturns,
 				Service:        srv,
+				Descriptor:     method,
 			}
 			srv.Methods = append(srv.Methods, mtd)
 		}
@@ -152,6 +187,22 @@ func (f *File) parseServices() error {
 	return nil
 }
 
+// parseServicesHTTPRules parses google.api.http rules of methods. Invalid rule doesn't fail parsing of file,
+// it's kept in HTTPRuleErr of its method.
+func (f *File) parseServicesHTTPRules() {
+	for _, srv := range f.Services {
+		for _, method := range srv.Methods {
+			literal, ok, err := httpRuleLiteral(method.GetOptions())
+			if err == nil && ok {
+				method.HTTPRule, err = parseHTTPRule(literal, method, false)
+			}
+			if err != nil {
+				method.HTTPRuleErr = errors.Wrapf(err, "failed to parse %s.%s http rule", srv.Name, method.Name)
+			}
+		}
+	}
+}
+
 func (f *File) parseMessagesFields() error {
 	for _, msg := range f.Messages {
 		for _, el := range msg.Descriptor.Elements {
@@ -170,6 +221,7 @@ func (f *File) parseMessagesFields() error {
 					Required:      fld.Required,
 					descriptor:    fld.Field,
 					Type:          typ,
+					message:       msg,
 				}
 				msg.NormalFields = append(msg.NormalFields, fl)
 			case *proto.MapField:
@@ -177,6 +229,9 @@ func (f *File) parseMessagesFields() error {
 				if !ok {
 					return errors.Errorf("failed to find message %s field %s type", strings.Join(msg.TypeName, "."), fld.Name)
 				}
+				if !mapKeyTypeIsValid(ktyp) {
+					return errors.Errorf("message %s map field %s has invalid key type %s", strings.Join(msg.TypeName, "."), fld.Name, ktyp)
+				}
 				vtyp, ok := f.findTypeInMessage(msg, fld.Type)
 				if !ok {
 					return errors.Errorf("failed to find message %s field %s type", strings.Join(msg.TypeName, "."), fld.Name)
@@ -186,6 +241,7 @@ func (f *File) parseMessagesFields() error {
 					KeyType:   ktyp,
 					ValueType: vtyp,
 					Field:     fld,
+					Entry:     mapEntryMessage(f, fld, ktyp, vtyp, msg),
 					file:      f,
 				}
 				mf := &MapField{
@@ -194,6 +250,7 @@ func (f *File) parseMessagesFields() error {
 					QuotedComment: quoteComment(fld.Comment, fld.InlineComment),
 					descriptor:    fld,
 					Map:           mp,
+					message:       msg,
 				}
 				msg.MapFields = append(msg.MapFields, mf)
 			case *proto.Oneof:
@@ -217,6 +274,7 @@ func (f *File) parseMessagesFields() error {
 						descriptor:    fld.Field,
 						Type:          typ,
 						OneOf:         of,
+						message:       msg,
 					})
 				}
 				msg.OneOffs = append(msg.OneOffs, of)
@@ -5,8 +5,9 @@ go 1.12
 require (
 	github.com/davecgh/go-spew v1.1.0
 	github.com/emicklei/proto v1.6.11
-	github.com/golang/protobuf v1.3.1
+	github.com/golang/protobuf v1.5.0
 	github.com/pkg/errors v0.8.1
 	github.com/smartystreets/goconvey v0.0.0-20190330032615-68dc04aab96a
 	github.com/stretchr/testify v1.3.0
+	google.golang.org/protobuf v1.28.1
 )
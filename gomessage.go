@@ -0,0 +1,427 @@
+package shprotos
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ToGoMessage fills generated Go message with value of msg, without encoding it to bytes.
+func ToGoMessage(data map[string]interface{}, msg *Message, dst proto.Message) error {
+	return MarshalOptions{}.ToGoMessage(data, msg, dst)
+}
+
+// ToGoMessage resets dst and fills it with value of msg. Fields are matched with struct fields by key numbers
+// of their protobuf struct tags. Values are accepted in the same forms as Marshal does.
+func (o MarshalOptions) ToGoMessage(data map[string]interface{}, msg *Message, dst proto.Message) error {
+	if o.Resolver == nil {
+		o.Resolver = defaultResolver(msg)
+	}
+	target := reflect.ValueOf(dst)
+	if target.Kind() != reflect.Ptr || target.IsNil() || target.Elem().Kind() != reflect.Struct {
+		return errors.Errorf("can't convert %s to %T", msg.GetFullName(), dst)
+	}
+	dst.Reset()
+
+	return o.toGoStruct(data, msg, target.Elem(), "")
+}
+
+func (o MarshalOptions) toGoStruct(data map[string]interface{}, msg *Message, target reflect.Value, path string) error {
+	skipFields, err := o.resolveOneOfs(data, msg, path)
+	if err != nil {
+		return err
+	}
+	props := proto.GetProperties(target.Type())
+	for _, field := range msg.GetFields() {
+		value, ok := data[field.GetName()]
+		if !ok || (value == nil && !holdsNullValue(field)) || skipFields[field.GetName()] {
+			continue
+		}
+		fieldPath := joinFieldPath(path, field.GetName())
+		index, oneOf, ok := goStructField(props, field)
+		if !ok {
+			return errors.Errorf("%s has no field for %s", target.Type(), fieldPath)
+		}
+		fieldTarget := target.Field(index)
+		if oneOf != nil {
+			wrapper := reflect.New(oneOf.Type.Elem())
+			fieldTarget.Set(wrapper)
+			fieldTarget = wrapper.Elem().Field(0)
+		}
+		switch fld := field.(type) {
+		case *NormalField:
+			if fld.Repeated {
+				err = o.toGoSlice(value, fld.Type, fieldTarget, fieldPath)
+			} else {
+				err = o.toGoValue(value, fld.Type, fieldTarget, fieldPath)
+			}
+		case *MapField:
+			err = o.toGoMap(value, fld.Map, fieldTarget, fieldPath)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if unknown, ok := data[UnknownFieldsKey]; ok {
+		raw, err := bytesFromInterface(unknown)
+		if err != nil {
+			return errors.Wrap(err, "failed to resolve unknown fields")
+		}
+		setGoUnknownFields(target, append([]byte(nil), raw...))
+	}
+
+	return nil
+}
+
+// goMessageOf returns generated message of addressable struct value.
+func goMessageOf(value reflect.Value) (proto.Message, bool) {
+	if !value.CanAddr() {
+		return nil, false
+	}
+	msg, ok := value.Addr().Interface().(proto.Message)
+
+	return msg, ok
+}
+
+// setGoUnknownFields stores unknown fields of generated struct through protobuf reflection, so both
+// XXX_unrecognized of older generated code and unknownFields of newer one are filled.
+// XXX_unrecognized is set directly only for structs, which aren't messages.
+func setGoUnknownFields(target reflect.Value, raw []byte) {
+	if msg, ok := goMessageOf(target); ok {
+		proto.MessageReflect(msg).SetUnknown(raw)
+		return
+	}
+	if unrecognized := target.FieldByName("XXX_unrecognized"); unrecognized.IsValid() && unrecognized.CanSet() {
+		unrecognized.SetBytes(raw)
+	}
+}
+
+// goUnknownFields returns unknown fields of generated struct, the same way as setGoUnknownFields stores them.
+func goUnknownFields(source reflect.Value) []byte {
+	if msg, ok := goMessageOf(source); ok {
+		return proto.MessageReflect(msg).GetUnknown()
+	}
+	if unrecognized := source.FieldByName("XXX_unrecognized"); unrecognized.IsValid() {
+		return unrecognized.Bytes()
+	}
+
+	return nil
+}
+
+func (o MarshalOptions) toGoSlice(value interface{}, typ Type, target reflect.Value, path string) error {
+	values := reflect.ValueOf(value)
+	if values.Kind() != reflect.Slice && values.Kind() != reflect.Array {
+		return newFieldError(path, "repeated "+protoTypeName(typ), value, nil)
+	}
+	if target.Kind() != reflect.Slice {
+		return errors.Errorf("can't assign repeated %s to %s at %s", protoTypeName(typ), target.Type(), path)
+	}
+	result := reflect.MakeSlice(target.Type(), values.Len(), values.Len())
+	for i := 0; i < values.Len(); i++ {
+		elemPath := fmt.Sprintf("%s[%d]", path, i)
+		if err := o.toGoValue(values.Index(i).Interface(), typ, result.Index(i), elemPath); err != nil {
+			return err
+		}
+	}
+	target.Set(result)
+
+	return nil
+}
+
+func (o MarshalOptions) toGoMap(value interface{}, typ *Map, target reflect.Value, path string) error {
+	mapValue := reflect.ValueOf(value)
+	if mapValue.Kind() != reflect.Map {
+		return newFieldError(path, protoTypeName(typ), value, nil)
+	}
+	if target.Kind() != reflect.Map {
+		return errors.Errorf("can't assign %s to %s at %s", protoTypeName(typ), target.Type(), path)
+	}
+	result := reflect.MakeMapWithSize(target.Type(), mapValue.Len())
+	for _, key := range mapValue.MapKeys() {
+		entryPath := fmt.Sprintf("%s[%v]", path, key.Interface())
+		goKey := reflect.New(target.Type().Key()).Elem()
+		if err := o.toGoValue(key.Interface(), typ.KeyType, goKey, entryPath); err != nil {
+			return err
+		}
+		goValue := reflect.New(target.Type().Elem()).Elem()
+		// nil google.protobuf.Value is a null value, not a missing one
+		if entry := mapValue.MapIndex(key).Interface(); entry != nil || isWellKnownType(typ.ValueType, wktValue) {
+			if err := o.toGoValue(entry, typ.ValueType, goValue, entryPath); err != nil {
+				return err
+			}
+		}
+		result.SetMapIndex(goKey, goValue)
+	}
+	target.Set(result)
+
+	return nil
+}
+
+// toGoValue sets single value of given type to target. Pointer targets, like proto2 optional scalars,
+// are allocated.
+func (o MarshalOptions) toGoValue(value interface{}, typ Type, target reflect.Value, path string) error {
+	var goValue interface{}
+	switch typ := typ.(type) {
+	case *Scalar:
+		scalar, err := scalarValue(value, typ.scalarKind())
+		if err != nil {
+			return newFieldError(path, protoTypeName(typ), value, err)
+		}
+		goValue = scalar
+	case *Enum:
+		number, name, err := resolveEnumValue(value, typ)
+		if err != nil {
+			return newFieldError(path, protoTypeName(typ), value, err)
+		}
+		if name == "" && typ.IsClosed() {
+			return newFieldError(path, protoTypeName(typ), value, errors.Errorf("unknown %s value %d", typ.Name, number))
+		}
+		goValue = number
+	case *Message:
+		data, err := o.messageData(value, typ, path)
+		if err != nil {
+			return err
+		}
+		if target.Kind() != reflect.Ptr || target.Type().Elem().Kind() != reflect.Struct {
+			return errors.Errorf("can't assign %s to %s at %s", protoTypeName(typ), target.Type(), path)
+		}
+		msgValue := reflect.New(target.Type().Elem())
+		if err := o.toGoStruct(data, typ, msgValue.Elem(), path); err != nil {
+			return err
+		}
+		target.Set(msgValue)
+		return nil
+	}
+	if err := assignGoValue(target, goValue); err != nil {
+		return errors.Wrapf(err, "failed to assign %s", path)
+	}
+
+	return nil
+}
+
+// assignGoValue converts scalar or enum value to type of target, which may be a named type, like generated enums.
+func assignGoValue(target reflect.Value, value interface{}) error {
+	if target.Kind() == reflect.Ptr {
+		ptr := reflect.New(target.Type().Elem())
+		if err := assignGoValue(ptr.Elem(), value); err != nil {
+			return err
+		}
+		target.Set(ptr)
+		return nil
+	}
+	goValue := reflect.ValueOf(value)
+	if goValue.Kind() != target.Kind() {
+		return errors.Errorf("can't assign %T to %s", value, target.Type())
+	}
+	target.Set(goValue.Convert(target.Type()))
+
+	return nil
+}
+
+// FromGoMessage converts generated Go message to value of msg, without encoding it to bytes.
+func FromGoMessage(src proto.Message, msg *Message) (map[string]interface{}, error) {
+	return UnmarshalOptions{}.FromGoMessage(src, msg)
+}
+
+// FromGoMessage converts generated Go message to the same value, as Unmarshal returns for its encoded bytes.
+// Fields are matched with struct fields by key numbers of their protobuf struct tags. Struct fields,
+// missing in msg, are skipped.
+func (o UnmarshalOptions) FromGoMessage(src proto.Message, msg *Message) (map[string]interface{}, error) {
+	if o.Resolver == nil {
+		o.Resolver = defaultResolver(msg)
+	}
+	source := reflect.ValueOf(src)
+	if source.Kind() != reflect.Ptr || source.Type().Elem().Kind() != reflect.Struct {
+		return nil, errors.Errorf("can't convert %T to %s", src, msg.GetFullName())
+	}
+	result := make(map[string]interface{})
+	if !source.IsNil() {
+		var err error
+		if result, err = o.fromGoStruct(source.Elem(), msg); err != nil {
+			return nil, err
+		}
+	}
+	if o.EmitDefaults {
+		o.populateDefaults(result, msg, nil, nil)
+	}
+
+	return result, nil
+}
+
+func (o UnmarshalOptions) fromGoStruct(source reflect.Value, msg *Message) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	props := proto.GetProperties(source.Type())
+	for _, field := range msg.GetFields() {
+		index, oneOf, ok := goStructField(props, field)
+		if !ok {
+			continue
+		}
+		fieldSource := source.Field(index)
+		if oneOf != nil {
+			if fieldSource.IsNil() || fieldSource.Elem().Type() != oneOf.Type {
+				continue
+			}
+			fieldSource = fieldSource.Elem().Elem().Field(0)
+		} else if isZeroValue(fieldSource) {
+			continue
+		}
+		switch fld := field.(type) {
+		case *NormalField:
+			if fld.Repeated {
+				for i := 0; i < fieldSource.Len(); i++ {
+					value, ok, err := o.fromGoValue(fieldSource.Index(i), fld.Type)
+					if err != nil {
+						return nil, errors.Wrapf(err, "failed to convert field %s", fld.Name)
+					}
+					if !ok {
+						o.keepUnknownField(result, messageKeyVarint(fld.KeyNumber, WireTypeVarint), proto.EncodeVarint(uint64(value.(int32))))
+						continue
+					}
+					o.appendRepeatedValue(result, fld, value)
+				}
+				continue
+			}
+			if fieldSource.Kind() == reflect.Ptr && fieldSource.IsNil() {
+				continue
+			}
+			value, ok, err := o.fromGoValue(fieldSource, fld.Type)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to convert field %s", fld.Name)
+			}
+			if !ok {
+				o.keepUnknownField(result, messageKeyVarint(fld.KeyNumber, WireTypeVarint), proto.EncodeVarint(uint64(value.(int32))))
+				continue
+			}
+			result[fld.Name] = value
+			if fld.OneOf != nil {
+				o.setOneOfCase(result, fld)
+			}
+		case *MapField:
+			if err := o.fromGoMap(result, fieldSource, fld); err != nil {
+				return nil, errors.Wrapf(err, "failed to convert field %s", fld.Name)
+			}
+		}
+	}
+	if o.KeepUnknownFields {
+		if raw := goUnknownFields(source); len(raw) > 0 {
+			unknown, _ := result[UnknownFieldsKey].([]byte)
+			result[UnknownFieldsKey] = append(unknown, raw...)
+		}
+	}
+
+	return result, nil
+}
+
+func (o UnmarshalOptions) fromGoMap(result map[string]interface{}, source reflect.Value, fld *MapField) error {
+	for _, key := range source.MapKeys() {
+		mapKey, _, err := o.fromGoValue(key, fld.Map.KeyType)
+		if err != nil {
+			return errors.Wrap(err, "failed to convert map key")
+		}
+		mapValue, ok, err := o.fromGoValue(source.MapIndex(key), fld.Map.ValueType)
+		if err != nil {
+			return errors.Wrap(err, "failed to convert map value")
+		}
+		if !ok {
+			entry := protowire.AppendVarint(nil, messageKeyVarint(1, typeWireType(fld.Map.KeyType)))
+			entry, err := (&encoder{}).appendValue(entry, mapKey, fld.Map.KeyType)
+			if err != nil {
+				return errors.Wrap(err, "failed to encode unknown map entry")
+			}
+			entry = protowire.AppendVarint(entry, messageKeyVarint(2, WireTypeVarint))
+			entry = protowire.AppendVarint(entry, uint64(mapValue.(int32)))
+			unknownKey := messageKeyVarint(fld.KeyNumber, WireTypeLengthDelimited)
+			o.keepUnknownField(result, unknownKey, protowire.AppendVarint(nil, uint64(len(entry))), entry)
+			continue
+		}
+		if o.TypedMapKeys {
+			resultMap, ok := result[fld.Name].(map[interface{}]interface{})
+			if !ok {
+				resultMap = make(map[interface{}]interface{})
+				result[fld.Name] = resultMap
+			}
+			resultMap[mapKey] = mapValue
+		} else {
+			resultMap, ok := result[fld.Name].(map[string]interface{})
+			if !ok {
+				resultMap = make(map[string]interface{})
+				result[fld.Name] = resultMap
+			}
+			resultMap[fmt.Sprint(mapKey)] = mapValue
+		}
+	}
+
+	return nil
+}
+
+// fromGoValue converts single Go value of given type. Like enumValue, it reports false for numbers,
+// unknown to closed enum.
+func (o UnmarshalOptions) fromGoValue(source reflect.Value, typ Type) (interface{}, bool, error) {
+	if msg, ok := typ.(*Message); ok {
+		data := map[string]interface{}{}
+		if source.Kind() != reflect.Ptr || source.Type().Elem().Kind() != reflect.Struct {
+			return nil, false, errors.Errorf("can't convert %s to %s", source.Type(), protoTypeName(typ))
+		}
+		if !source.IsNil() {
+			var err error
+			if data, err = o.fromGoStruct(source.Elem(), msg); err != nil {
+				return nil, false, err
+			}
+		}
+		value, err := o.messageValue(data, msg, 1)
+		if err != nil {
+			return nil, false, err
+		}
+		return value, true, nil
+	}
+	if source.Kind() == reflect.Ptr {
+		source = source.Elem()
+	}
+	switch typ := typ.(type) {
+	case *Scalar:
+		goType := typ.scalarKind().GoType()
+		if source.Kind() != goType.Kind() || !source.Type().ConvertibleTo(goType) {
+			return nil, false, errors.Errorf("can't convert %s to %s", source.Type(), protoTypeName(typ))
+		}
+		if typ.scalarKind() == ScalarBytes && o.AliasBytes {
+			return source.Bytes(), true, nil
+		}
+		if typ.scalarKind() == ScalarBytes {
+			return base64.StdEncoding.EncodeToString(source.Bytes()), true, nil
+		}
+		return source.Convert(goType).Interface(), true, nil
+	case *Enum:
+		if source.Kind() != reflect.Int32 {
+			return nil, false, errors.Errorf("can't convert %s to %s", source.Type(), protoTypeName(typ))
+		}
+		value, ok := o.enumValue(int32(source.Int()), typ)
+		return value, ok, nil
+	}
+
+	return nil, false, errors.Errorf("unexpected field type %s", typ)
+}
+
+// goStructField looks up index of struct field, generated for field, by key number in its protobuf struct tag.
+// For oneof members it returns index of oneof interface field and properties of member wrapper type.
+func goStructField(props *proto.StructProperties, field Field) (int, *proto.OneofProperties, bool) {
+	number := int(field.GetKeyNumber())
+	if field.GetOneOf() != nil {
+		for _, oneOf := range props.OneofTypes {
+			if oneOf.Prop.Tag == number {
+				return oneOf.Field, oneOf, true
+			}
+		}
+		return 0, nil, false
+	}
+	for i, prop := range props.Prop {
+		if prop.Tag == number {
+			return i, nil, true
+		}
+	}
+
+	return 0, nil, false
+}
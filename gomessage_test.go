@@ -0,0 +1,161 @@
+package shprotos
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	full "github.com/saturn4er/shprotos/testdata"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGoMessage(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/full.proto", nil, nil)
+	require.NoError(t, err)
+	desc, ok := parsedFile.Message(TypeName{"ComplexMessage"})
+	require.True(t, ok)
+
+	messages := []*full.ComplexMessage{
+		{
+			Enum:          full.ComplexMessage_VALUE_B,
+			ScalarInt32:   -5,
+			ScalarUint64:  1 << 63,
+			ScalarSint64:  -1 << 40,
+			ScalarFixed32: 7,
+			ScalarDouble:  1.5,
+			ScalarFloat:   -2.5,
+			ScalarBool:    true,
+			ScalarString:  "hello",
+			Message:       &full.ComplexMessage_SimpleMessage{SomeField: 1, SomeField2: "nested"},
+			Bytes:         []byte{0, 1, 2},
+			MapEnum:       map[int32]full.ComplexMessage_SimpleEnum{1: full.ComplexMessage_VALUE_A, -2: full.ComplexMessage_VALUE_B},
+			MapScalar:     map[int32]int32{10: 6},
+			MapMsg:        map[string]*full.ComplexMessage_SimpleMessage{"a": {SomeField: 3}},
+			MapBytes:      map[string][]byte{"b": {4, 5}},
+			MapString:     map[string]string{"c": "d"},
+			REnum:         []full.ComplexMessage_SimpleEnum{full.ComplexMessage_VALUE_B, full.ComplexMessage_VALUE_A},
+			RScalar:       []int32{1, -1, 3},
+			RMsg:          []*full.ComplexMessage_SimpleMessage{{SomeField: 1}, {SomeField2: "x"}},
+			RBytes:        [][]byte{{6}, {7, 8}},
+			Oneof:         &full.ComplexMessage_OneofEnum{OneofEnum: full.ComplexMessage_VALUE_A},
+		},
+		{Oneof: &full.ComplexMessage_OneofMessage{OneofMessage: &full.ComplexMessage_SimpleMessage{SomeField: 2}}},
+		{Oneof: &full.ComplexMessage_OneofScalar{}},
+		{},
+	}
+	optionSets := []UnmarshalOptions{
+		{},
+		{EnumsAsNames: true, TypedMapKeys: true, ReportOneOfCases: true},
+		{EmitDefaults: true},
+	}
+	for _, goMsg := range messages {
+		encoded, err := proto.Marshal(goMsg)
+		require.NoError(t, err)
+		for _, options := range optionSets {
+			expected, err := options.Unmarshal(encoded, desc)
+			require.NoError(t, err)
+			data, err := options.FromGoMessage(goMsg, desc)
+			require.NoError(t, err)
+			require.Equal(t, expected, data)
+
+			result := &full.ComplexMessage{ScalarInt64: 100}
+			require.NoError(t, ToGoMessage(data, desc, result))
+			require.Equal(t, goMsg.String(), result.String())
+		}
+	}
+
+	// values are accepted in the same forms as MarshalMessage accepts
+	result := &full.ComplexMessage{}
+	err = ToGoMessage(map[string]interface{}{
+		"enum":         "VALUE_B",
+		"scalar_int32": 5,
+		"bytes":        []byte{1},
+		"map_scalar":   map[int]int{1: 2},
+		"r_enum":       []interface{}{"VALUE_B", 1},
+		"oneof_scalar": "7",
+	}, desc, result)
+	require.NoError(t, err)
+	require.Equal(t, &full.ComplexMessage{
+		Enum:        full.ComplexMessage_VALUE_B,
+		ScalarInt32: 5,
+		Bytes:       []byte{1},
+		MapScalar:   map[int32]int32{1: 2},
+		REnum:       []full.ComplexMessage_SimpleEnum{full.ComplexMessage_VALUE_B, full.ComplexMessage_VALUE_A},
+		Oneof:       &full.ComplexMessage_OneofScalar{OneofScalar: 7},
+	}, result)
+
+	err = ToGoMessage(map[string]interface{}{"r_msg": []interface{}{map[string]interface{}{"some_field": "x"}}}, desc, result)
+	require.IsType(t, &FieldError{}, err)
+	require.Equal(t, "r_msg[0].some_field", err.(*FieldError).Path)
+	err = ToGoMessage(map[string]interface{}{"oneof_scalar": 1, "oneof_enum": 1}, desc, result)
+	require.IsType(t, &OneOfError{}, err)
+	err = ToGoMessage(map[string]interface{}{"scalar_int32": 1}, desc, &structpb.Struct{})
+	require.Error(t, err)
+}
+
+func TestGoMessageWellKnownTypes(t *testing.T) {
+	parser := Parser{}
+	_, err := parser.Parse("./testdata/wkt.proto", nil, []string{"./testdata"})
+	require.NoError(t, err)
+	desc, ok := parser.FindMessageByName("google.protobuf.Struct")
+	require.True(t, ok)
+
+	goMsg := &structpb.Struct{Fields: map[string]*structpb.Value{
+		"a": {Kind: &structpb.Value_NumberValue{NumberValue: 1}},
+		"b": {Kind: &structpb.Value_ListValue{ListValue: &structpb.ListValue{Values: []*structpb.Value{
+			{Kind: &structpb.Value_StringValue{StringValue: "x"}},
+			{Kind: &structpb.Value_NullValue{}},
+		}}}},
+		"c": {Kind: &structpb.Value_StructValue{StructValue: &structpb.Struct{Fields: map[string]*structpb.Value{
+			"d": {Kind: &structpb.Value_BoolValue{BoolValue: true}},
+		}}}},
+	}}
+	data, err := FromGoMessage(goMsg, desc)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"fields": map[string]interface{}{
+			"a": float64(1),
+			"b": []interface{}{"x", nil},
+			"c": map[string]interface{}{"d": true},
+		},
+	}, data)
+
+	result := &structpb.Struct{}
+	require.NoError(t, ToGoMessage(data, desc, result))
+	require.True(t, proto.Equal(goMsg, result))
+}
+
+func TestGoMessageUnknownFields(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/full.proto", nil, nil)
+	require.NoError(t, err)
+	simpleDesc, ok := parsedFile.Message(TypeName{"ComplexMessage", "SimpleMessage"})
+	require.True(t, ok)
+	_, err = parser.Parse("./testdata/wkt.proto", nil, []string{"./testdata"})
+	require.NoError(t, err)
+	timestampDesc, ok := parser.FindMessageByName("google.protobuf.Timestamp")
+	require.True(t, ok)
+
+	// field 15 with varint 1, unknown to both messages
+	unknown := []byte{0x78, 0x01}
+	for _, test := range []struct {
+		desc  *Message
+		data  map[string]interface{}
+		goMsg proto.Message
+	}{
+		// generated with XXX_unrecognized
+		{simpleDesc, map[string]interface{}{"some_field": int32(1)}, &full.ComplexMessage_SimpleMessage{}},
+		// generated with unknownFields
+		{timestampDesc, map[string]interface{}{"seconds": int64(1)}, &timestamp.Timestamp{}},
+	} {
+		test.data[UnknownFieldsKey] = unknown
+		require.NoError(t, ToGoMessage(test.data, test.desc, test.goMsg))
+		require.Equal(t, unknown, []byte(proto.MessageReflect(test.goMsg).GetUnknown()))
+
+		result, err := UnmarshalOptions{KeepUnknownFields: true}.FromGoMessage(test.goMsg, test.desc)
+		require.NoError(t, err)
+		require.Equal(t, test.data, result)
+	}
+}
@@ -7,15 +7,6 @@ import (
 	"github.com/emicklei/proto"
 )
 
-func typeIsScalar(typ string) bool {
-	switch typ {
-	case "double", "float", "int32", "int64", "uint32", "uint64", "sint32", "sint64", "fixed32", "fixed64", "sfixed32", "sfixed64", "bool", "string", "bytes":
-		return true
-	}
-
-	return false
-}
-
 func quoteComment(comment *proto.Comment, inlineComment *proto.Comment) string {
 	var lines []string
 
@@ -34,6 +25,16 @@ func quoteComment(comment *proto.Comment, inlineComment *proto.Comment) string {
 	return strconv.Quote(strings.TrimSpace(strings.Join(lines, "\n")))
 }
 
+func resolveFileSyntax(file *proto.Proto) string {
+	for _, el := range file.Elements {
+		if s, ok := el.(*proto.Syntax); ok {
+			return s.Value
+		}
+	}
+
+	return SyntaxProto2
+}
+
 func resolveFilePkgName(file *proto.Proto) string {
 	for _, el := range file.Elements {
 		if p, ok := el.(*proto.Package); ok {
@@ -56,3 +57,137 @@ func message(file *File, msg *proto.Message, typeName []string, parent *Message)
 
 	return m
 }
+
+func findOption(options []*proto.Option, name string) (*proto.Option, bool) {
+	for _, option := range options {
+		if option.Name == name {
+			return option, true
+		}
+	}
+
+	return nil, false
+}
+
+func optionIsTrue(options []*proto.Option, name string) bool {
+	option, ok := findOption(options, name)
+
+	return ok && option.Constant.Source == "true"
+}
+
+// fieldJSONName returns json_name option value or lowerCamelCase field name the same way protoc does.
+func fieldJSONName(name string, options []*proto.Option) string {
+	if option, ok := findOption(options, "json_name"); ok {
+		return option.Constant.Source
+	}
+
+	return lowerCamelCase(name)
+}
+
+func lowerCamelCase(name string) string {
+	var result strings.Builder
+
+	upperNext := false
+
+	for _, r := range name {
+		if r == '_' {
+			upperNext = true
+			continue
+		}
+		if upperNext && r >= 'a' && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		upperNext = false
+		result.WriteRune(r)
+	}
+
+	return result.String()
+}
+
+// snakeCase reverts lowerCamelCase conversion, like "fooBar" to "foo_bar".
+func snakeCase(name string) string {
+	var result strings.Builder
+
+	for _, r := range name {
+		if r >= 'A' && r <= 'Z' {
+			result.WriteByte('_')
+			r += 'a' - 'A'
+		}
+		result.WriteRune(r)
+	}
+
+	return result.String()
+}
+
+func elementsOptions(elements []proto.Visitee) []*proto.Option {
+	var result []*proto.Option
+
+	for _, el := range elements {
+		if option, ok := el.(*proto.Option); ok {
+			result = append(result, option)
+		}
+	}
+
+	return result
+}
+
+// mapEntryMessage synthesizes map entry message the same way protoc does: map<K, V> field_name
+// becomes nested message FieldNameEntry with "key" = 1, "value" = 2 fields and map_entry option.
+func mapEntryMessage(file *File, fld *proto.MapField, keyType, valueType Type, parent *Message) *Message {
+	name := lowerCamelCase(fld.Name)
+	if name != "" {
+		name = strings.ToUpper(name[:1]) + name[1:]
+	}
+	name += "Entry"
+
+	keyField := &proto.Field{Name: "key", Type: fld.KeyType, Sequence: 1}
+	valueField := &proto.Field{Name: "value", Type: fld.Type, Sequence: 2}
+	descriptor := &proto.Message{
+		Name: name,
+		Elements: []proto.Visitee{
+			&proto.Option{Name: "map_entry", Constant: proto.Literal{Source: "true"}},
+			&proto.NormalField{Field: keyField},
+			&proto.NormalField{Field: valueField},
+		},
+		Parent: parent.Descriptor,
+	}
+	entry := message(file, descriptor, parent.TypeName.NewSubTypeName(name), parent)
+	entry.NormalFields = []*NormalField{
+		{KeyNumber: 1, Name: "key", QuotedComment: `""`, descriptor: keyField, Type: keyType, message: entry},
+		{KeyNumber: 2, Name: "value", QuotedComment: `""`, descriptor: valueField, Type: valueType, message: entry},
+	}
+
+	return entry
+}
+
+// mapKeyTypeIsValid checks, that type can be used as map key: any integral, bool or string scalar type.
+func mapKeyTypeIsValid(typ Type) bool {
+	scalar, ok := typ.(*Scalar)
+	if !ok {
+		return false
+	}
+
+	return scalar.scalarKind().IsIntegral() || scalar.scalarKind() == ScalarString || scalar.scalarKind() == ScalarBool
+}
+
+// typeWireType returns wire type of single non-packed value of given type.
+func typeWireType(typ Type) uint64 {
+	switch typ := typ.(type) {
+	case *Scalar:
+		return typ.scalarKind().WireType()
+	case *Enum:
+		return WireTypeVarint
+	}
+
+	return WireTypeLengthDelimited
+}
+
+func typeIsPackable(typ Type) bool {
+	switch typ := typ.(type) {
+	case *Scalar:
+		return typ.scalarKind().IsPackable()
+	case *Enum:
+		return true
+	}
+
+	return false
+}
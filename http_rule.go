@@ -0,0 +1,318 @@
+package shprotos
+
+import (
+	"strings"
+
+	"github.com/emicklei/proto"
+	"github.com/pkg/errors"
+)
+
+const httpRuleOptionName = "(google.api.http)"
+
+// HTTPRule is a google.api.http binding of a method.
+type HTTPRule struct {
+	// HTTPMethod is one of GET, PUT, POST, DELETE, PATCH or custom pattern kind.
+	HTTPMethod         string
+	Template           *PathTemplate
+	Body               string
+	ResponseBody       string
+	AdditionalBindings []*HTTPRule
+}
+
+type PathSegmentKind byte
+
+const (
+	PathSegmentLiteral PathSegmentKind = iota
+	PathSegmentWildcard
+	PathSegmentDeepWildcard
+	PathSegmentVariable
+)
+
+type PathSegment struct {
+	Kind     PathSegmentKind
+	Literal  string
+	Variable *PathVariable
+}
+
+func (s PathSegment) String() string {
+	switch s.Kind {
+	case PathSegmentWildcard:
+		return "*"
+	case PathSegmentDeepWildcard:
+		return "**"
+	case PathSegmentVariable:
+		return s.Variable.String()
+	}
+
+	return s.Literal
+}
+
+// PathVariable is a {field.path=segments/*} part of path template.
+type PathVariable struct {
+	FieldPath []string
+	Segments  []*PathSegment
+	// Fields contains resolved fields of method input message, one per FieldPath element.
+	Fields []Field
+}
+
+func (v PathVariable) String() string {
+	segments := make([]string, len(v.Segments))
+	for i, segment := range v.Segments {
+		segments[i] = segment.String()
+	}
+
+	return "{" + strings.Join(v.FieldPath, ".") + "=" + strings.Join(segments, "/") + "}"
+}
+
+func (v *PathVariable) resolve(msg *Message) error {
+	v.Fields = nil
+	current := msg
+	for _, name := range v.FieldPath {
+		if current == nil {
+			return errors.Errorf("field path %s goes through non-message field", strings.Join(v.FieldPath, "."))
+		}
+		field, ok := current.GetFieldByName(name)
+		if !ok {
+			return errors.Errorf("can't find field %s in message %s", name, current.GetFullName())
+		}
+		if _, ok := field.(*MapField); ok || field.IsRepeated() {
+			return errors.Errorf("field %s of message %s can't be used in path, because it's repeated", name, current.GetFullName())
+		}
+		v.Fields = append(v.Fields, field)
+		current, _ = field.GetType().(*Message)
+	}
+	if current != nil {
+		return errors.Errorf("field path %s points to message", strings.Join(v.FieldPath, "."))
+	}
+
+	return nil
+}
+
+// PathTemplate is a parsed google.api.http path template, like "/v1/{name=messages/*}:verb".
+type PathTemplate struct {
+	Path      string
+	Segments  []*PathSegment
+	Verb      string
+	Variables []*PathVariable
+}
+
+// ParsePathTemplate parses path template according to google/api/http.proto grammar.
+func ParsePathTemplate(path string) (*PathTemplate, error) {
+	p := &pathTemplateParser{path: path}
+
+	return p.parse()
+}
+
+type pathTemplateParser struct {
+	path string
+	pos  int
+}
+
+func (p *pathTemplateParser) parse() (*PathTemplate, error) {
+	if !p.consume("/") {
+		return nil, errors.Errorf("path template %q should start with /", p.path)
+	}
+	segments, err := p.parseSegments(true)
+	if err != nil {
+		return nil, err
+	}
+	result := &PathTemplate{
+		Path:     p.path,
+		Segments: segments,
+	}
+	if p.consume(":") {
+		result.Verb = p.parseLiteral()
+		if result.Verb == "" {
+			return nil, errors.Errorf("empty verb in path template %q", p.path)
+		}
+	}
+	if p.pos != len(p.path) {
+		return nil, errors.Errorf("unexpected %q at %d in path template %q", p.path[p.pos], p.pos, p.path)
+	}
+	for _, segment := range segments {
+		if segment.Kind == PathSegmentVariable {
+			result.Variables = append(result.Variables, segment.Variable)
+		}
+	}
+
+	return result, nil
+}
+
+func (p *pathTemplateParser) parseSegments(allowVariables bool) ([]*PathSegment, error) {
+	var result []*PathSegment
+	for {
+		segment, err := p.parseSegment(allowVariables)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, segment)
+		if !p.consume("/") {
+			return result, nil
+		}
+	}
+}
+
+func (p *pathTemplateParser) parseSegment(allowVariables bool) (*PathSegment, error) {
+	switch {
+	case p.consume("**"):
+		return &PathSegment{Kind: PathSegmentDeepWildcard}, nil
+	case p.consume("*"):
+		return &PathSegment{Kind: PathSegmentWildcard}, nil
+	case p.consume("{"):
+		if !allowVariables {
+			return nil, errors.Errorf("nested variable at %d in path template %q", p.pos-1, p.path)
+		}
+
+		return p.parseVariable()
+	}
+	literal := p.parseLiteral()
+	if literal == "" {
+		return nil, errors.Errorf("expected segment at %d in path template %q", p.pos, p.path)
+	}
+
+	return &PathSegment{Kind: PathSegmentLiteral, Literal: literal}, nil
+}
+
+func (p *pathTemplateParser) parseVariable() (*PathSegment, error) {
+	variable := &PathVariable{}
+	for {
+		ident := p.parseIdent()
+		if ident == "" {
+			return nil, errors.Errorf("expected field name at %d in path template %q", p.pos, p.path)
+		}
+		variable.FieldPath = append(variable.FieldPath, ident)
+		if !p.consume(".") {
+			break
+		}
+	}
+	if p.consume("=") {
+		segments, err := p.parseSegments(false)
+		if err != nil {
+			return nil, err
+		}
+		variable.Segments = segments
+	} else {
+		variable.Segments = []*PathSegment{{Kind: PathSegmentWildcard}}
+	}
+	if !p.consume("}") {
+		return nil, errors.Errorf("expected } at %d in path template %q", p.pos, p.path)
+	}
+
+	return &PathSegment{Kind: PathSegmentVariable, Variable: variable}, nil
+}
+
+func (p *pathTemplateParser) consume(s string) bool {
+	if strings.HasPrefix(p.path[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+
+	return false
+}
+
+func (p *pathTemplateParser) parseIdent() string {
+	start := p.pos
+	for p.pos < len(p.path) {
+		c := p.path[p.pos]
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			break
+		}
+		p.pos++
+	}
+
+	return p.path[start:p.pos]
+}
+
+func (p *pathTemplateParser) parseLiteral() string {
+	start := p.pos
+	for p.pos < len(p.path) && !strings.ContainsRune("/{}=*:", rune(p.path[p.pos])) {
+		p.pos++
+	}
+
+	return p.path[start:p.pos]
+}
+
+// httpRuleLiteral returns google.api.http option value, written either as aggregate, like
+// option (google.api.http) = { get: "/v1/books" }, or as separate fields, like option (google.api.http).get = "/v1/books".
+func httpRuleLiteral(options []*proto.Option) (*proto.Literal, bool, error) {
+	var result *proto.Literal
+	var fields proto.LiteralMap
+	for _, option := range options {
+		switch {
+		case option.Name == httpRuleOptionName:
+			result = &option.Constant
+		case strings.HasPrefix(option.Name, httpRuleOptionName+"."):
+			name := strings.TrimPrefix(option.Name, httpRuleOptionName+".")
+			fields = append(fields, &proto.NamedLiteral{Literal: &option.Constant, Name: name})
+		}
+	}
+	if result != nil && fields != nil {
+		return nil, false, errors.Errorf("%s option can't be set both as aggregate and by fields", httpRuleOptionName)
+	}
+	if fields != nil {
+		result = &proto.Literal{OrderedMap: fields}
+	}
+
+	return result, result != nil, nil
+}
+
+func parseHTTPRule(literal *proto.Literal, method *Method, additional bool) (*HTTPRule, error) {
+	result := &HTTPRule{}
+	var path string
+	for _, el := range literal.OrderedMap {
+		switch el.Name {
+		case "get", "put", "post", "delete", "patch":
+			result.HTTPMethod = strings.ToUpper(el.Name)
+			path = el.Source
+		case "custom":
+			kind, ok := el.OrderedMap.Get("kind")
+			customPath, pathOk := el.OrderedMap.Get("path")
+			if !ok || !pathOk {
+				return nil, errors.New("custom http rule should contain kind and path")
+			}
+			result.HTTPMethod = kind.Source
+			path = customPath.Source
+		case "body":
+			result.Body = el.Source
+		case "response_body":
+			result.ResponseBody = el.Source
+		case "selector":
+		case "additional_bindings":
+			if additional {
+				return nil, errors.New("additional_bindings can't be nested")
+			}
+			binding, err := parseHTTPRule(el.Literal, method, true)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to parse additional binding")
+			}
+			result.AdditionalBindings = append(result.AdditionalBindings, binding)
+		default:
+			return nil, errors.Errorf("unknown http rule field %s", el.Name)
+		}
+	}
+	if result.HTTPMethod == "" || path == "" {
+		return nil, errors.New("http rule should contain method and path")
+	}
+	template, err := ParsePathTemplate(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse path template")
+	}
+	for _, variable := range template.Variables {
+		if err := variable.resolve(method.InputMessage); err != nil {
+			return nil, errors.Wrapf(err, "failed to resolve %s path variable", variable)
+		}
+	}
+	result.Template = template
+	if result.Body != "" && result.Body != "*" {
+		if _, ok := method.InputMessage.GetFieldByName(result.Body); !ok {
+			return nil, errors.Errorf("can't find body field %s in message %s", result.Body, method.InputMessage.GetFullName())
+		}
+	}
+	if result.ResponseBody != "" {
+		if _, ok := method.OutputMessage.GetFieldByName(result.ResponseBody); !ok {
+			return nil, errors.Errorf("can't find response body field %s in message %s", result.ResponseBody, method.OutputMessage.GetFullName())
+		}
+	}
+
+	return result, nil
+}
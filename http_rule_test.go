@@ -0,0 +1,94 @@
+package shprotos
+
+import (
+	"testing"
+
+	"github.com/emicklei/proto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParsePathTemplate(t *testing.T) {
+	Convey("Test ParsePathTemplate", t, func(c C) {
+		c.Convey("Should parse literals, wildcards, variables and verb", func(c C) {
+			template, err := ParsePathTemplate("/v1/{parent.name=shelves/*}/books/**:batchGet")
+			c.So(err, ShouldBeNil)
+			c.So(template.Verb, ShouldEqual, "batchGet")
+			c.So(template.Segments, ShouldHaveLength, 4)
+			c.So(template.Segments[0].Kind, ShouldEqual, PathSegmentLiteral)
+			c.So(template.Segments[0].Literal, ShouldEqual, "v1")
+			c.So(template.Segments[1].Kind, ShouldEqual, PathSegmentVariable)
+			c.So(template.Segments[3].Kind, ShouldEqual, PathSegmentDeepWildcard)
+			c.So(template.Variables, ShouldHaveLength, 1)
+			c.So(template.Variables[0].FieldPath, ShouldResemble, []string{"parent", "name"})
+			c.So(template.Variables[0].String(), ShouldEqual, "{parent.name=shelves/*}")
+		})
+		c.Convey("Should use single wildcard for variable without segments", func(c C) {
+			template, err := ParsePathTemplate("/v1/{name}")
+			c.So(err, ShouldBeNil)
+			c.So(template.Variables[0].Segments, ShouldHaveLength, 1)
+			c.So(template.Variables[0].Segments[0].Kind, ShouldEqual, PathSegmentWildcard)
+		})
+		c.Convey("Should fail on invalid templates", func(c C) {
+			for _, path := range []string{"v1/books", "/v1/{name=a/{b}}", "/v1/{name", "/v1//books", "/v1/books:", "/v1/{}"} {
+				_, err := ParsePathTemplate(path)
+				c.So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestHTTPRule(t *testing.T) {
+	Convey("Test google.api.http rules", t, func(c C) {
+		parser := Parser{}
+		file, err := parser.Parse("./testdata/http.proto", nil, nil)
+		c.So(err, ShouldBeNil)
+		getBook, updateBook := file.Services[0].Methods[0], file.Services[0].Methods[1]
+
+		c.Convey("Should parse http rule with additional bindings", func(c C) {
+			rule := getBook.HTTPRule
+			c.So(rule, ShouldNotBeNil)
+			c.So(rule.HTTPMethod, ShouldEqual, "GET")
+			c.So(rule.ResponseBody, ShouldEqual, "book")
+			c.So(rule.Template.Variables, ShouldHaveLength, 2)
+			c.So(rule.Template.Variables[0].Fields, ShouldHaveLength, 2)
+			c.So(rule.Template.Variables[0].Fields[1].GetName(), ShouldEqual, "id")
+			c.So(rule.Template.Variables[0].Fields[1].GetMessage().Name, ShouldEqual, "Shelf")
+			c.So(getBook.HTTPRules(), ShouldHaveLength, 2)
+			c.So(rule.AdditionalBindings[0].HTTPMethod, ShouldEqual, "HEAD")
+			c.So(rule.AdditionalBindings[0].Template.Verb, ShouldEqual, "head")
+		})
+		c.Convey("Should parse http rule with body", func(c C) {
+			rule := updateBook.HTTPRule
+			c.So(rule.HTTPMethod, ShouldEqual, "PATCH")
+			c.So(rule.Body, ShouldEqual, "book")
+			c.So(rule.Template.Variables[0].Fields[0].GetName(), ShouldEqual, "book")
+			c.So(rule.Template.Variables[0].Fields[1].GetName(), ShouldEqual, "name")
+		})
+		c.Convey("Should parse http rule, set by fields", func(c C) {
+			deleteBook := file.Services[0].Methods[2]
+			c.So(deleteBook.HTTPRuleErr, ShouldBeNil)
+			c.So(deleteBook.HTTPRule.HTTPMethod, ShouldEqual, "DELETE")
+			c.So(deleteBook.HTTPRule.Template.Variables[0].Fields[0].GetName(), ShouldEqual, "name")
+		})
+		c.Convey("Should keep error of invalid http rule in method", func(c C) {
+			moveBook := file.Services[0].Methods[3]
+			c.So(moveBook.HTTPRule, ShouldBeNil)
+			c.So(moveBook.HTTPRuleErr, ShouldNotBeNil)
+			c.So(getBook.HTTPRuleErr, ShouldBeNil)
+		})
+		c.Convey("Should fail on http rule, set both as aggregate and by fields", func(c C) {
+			_, _, err := httpRuleLiteral([]*proto.Option{{Name: httpRuleOptionName}, {Name: httpRuleOptionName + ".get"}})
+			c.So(err, ShouldNotBeNil)
+		})
+		c.Convey("Should fail to resolve unknown variable field", func(c C) {
+			template, err := ParsePathTemplate("/v1/{book.author}")
+			c.So(err, ShouldBeNil)
+			c.So(template.Variables[0].resolve(updateBook.InputMessage), ShouldNotBeNil)
+		})
+		c.Convey("Should fail to resolve message variable field", func(c C) {
+			template, err := ParsePathTemplate("/v1/{book}")
+			c.So(err, ShouldBeNil)
+			c.So(template.Variables[0].resolve(updateBook.InputMessage), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,890 @@
+package shprotos
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// JSONMarshaler encodes dynamic message values, as returned by UnmarshalMessage, to canonical proto3 JSON,
+// compatible with jsonpb and protojson.
+type JSONMarshaler struct {
+	// OrigName uses proto field names instead of lowerCamelCase (or json_name) ones.
+	OrigName bool
+	// EnumsAsInts renders enum values as numbers instead of names.
+	EnumsAsInts bool
+	// Indent is a string to indent each level by. Output is compact, if it's empty.
+	Indent string
+	// Resolver resolves type URLs of google.protobuf.Any values. If it's nil, messages are looked up
+	// in file of marshalled message and its imports.
+	Resolver MessageResolver
+}
+
+func (m JSONMarshaler) Marshal(data map[string]interface{}, msg *Message) ([]byte, error) {
+	if m.Resolver == nil {
+		m.Resolver = defaultResolver(msg)
+	}
+	e := &jsonEncoder{JSONMarshaler: m}
+	if err := e.encodeMessage(data, msg, ""); err != nil {
+		return nil, err
+	}
+	if m.Indent == "" {
+		return e.buf.Bytes(), nil
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, e.buf.Bytes(), "", m.Indent); err != nil {
+		return nil, errors.Wrap(err, "failed to indent json")
+	}
+
+	return indented.Bytes(), nil
+}
+
+type jsonEncoder struct {
+	JSONMarshaler
+	buf bytes.Buffer
+}
+
+func (e *jsonEncoder) fieldName(field Field) string {
+	if e.OrigName {
+		return field.GetName()
+	}
+
+	return field.GetJSONName()
+}
+
+func (e *jsonEncoder) encodeMessage(data map[string]interface{}, msg *Message, path string) error {
+	e.buf.WriteByte('{')
+	if err := e.encodeFields(data, msg, path, true); err != nil {
+		return err
+	}
+	e.buf.WriteByte('}')
+
+	return nil
+}
+
+// encodeFields writes message fields without enclosing braces. first reports, that no fields were written
+// to the object before.
+func (e *jsonEncoder) encodeFields(data map[string]interface{}, msg *Message, path string, first bool) error {
+	for _, fp := range planOf(msg).ordered {
+		field := fp.field
+		value, ok := data[fp.name]
+		if !ok || (value == nil && !holdsNullValue(field)) {
+			continue
+		}
+		if !first {
+			e.buf.WriteByte(',')
+		}
+		first = false
+		e.writeString(e.fieldName(field))
+		e.buf.WriteByte(':')
+		fieldPath := joinFieldPath(path, fp.name)
+		var err error
+		switch fld := field.(type) {
+		case *MapField:
+			err = e.encodeMap(value, fld.Map, fieldPath)
+		case *NormalField:
+			if fld.Repeated {
+				err = e.encodeList(value, fld.Type, fieldPath)
+			} else {
+				err = e.encodeValue(value, fld.Type, fieldPath)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encodeAny writes expanded google.protobuf.Any value as an object with "@type" key and fields of embedded message.
+func (e *jsonEncoder) encodeAny(value interface{}, msg *Message, path string) error {
+	data, ok := value.(map[string]interface{})
+	if !ok {
+		return newFieldError(path, protoTypeName(msg), value, nil)
+	}
+	if _, ok := data[AnyTypeKey]; !ok && len(data) > 0 {
+		expanded, err := UnmarshalOptions{Resolver: e.Resolver}.expandAny(data, 1)
+		if err != nil {
+			return newFieldError(path, protoTypeName(msg), value, err)
+		}
+		data = expanded
+	}
+	typeURL, ok := data[AnyTypeKey].(string)
+	if !ok {
+		e.buf.WriteString("{}")
+		return nil
+	}
+	embedded, err := resolveAnyMessage(e.Resolver, typeURL)
+	if err != nil {
+		return newFieldError(path, protoTypeName(msg), value, err)
+	}
+	e.buf.WriteByte('{')
+	e.writeString(AnyTypeKey)
+	e.buf.WriteByte(':')
+	e.writeString(typeURL)
+	if isWrappedInAny(embedded) {
+		e.buf.WriteString(`,"value":`)
+		if err := e.encodeValue(data["value"], embedded, joinFieldPath(path, "value")); err != nil {
+			return err
+		}
+	} else if err := e.encodeFields(data, embedded, path, false); err != nil {
+		return err
+	}
+	e.buf.WriteByte('}')
+
+	return nil
+}
+
+func (e *jsonEncoder) encodeList(value interface{}, typ Type, path string) error {
+	values := reflect.ValueOf(value)
+	if values.Kind() != reflect.Slice && values.Kind() != reflect.Array {
+		return newFieldError(path, "repeated "+protoTypeName(typ), value, nil)
+	}
+	e.buf.WriteByte('[')
+	for i := 0; i < values.Len(); i++ {
+		if i > 0 {
+			e.buf.WriteByte(',')
+		}
+		if err := e.encodeValue(values.Index(i).Interface(), typ, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	e.buf.WriteByte(']')
+
+	return nil
+}
+
+func (e *jsonEncoder) encodeMap(value interface{}, typ *Map, path string) error {
+	mapValue := reflect.ValueOf(value)
+	if mapValue.Kind() != reflect.Map {
+		return newFieldError(path, protoTypeName(typ), value, nil)
+	}
+	keys, err := sortedMapKeys(mapValue, typ.KeyType, path)
+	if err != nil {
+		return err
+	}
+	e.buf.WriteByte('{')
+	for i, mapKey := range keys {
+		key, err := formatMapKey(mapKey.Interface(), typ.KeyType)
+		if err != nil {
+			return newFieldError(fmt.Sprintf("%s[%v]", path, mapKey.Interface()), protoTypeName(typ.KeyType), mapKey.Interface(), err)
+		}
+		if i > 0 {
+			e.buf.WriteByte(',')
+		}
+		e.writeString(key)
+		e.buf.WriteByte(':')
+		if err := e.encodeValue(mapValue.MapIndex(mapKey).Interface(), typ.ValueType, fmt.Sprintf("%s[%s]", path, key)); err != nil {
+			return err
+		}
+	}
+	e.buf.WriteByte('}')
+
+	return nil
+}
+
+func (e *jsonEncoder) encodeValue(value interface{}, typ Type, path string) error {
+	switch typ := typ.(type) {
+	case *Message:
+		if wkt, _, ok := wellKnownTypeOf(typ); ok {
+			return e.encodeWellKnown(value, wkt, typ, path)
+		}
+		if typ.GetFullName() == wktAny {
+			return e.encodeAny(value, typ, path)
+		}
+		msgValue, err := messageMap(value, typ, path)
+		if err != nil {
+			return err
+		}
+
+		return e.encodeMessage(msgValue, typ, path)
+	case *Enum:
+		number, name, err := resolveEnumValue(value, typ)
+		if err != nil {
+			return newFieldError(path, protoTypeName(typ), value, err)
+		}
+		if e.EnumsAsInts || name == "" {
+			e.buf.WriteString(strconv.FormatInt(int64(number), 10))
+		} else {
+			e.writeString(name)
+		}
+
+		return nil
+	case *Scalar:
+		if err := e.encodeScalar(value, typ.scalarKind()); err != nil {
+			return newFieldError(path, protoTypeName(typ), value, err)
+		}
+
+		return nil
+	}
+
+	return newFieldError(path, protoTypeName(typ), value, nil)
+}
+
+// encodeWellKnown writes canonical JSON of well-known message value.
+func (e *jsonEncoder) encodeWellKnown(value interface{}, wkt wellKnownType, msg *Message, path string) error {
+	data, err := wkt.toMessage(value, msg)
+	if err != nil {
+		return newFieldError(path, protoTypeName(msg), value, err)
+	}
+	switch msg.GetFullName() {
+	case wktEmpty:
+		return e.encodeMessage(data, msg, path)
+	case wktStruct, wktValue, wktListValue:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return newFieldError(path, protoTypeName(msg), value, err)
+		}
+		e.buf.Write(encoded)
+		return nil
+	}
+	canonical, err := wkt.fromMessage(data, msg)
+	if err != nil {
+		return newFieldError(path, protoTypeName(msg), value, err)
+	}
+	switch msg.GetFullName() {
+	case wktTimestamp, wktDuration:
+		e.writeString(canonical.(string))
+	case wktFieldMask:
+		paths := strings.Split(canonical.(string), ",")
+		for i, path := range paths {
+			paths[i] = lowerCamelCase(path)
+		}
+		e.writeString(strings.Join(paths, ","))
+	default:
+		typ, err := wrapperValueType(msg)
+		if err != nil {
+			return err
+		}
+		return e.encodeValue(canonical, typ, path)
+	}
+
+	return nil
+}
+
+func (e *jsonEncoder) encodeScalar(value interface{}, kind ScalarKind) error {
+	switch kind {
+	case ScalarString:
+		str, ok := value.(string)
+		if !ok {
+			return errors.Errorf("can't convert %T to string", value)
+		}
+		e.writeString(str)
+	case ScalarBytes:
+		bytesValue, err := bytesFromInterface(value)
+		if err != nil {
+			return err
+		}
+		e.writeString(base64.StdEncoding.EncodeToString(bytesValue))
+	case ScalarBool:
+		boolValue, err := boolFromInterface(value)
+		if err != nil {
+			return err
+		}
+		e.buf.WriteString(strconv.FormatBool(boolValue))
+	case ScalarFloat, ScalarDouble:
+		floatValue, err := float64FromInterface(value)
+		if err != nil {
+			return err
+		}
+		bitSize := 64
+		if kind == ScalarFloat {
+			bitSize = 32
+		}
+		e.writeFloat(floatValue, bitSize)
+	default:
+		intValue, err := uint64FromInterface(value)
+		if err != nil {
+			return err
+		}
+		formatted := formatIntegral(intValue, kind)
+		switch kind {
+		case ScalarInt64, ScalarUint64, ScalarSint64, ScalarFixed64, ScalarSfixed64:
+			// 64-bit integers are quoted, as JSON numbers can't hold them precisely.
+			e.writeString(formatted)
+		default:
+			e.buf.WriteString(formatted)
+		}
+	}
+
+	return nil
+}
+
+// writeFloat writes float the same way protojson and encoding/json do: without exponent, unless absolute
+// value is less than 1e-6 or at least 1e21. Special values are written as strings.
+func (e *jsonEncoder) writeFloat(value float64, bitSize int) {
+	switch {
+	case math.IsNaN(value):
+		e.writeString("NaN")
+		return
+	case math.IsInf(value, 1):
+		e.writeString("Infinity")
+		return
+	case math.IsInf(value, -1):
+		e.writeString("-Infinity")
+		return
+	}
+	format := byte('f')
+	if abs := math.Abs(value); abs != 0 {
+		if bitSize == 64 && (abs < 1e-6 || abs >= 1e21) || bitSize == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+	formatted := strconv.AppendFloat(nil, value, format, -1, bitSize)
+	if format == 'e' {
+		// exponent is written with at least one digit, 1e-07 becomes 1e-7
+		if n := len(formatted); n >= 4 && formatted[n-4] == 'e' && formatted[n-3] == '-' && formatted[n-2] == '0' {
+			formatted[n-2] = formatted[n-1]
+			formatted = formatted[:n-1]
+		}
+	}
+	e.buf.Write(formatted)
+}
+
+func (e *jsonEncoder) writeString(s string) {
+	encoded, _ := json.Marshal(s)
+	e.buf.Write(encoded)
+}
+
+// formatIntegral formats integer, encoded as uint64 bits, according to scalar kind size and signedness.
+func formatIntegral(value uint64, kind ScalarKind) string {
+	switch kind {
+	case ScalarInt32, ScalarSint32, ScalarSfixed32:
+		return strconv.FormatInt(int64(int32(value)), 10)
+	case ScalarUint32, ScalarFixed32:
+		return strconv.FormatUint(uint64(uint32(value)), 10)
+	case ScalarInt64, ScalarSint64, ScalarSfixed64:
+		return strconv.FormatInt(int64(value), 10)
+	}
+
+	return strconv.FormatUint(value, 10)
+}
+
+// formatMapKey formats map key the way it's represented in JSON object.
+func formatMapKey(key interface{}, typ Type) (string, error) {
+	scalar, ok := typ.(*Scalar)
+	if !ok {
+		return "", errors.Errorf("unexpected map key type %s", typ)
+	}
+	switch scalar.scalarKind() {
+	case ScalarString:
+		str, ok := key.(string)
+		if !ok {
+			return "", errors.Errorf("can't convert %T to string", key)
+		}
+		return str, nil
+	case ScalarBool:
+		boolValue, err := boolFromInterface(key)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatBool(boolValue), nil
+	}
+	intValue, err := uint64FromInterface(key)
+	if err != nil {
+		return "", err
+	}
+
+	return formatIntegral(intValue, scalar.scalarKind()), nil
+}
+
+func joinFieldPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+
+	return path + "." + name
+}
+
+// JSONUnmarshaler decodes canonical proto3 JSON into dynamic message value, accepted by MarshalMessage.
+// Fields are looked up by json name and by proto name, result uses proto names. JSON is read token by token
+// along the message schema, without intermediate tree.
+type JSONUnmarshaler struct {
+	// AllowUnknownFields skips JSON fields, missing in message schema, instead of failing.
+	AllowUnknownFields bool
+	// Resolver resolves type URLs of google.protobuf.Any values. If it's nil, messages are looked up
+	// in file of unmarshalled message and its imports.
+	Resolver MessageResolver
+}
+
+func (u JSONUnmarshaler) Unmarshal(data []byte, msg *Message) (map[string]interface{}, error) {
+	if u.Resolver == nil {
+		u.Resolver = defaultResolver(msg)
+	}
+	d := &jsonDecoder{data: data}
+	if d.peek() != '{' {
+		return nil, mismatchedJSONValue(d, "", protoTypeName(msg))
+	}
+	result, err := u.decodeMessage(d, msg, "", false)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.end(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// mismatchedJSONValue reads value, which can't be decoded as protoType, and returns FieldError about it.
+func mismatchedJSONValue(d *jsonDecoder, path string, protoType string) error {
+	value, err := d.readValue(true)
+	if err != nil {
+		return err
+	}
+
+	return newFieldError(path, protoType, value, nil)
+}
+
+// decodeMessage reads object of message fields. AnyTypeKey is skipped, if message is embedded into Any.
+func (u JSONUnmarshaler) decodeMessage(d *jsonDecoder, msg *Message, path string, inAny bool) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	err := d.readObject(func(key string) error {
+		if inAny && key == AnyTypeKey {
+			return d.skipValue()
+		}
+		field, ok := jsonMessageField(msg, key)
+		if !ok {
+			if u.AllowUnknownFields {
+				return d.skipValue()
+			}
+			return errors.Errorf("unknown field %s in message %s", joinFieldPath(path, key), msg.GetFullName())
+		}
+		if _, ok := result[field.GetName()]; ok {
+			return errors.Errorf("field %s is set twice", joinFieldPath(path, field.GetName()))
+		}
+		null, err := d.readNull()
+		if err != nil {
+			return err
+		}
+		if null {
+			// null is a null value of google.protobuf.Value field and a missing value of others
+			if holdsNullValue(field) {
+				result[field.GetName()] = nil
+			}
+			return nil
+		}
+		fieldPath := joinFieldPath(path, field.GetName())
+		var fieldValue interface{}
+		switch fld := field.(type) {
+		case *MapField:
+			fieldValue, err = u.decodeMap(d, fld.Map, fieldPath)
+		case *NormalField:
+			if fld.Repeated {
+				fieldValue, err = u.decodeList(d, fld.Type, fieldPath)
+			} else {
+				fieldValue, err = u.decodeValue(d, fld.Type, fieldPath)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		result[field.GetName()] = fieldValue
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, oneOf := range msg.OneOffs {
+		var set []string
+		for _, fld := range oneOf.Fields {
+			if _, ok := result[fld.Name]; ok {
+				set = append(set, fld.Name)
+			}
+		}
+		if len(set) > 1 {
+			return nil, &OneOfError{Path: path, OneOf: oneOf.Name, Fields: set}
+		}
+	}
+
+	return result, nil
+}
+
+func jsonMessageField(msg *Message, name string) (Field, bool) {
+	for _, field := range msg.GetFields() {
+		if field.GetJSONName() == name || field.GetName() == name {
+			return field, true
+		}
+	}
+
+	return nil, false
+}
+
+func (u JSONUnmarshaler) decodeList(d *jsonDecoder, typ Type, path string) ([]interface{}, error) {
+	if d.peek() != '[' {
+		return nil, mismatchedJSONValue(d, path, "repeated "+protoTypeName(typ))
+	}
+	result := []interface{}{}
+	err := d.readArray(func(i int) error {
+		elemPath := fmt.Sprintf("%s[%d]", path, i)
+		if d.peek() == 'n' && !isWellKnownType(typ, wktValue) {
+			return mismatchedJSONValue(d, elemPath, protoTypeName(typ))
+		}
+		decoded, err := u.decodeValue(d, typ, elemPath)
+		if err != nil {
+			return err
+		}
+		result = append(result, decoded)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (u JSONUnmarshaler) decodeMap(d *jsonDecoder, typ *Map, path string) (map[string]interface{}, error) {
+	if d.peek() != '{' {
+		return nil, mismatchedJSONValue(d, path, protoTypeName(typ))
+	}
+	result := make(map[string]interface{})
+	err := d.readObject(func(key string) error {
+		entryPath := fmt.Sprintf("%s[%s]", path, key)
+		mapKey, err := parseMapKey(key, typ.KeyType)
+		if err != nil {
+			return newFieldError(entryPath, protoTypeName(typ.KeyType), key, err)
+		}
+		if d.peek() == 'n' && !isWellKnownType(typ.ValueType, wktValue) {
+			return mismatchedJSONValue(d, entryPath, protoTypeName(typ.ValueType))
+		}
+		decoded, err := u.decodeValue(d, typ.ValueType, entryPath)
+		if err != nil {
+			return err
+		}
+		result[mapKey] = decoded
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// parseMapKey validates JSON object key and returns it in canonical form.
+func parseMapKey(key string, typ Type) (string, error) {
+	scalar, ok := typ.(*Scalar)
+	if !ok {
+		return "", errors.Errorf("unexpected map key type %s", typ)
+	}
+	switch scalar.scalarKind() {
+	case ScalarString:
+		return key, nil
+	case ScalarBool:
+		if key != "true" && key != "false" {
+			return "", errors.Errorf("invalid bool %q", key)
+		}
+		return key, nil
+	}
+	value, err := parseJSONIntegral(key, scalar.scalarKind())
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprint(value), nil
+}
+
+func (u JSONUnmarshaler) decodeValue(d *jsonDecoder, typ Type, path string) (interface{}, error) {
+	if msg, ok := typ.(*Message); ok {
+		if _, _, ok := wellKnownTypeOf(msg); ok && msg.GetFullName() != wktEmpty {
+			return u.decodeWellKnown(d, msg, path)
+		}
+		if d.peek() != '{' {
+			return nil, mismatchedJSONValue(d, path, protoTypeName(msg))
+		}
+		if msg.GetFullName() == wktAny {
+			return u.decodeAny(d, msg, path)
+		}
+
+		return u.decodeMessage(d, msg, path, false)
+	}
+	value, err := d.readValue(true)
+	if err != nil {
+		return nil, err
+	}
+	switch typ := typ.(type) {
+	case *Enum:
+		switch value := value.(type) {
+		case string:
+			enumValue, ok := typ.ValueByName(value)
+			if !ok {
+				return nil, newFieldError(path, protoTypeName(typ), value, errors.Errorf("unknown value %s", value))
+			}
+			return int32(enumValue.Value), nil
+		case json.Number:
+			number, err := parseJSONIntegral(value.String(), ScalarInt32)
+			if err != nil {
+				return nil, newFieldError(path, protoTypeName(typ), value, err)
+			}
+			if _, ok := typ.ValueByNumber(int(number.(int32))); !ok && typ.IsClosed() {
+				return nil, newFieldError(path, protoTypeName(typ), value, errors.Errorf("unknown value %s", value))
+			}
+			return number, nil
+		}
+	case *Scalar:
+		result, err := decodeJSONScalar(value, typ.scalarKind())
+		if err != nil {
+			return nil, newFieldError(path, protoTypeName(typ), value, err)
+		}
+		return result, nil
+	}
+
+	return nil, newFieldError(path, protoTypeName(typ), value, nil)
+}
+
+// decodeAny decodes google.protobuf.Any JSON object to expanded dynamic value with AnyTypeKey. AnyTypeKey may
+// follow fields of embedded message, so object is scanned for it first and then decoded from the start.
+func (u JSONUnmarshaler) decodeAny(d *jsonDecoder, msg *Message, path string) (map[string]interface{}, error) {
+	start := d.pos
+	var typeURL string
+	keys := 0
+	hasType := false
+	err := d.readObject(func(key string) error {
+		keys++
+		if key != AnyTypeKey {
+			return d.skipValue()
+		}
+		value, err := d.readValue(true)
+		typeURL, hasType = value.(string)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if keys == 0 {
+		return map[string]interface{}{}, nil
+	}
+	// anyError rereads the whole object, so error reports its Go type
+	anyError := func(err error) error {
+		d.pos = start
+		value, _ := d.readValue(true)
+		return newFieldError(path, protoTypeName(msg), value, err)
+	}
+	if !hasType {
+		return nil, anyError(errors.Errorf("%s key is missing", AnyTypeKey))
+	}
+	embedded, err := resolveAnyMessage(u.Resolver, typeURL)
+	if err != nil {
+		return nil, anyError(err)
+	}
+	d.pos = start
+	if !isWrappedInAny(embedded) {
+		result, err := u.decodeMessage(d, embedded, path, true)
+		if err != nil {
+			return nil, err
+		}
+		result[AnyTypeKey] = typeURL
+		return result, nil
+	}
+	valuePath := joinFieldPath(path, "value")
+	var value interface{}
+	hasValue := false
+	err = d.readObject(func(key string) error {
+		if key != "value" {
+			return d.skipValue()
+		}
+		hasValue = true
+		var err error
+		value, err = u.decodeValue(d, embedded, valuePath)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !hasValue {
+		return nil, newFieldError(valuePath, protoTypeName(embedded), nil, errors.New("value is missing"))
+	}
+
+	return map[string]interface{}{AnyTypeKey: typeURL, "value": value}, nil
+}
+
+// decodeWellKnown reads canonical JSON of well-known message to its dynamic value representation.
+func (u JSONUnmarshaler) decodeWellKnown(d *jsonDecoder, msg *Message, path string) (interface{}, error) {
+	switch msg.GetFullName() {
+	case wktStruct:
+		if d.peek() != '{' {
+			return nil, mismatchedJSONValue(d, path, protoTypeName(msg))
+		}
+		return d.readValue(false)
+	case wktListValue:
+		if d.peek() != '[' {
+			return nil, mismatchedJSONValue(d, path, protoTypeName(msg))
+		}
+		return d.readValue(false)
+	case wktValue:
+		return d.readValue(false)
+	case wktTimestamp, wktDuration, wktFieldMask:
+	default:
+		typ, err := wrapperValueType(msg)
+		if err != nil {
+			return nil, err
+		}
+		return u.decodeValue(d, typ, path)
+	}
+	if d.peek() != '"' {
+		return nil, mismatchedJSONValue(d, path, protoTypeName(msg))
+	}
+	str, err := d.readString()
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	switch msg.GetFullName() {
+	case wktTimestamp:
+		var seconds int64
+		var nanos int32
+		if seconds, nanos, err = parseTimestamp(str); err == nil {
+			result, err = formatTimestamp(seconds, nanos)
+		}
+	case wktDuration:
+		var seconds int64
+		var nanos int32
+		if seconds, nanos, err = parseDuration(str); err == nil {
+			result, err = formatDuration(seconds, nanos)
+		}
+	default:
+		var paths []string
+		if str != "" {
+			paths = strings.Split(str, ",")
+		}
+		for i, path := range paths {
+			paths[i] = snakeCase(path)
+		}
+		result = strings.Join(paths, ",")
+	}
+	if err != nil {
+		return nil, newFieldError(path, protoTypeName(msg), str, err)
+	}
+
+	return result, nil
+}
+
+// decodeJSONScalar converts JSON value to Go value of scalar kind type. Bytes are returned as standard base64 string.
+func decodeJSONScalar(value interface{}, kind ScalarKind) (interface{}, error) {
+	switch kind {
+	case ScalarString:
+		if str, ok := value.(string); ok {
+			return str, nil
+		}
+	case ScalarBytes:
+		if str, ok := value.(string); ok {
+			data, err := decodeJSONBytes(str)
+			if err != nil {
+				return nil, err
+			}
+			return base64.StdEncoding.EncodeToString(data), nil
+		}
+	case ScalarBool:
+		if boolValue, ok := value.(bool); ok {
+			return boolValue, nil
+		}
+	case ScalarFloat, ScalarDouble:
+		var str string
+		switch value := value.(type) {
+		case json.Number:
+			str = value.String()
+		case string:
+			switch value {
+			case "NaN":
+				str = "NaN"
+			case "Infinity":
+				str = "+Inf"
+			case "-Infinity":
+				str = "-Inf"
+			default:
+				str = value
+			}
+		default:
+			return nil, errors.Errorf("unexpected %T", value)
+		}
+		bitSize := 64
+		if kind == ScalarFloat {
+			bitSize = 32
+		}
+		floatValue, err := strconv.ParseFloat(str, bitSize)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse float")
+		}
+		if kind == ScalarFloat {
+			return float32(floatValue), nil
+		}
+		return floatValue, nil
+	default:
+		switch value := value.(type) {
+		case json.Number:
+			return parseJSONIntegral(value.String(), kind)
+		case string:
+			return parseJSONIntegral(value, kind)
+		}
+	}
+
+	return nil, errors.Errorf("unexpected %T", value)
+}
+
+// parseJSONIntegral parses integer, which may be written with exponent or fraction part, like 1e3 or 10.0,
+// to Go value of scalar kind type.
+func parseJSONIntegral(str string, kind ScalarKind) (interface{}, error) {
+	bitSize := 64
+	if kind.GoType().Size() == 4 {
+		bitSize = 32
+	}
+	if kind.IsSigned() {
+		value, err := strconv.ParseInt(str, 10, bitSize)
+		if err != nil {
+			floatValue, ferr := strconv.ParseFloat(str, 64)
+			if ferr != nil || floatValue != math.Trunc(floatValue) {
+				return nil, errors.Wrap(err, "failed to parse int")
+			}
+			value, err = strconv.ParseInt(strconv.FormatFloat(floatValue, 'f', -1, 64), 10, bitSize)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to parse int")
+			}
+		}
+		if bitSize == 32 {
+			return int32(value), nil
+		}
+		return value, nil
+	}
+	value, err := strconv.ParseUint(str, 10, bitSize)
+	if err != nil {
+		floatValue, ferr := strconv.ParseFloat(str, 64)
+		if ferr != nil || floatValue != math.Trunc(floatValue) {
+			return nil, errors.Wrap(err, "failed to parse uint")
+		}
+		value, err = strconv.ParseUint(strconv.FormatFloat(floatValue, 'f', -1, 64), 10, bitSize)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse uint")
+		}
+	}
+	if bitSize == 32 {
+		return uint32(value), nil
+	}
+
+	return value, nil
+}
+
+// decodeJSONBytes accepts standard and URL-safe base64 with or without padding.
+func decodeJSONBytes(str string) ([]byte, error) {
+	encoding := base64.StdEncoding
+	if strings.ContainsAny(str, "-_") {
+		encoding = base64.URLEncoding
+	}
+	if len(str)%4 != 0 {
+		encoding = encoding.WithPadding(base64.NoPadding)
+	}
+	data, err := encoding.DecodeString(str)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode base64 string")
+	}
+
+	return data, nil
+}
@@ -0,0 +1,357 @@
+package shprotos
+
+import (
+	"encoding/json"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// maxJSONDepth limits nesting of JSON objects and arrays, read by jsonDecoder.
+const maxJSONDepth = 10000
+
+// jsonDecoder reads JSON tokens directly from data. JSONUnmarshaler drives it by message schema, so values
+// are decoded to their dynamic representation without intermediate tree.
+type jsonDecoder struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+func (d *jsonDecoder) skipSpace() {
+	for d.pos < len(d.data) {
+		switch d.data[d.pos] {
+		case ' ', '\t', '\n', '\r':
+			d.pos++
+		default:
+			return
+		}
+	}
+}
+
+// peek returns next non-space byte without consuming it, or zero at the end of data.
+func (d *jsonDecoder) peek() byte {
+	d.skipSpace()
+	if d.pos == len(d.data) {
+		return 0
+	}
+
+	return d.data[d.pos]
+}
+
+func (d *jsonDecoder) unexpected() error {
+	if d.pos >= len(d.data) {
+		return errors.New("unexpected end of json")
+	}
+
+	return errors.Errorf("unexpected %q at offset %d of json", d.data[d.pos], d.pos)
+}
+
+func (d *jsonDecoder) consume(c byte) error {
+	if d.peek() != c {
+		return d.unexpected()
+	}
+	d.pos++
+
+	return nil
+}
+
+// end checks, that only spaces are left after decoded value.
+func (d *jsonDecoder) end() error {
+	if d.peek() != 0 {
+		return errors.Errorf("unexpected data after json value at offset %d", d.pos)
+	}
+
+	return nil
+}
+
+// readNull consumes null literal, if it's the next token.
+func (d *jsonDecoder) readNull() (bool, error) {
+	if d.peek() != 'n' {
+		return false, nil
+	}
+
+	return true, d.readLiteral("null")
+}
+
+func (d *jsonDecoder) readLiteral(literal string) error {
+	end := d.pos + len(literal)
+	if end > len(d.data) || string(d.data[d.pos:end]) != literal || end < len(d.data) && isJSONIdentByte(d.data[end]) {
+		return errors.Errorf("invalid literal at offset %d of json", d.pos)
+	}
+	d.pos = end
+
+	return nil
+}
+
+func isJSONIdentByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// readObject reads object, calling fn for each key. fn should read value of the key.
+func (d *jsonDecoder) readObject(fn func(key string) error) error {
+	if err := d.enter('{'); err != nil {
+		return err
+	}
+	if d.peek() == '}' {
+		return d.leave()
+	}
+	for {
+		if d.peek() != '"' {
+			return d.unexpected()
+		}
+		key, err := d.readString()
+		if err != nil {
+			return err
+		}
+		if err := d.consume(':'); err != nil {
+			return err
+		}
+		if err := fn(key); err != nil {
+			return err
+		}
+		if d.peek() != ',' {
+			break
+		}
+		d.pos++
+	}
+	if d.peek() != '}' {
+		return d.unexpected()
+	}
+
+	return d.leave()
+}
+
+// readArray reads array, calling fn for each element. fn should read the element.
+func (d *jsonDecoder) readArray(fn func(i int) error) error {
+	if err := d.enter('['); err != nil {
+		return err
+	}
+	if d.peek() == ']' {
+		return d.leave()
+	}
+	for i := 0; ; i++ {
+		if err := fn(i); err != nil {
+			return err
+		}
+		if d.peek() != ',' {
+			break
+		}
+		d.pos++
+	}
+	if d.peek() != ']' {
+		return d.unexpected()
+	}
+
+	return d.leave()
+}
+
+func (d *jsonDecoder) enter(c byte) error {
+	if err := d.consume(c); err != nil {
+		return err
+	}
+	d.depth++
+	if d.depth > maxJSONDepth {
+		return errors.Errorf("json exceeds nesting limit of %d", maxJSONDepth)
+	}
+
+	return nil
+}
+
+func (d *jsonDecoder) leave() error {
+	d.pos++
+	d.depth--
+
+	return nil
+}
+
+// readValue reads any JSON value the same way, as encoding/json decodes it into interface{}. Numbers are
+// returned as json.Number, if useNumber is set, or as float64.
+func (d *jsonDecoder) readValue(useNumber bool) (interface{}, error) {
+	switch c := d.peek(); {
+	case c == '{':
+		result := map[string]interface{}{}
+		err := d.readObject(func(key string) error {
+			value, err := d.readValue(useNumber)
+			result[key] = value
+			return err
+		})
+		return result, err
+	case c == '[':
+		result := []interface{}{}
+		err := d.readArray(func(int) error {
+			value, err := d.readValue(useNumber)
+			result = append(result, value)
+			return err
+		})
+		return result, err
+	case c == '"':
+		return d.readString()
+	case c == 't':
+		return true, d.readLiteral("true")
+	case c == 'f':
+		return false, d.readLiteral("false")
+	case c == 'n':
+		return nil, d.readLiteral("null")
+	case c == '-' || c >= '0' && c <= '9':
+		number, err := d.readNumber()
+		if err != nil || useNumber {
+			return number, err
+		}
+		value, err := strconv.ParseFloat(string(number), 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse number")
+		}
+		return value, nil
+	}
+
+	return nil, d.unexpected()
+}
+
+// skipValue reads value, ignoring it.
+func (d *jsonDecoder) skipValue() error {
+	_, err := d.readValue(true)
+
+	return err
+}
+
+// readNumber reads number literal, validating it by JSON grammar.
+func (d *jsonDecoder) readNumber() (json.Number, error) {
+	start := d.pos
+	if d.pos < len(d.data) && d.data[d.pos] == '-' {
+		d.pos++
+	}
+	switch {
+	case d.pos < len(d.data) && d.data[d.pos] == '0':
+		d.pos++
+	case !d.readDigits():
+		return "", d.unexpected()
+	}
+	if d.pos < len(d.data) && d.data[d.pos] == '.' {
+		d.pos++
+		if !d.readDigits() {
+			return "", d.unexpected()
+		}
+	}
+	if d.pos < len(d.data) && (d.data[d.pos] == 'e' || d.data[d.pos] == 'E') {
+		d.pos++
+		if d.pos < len(d.data) && (d.data[d.pos] == '+' || d.data[d.pos] == '-') {
+			d.pos++
+		}
+		if !d.readDigits() {
+			return "", d.unexpected()
+		}
+	}
+	if d.pos < len(d.data) && isJSONIdentByte(d.data[d.pos]) {
+		return "", d.unexpected()
+	}
+
+	return json.Number(d.data[start:d.pos]), nil
+}
+
+func (d *jsonDecoder) readDigits() bool {
+	start := d.pos
+	for d.pos < len(d.data) && d.data[d.pos] >= '0' && d.data[d.pos] <= '9' {
+		d.pos++
+	}
+
+	return d.pos > start
+}
+
+// readString reads quoted string, unescaping it. Strings should be valid UTF-8.
+func (d *jsonDecoder) readString() (string, error) {
+	if err := d.consume('"'); err != nil {
+		return "", err
+	}
+	start := d.pos
+	// strings without escapes are sliced as is
+	for d.pos < len(d.data) && d.data[d.pos] != '"' && d.data[d.pos] != '\\' && d.data[d.pos] >= 0x20 {
+		d.pos++
+	}
+	if d.pos < len(d.data) && d.data[d.pos] == '"' {
+		value := d.data[start:d.pos]
+		d.pos++
+		if !utf8.Valid(value) {
+			return "", errors.Errorf("invalid UTF-8 in string at offset %d of json", start)
+		}
+		return string(value), nil
+	}
+	result := append([]byte(nil), d.data[start:d.pos]...)
+	for {
+		if d.pos >= len(d.data) {
+			return "", d.unexpected()
+		}
+		c := d.data[d.pos]
+		switch {
+		case c == '"':
+			d.pos++
+			if !utf8.Valid(result) {
+				return "", errors.Errorf("invalid UTF-8 in string at offset %d of json", start)
+			}
+			return string(result), nil
+		case c < 0x20:
+			return "", d.unexpected()
+		case c != '\\':
+			result = append(result, c)
+			d.pos++
+			continue
+		}
+		d.pos++
+		if d.pos >= len(d.data) {
+			return "", d.unexpected()
+		}
+		c = d.data[d.pos]
+		d.pos++
+		switch c {
+		case '"', '\\', '/':
+			result = append(result, c)
+		case 'b':
+			result = append(result, '\b')
+		case 'f':
+			result = append(result, '\f')
+		case 'n':
+			result = append(result, '\n')
+		case 'r':
+			result = append(result, '\r')
+		case 't':
+			result = append(result, '\t')
+		case 'u':
+			r, err := d.readHex()
+			if err != nil {
+				return "", err
+			}
+			if utf16.IsSurrogate(r) {
+				// second half of surrogate pair should follow, otherwise rune is invalid
+				r2 := utf8.RuneError
+				if d.pos+1 < len(d.data) && d.data[d.pos] == '\\' && d.data[d.pos+1] == 'u' {
+					d.pos += 2
+					if r2, err = d.readHex(); err != nil {
+						return "", err
+					}
+				}
+				if r = utf16.DecodeRune(r, r2); r == utf8.RuneError {
+					return "", errors.Errorf("invalid surrogate pair at offset %d of json", d.pos)
+				}
+			}
+			result = append(result, string(r)...)
+		default:
+			d.pos--
+			return "", d.unexpected()
+		}
+	}
+}
+
+func (d *jsonDecoder) readHex() (rune, error) {
+	if d.pos+4 > len(d.data) {
+		return 0, errors.New("unexpected end of json")
+	}
+	value, err := strconv.ParseUint(string(d.data[d.pos:d.pos+4]), 16, 16)
+	if err != nil {
+		return 0, errors.Errorf("invalid unicode escape at offset %d of json", d.pos)
+	}
+	d.pos += 4
+
+	return rune(value), nil
+}
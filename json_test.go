@@ -0,0 +1,198 @@
+package shprotos
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	full "github.com/saturn4er/shprotos/testdata"
+	"github.com/stretchr/testify/require"
+)
+
+func jsonTestMessage() *full.ComplexMessage {
+	return &full.ComplexMessage{
+		Enum:           full.ComplexMessage_VALUE_A,
+		ScalarInt32:    -10,
+		ScalarInt64:    -9007199254740993,
+		ScalarUint32:   10,
+		ScalarUint64:   18446744073709551615,
+		ScalarSint32:   -50,
+		ScalarSint64:   -500,
+		ScalarFixed32:  1000,
+		ScalarFixed64:  10000,
+		ScalarSfixed32: -100000,
+		ScalarSfixed64: -1000000,
+		ScalarDouble:   math.Inf(-1),
+		ScalarFloat:    -3.5,
+		ScalarBool:     true,
+		ScalarString:   "some \"quoted\" string",
+		Message: &full.ComplexMessage_SimpleMessage{
+			SomeField:  300,
+			SomeField2: "hello",
+		},
+		Bytes: []byte("hello world"),
+		MapEnum: map[int32]full.ComplexMessage_SimpleEnum{
+			-1: full.ComplexMessage_VALUE_A,
+			2:  full.ComplexMessage_VALUE_B8,
+		},
+		MapScalar: map[int32]int32{1: 2},
+		MapMsg: map[string]*full.ComplexMessage_SimpleMessage{
+			"hello": {SomeField: 1, SomeField2: "hello"},
+		},
+		MapBytes:  map[string][]byte{"hello": []byte("world")},
+		MapString: map[string]string{"hello": "world"},
+		REnum: []full.ComplexMessage_SimpleEnum{
+			full.ComplexMessage_VALUE_B7,
+			full.ComplexMessage_VALUE_B8,
+		},
+		RScalar: []int32{1, 2, 3, 4},
+		RMsg: []*full.ComplexMessage_SimpleMessage{
+			{SomeField: 1, SomeField2: "hello"},
+			{SomeField: 2},
+		},
+		RBytes: [][]byte{[]byte("hello"), []byte("world")},
+		Oneof:  &full.ComplexMessage_OneofEnum{OneofEnum: full.ComplexMessage_VALUE_B},
+	}
+}
+
+// requireJSONEq compares compact forms of JSON documents, so formatting of values and order of fields matter.
+func requireJSONEq(t *testing.T, expected, actual []byte) {
+	var expectedCompact, actualCompact bytes.Buffer
+	require.NoError(t, json.Compact(&expectedCompact, expected))
+	require.NoError(t, json.Compact(&actualCompact, actual))
+	require.Equal(t, expectedCompact.String(), actualCompact.String())
+}
+
+func TestJSONMarshaler(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/full.proto", nil, nil)
+	require.NoError(t, err)
+	msgDesc, ok := parsedFile.Message(TypeName{"ComplexMessage"})
+	require.True(t, ok)
+
+	protoMessage := jsonTestMessage()
+	data, err := proto.Marshal(protoMessage)
+	require.NoError(t, err)
+	dynamic, err := UnmarshalMessage(data, msgDesc)
+	require.NoError(t, err)
+
+	for _, options := range []JSONMarshaler{
+		{},
+		{OrigName: true},
+		{EnumsAsInts: true},
+		{Indent: "  "},
+	} {
+		expected, err := (&jsonpb.Marshaler{
+			OrigName:    options.OrigName,
+			EnumsAsInts: options.EnumsAsInts,
+			Indent:      options.Indent,
+		}).MarshalToString(protoMessage)
+		require.NoError(t, err)
+
+		actual, err := options.Marshal(dynamic, msgDesc)
+		require.NoError(t, err)
+		requireJSONEq(t, []byte(expected), actual)
+	}
+
+	actual, err := JSONMarshaler{}.Marshal(map[string]interface{}{"scalar_double": math.NaN()}, msgDesc)
+	require.NoError(t, err)
+	require.Equal(t, `{"scalarDouble":"NaN"}`, string(actual))
+
+	for _, value := range []float64{1e6, 123.25, 1e-6, 1e-7, 1e20, 1e21, -2.5e-10, math.MaxFloat64} {
+		protoMessage := &full.ComplexMessage{ScalarDouble: value, ScalarFloat: float32(value)}
+		expected, err := (&jsonpb.Marshaler{}).MarshalToString(protoMessage)
+		require.NoError(t, err)
+		actual, err := JSONMarshaler{}.Marshal(map[string]interface{}{"scalar_double": value, "scalar_float": float32(value)}, msgDesc)
+		require.NoError(t, err)
+		require.Equal(t, expected, string(actual))
+	}
+}
+
+func TestJSONUnmarshaler(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/full.proto", nil, nil)
+	require.NoError(t, err)
+	msgDesc, ok := parsedFile.Message(TypeName{"ComplexMessage"})
+	require.True(t, ok)
+
+	protoMessage := jsonTestMessage()
+	for _, origName := range []bool{false, true} {
+		jsonData, err := (&jsonpb.Marshaler{OrigName: origName}).MarshalToString(protoMessage)
+		require.NoError(t, err)
+
+		dynamic, err := JSONUnmarshaler{}.Unmarshal([]byte(jsonData), msgDesc)
+		require.NoError(t, err)
+		data, err := MarshalMessage(dynamic, msgDesc)
+		require.NoError(t, err)
+
+		resultMsg := &full.ComplexMessage{}
+		require.NoError(t, proto.Unmarshal(data, resultMsg))
+		require.Equal(t, protoMessage, resultMsg)
+	}
+
+	dynamic, err := JSONUnmarshaler{}.Unmarshal([]byte(`{
+		"scalarInt32": 1e2,
+		"scalar_uint64": "18446744073709551615",
+		"scalarFloat": "Infinity",
+		"bytes": "aGVsbG8-_w",
+		"enum": 2,
+		"mapScalar": {"-5": "7"}
+	}`), msgDesc)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"scalar_int32":  int32(100),
+		"scalar_uint64": uint64(18446744073709551615),
+		"scalar_float":  float32(math.Inf(1)),
+		"bytes":         "aGVsbG8+/w==",
+		"enum":          int32(2),
+		"map_scalar":    map[string]interface{}{"-5": int32(7)},
+	}, dynamic)
+
+	_, err = JSONUnmarshaler{}.Unmarshal([]byte(`{"unknown": 1}`), msgDesc)
+	require.Error(t, err)
+	_, err = JSONUnmarshaler{AllowUnknownFields: true}.Unmarshal([]byte(`{"unknown": 1}`), msgDesc)
+	require.NoError(t, err)
+	_, err = JSONUnmarshaler{}.Unmarshal([]byte(`{"scalarInt32": 1, "scalar_int32": 2}`), msgDesc)
+	require.Error(t, err)
+	_, err = JSONUnmarshaler{}.Unmarshal([]byte(`{"scalarInt32": 1.5}`), msgDesc)
+	require.Error(t, err)
+	_, err = JSONUnmarshaler{}.Unmarshal([]byte(`{"enum": "UNKNOWN_VALUE"}`), msgDesc)
+	require.Error(t, err)
+	_, err = JSONUnmarshaler{}.Unmarshal([]byte(`{"oneofScalar": 1, "oneofEnum": 1}`), msgDesc)
+	require.IsType(t, &OneOfError{}, err)
+
+	dynamic, err = JSONUnmarshaler{}.Unmarshal([]byte(` {"scalarString": "a\"\\\/\b\f\n\r\t\u00e9\ud83d\ude00", "mapString": {}, "rScalar": [],
+		"message": null} `), msgDesc)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"scalar_string": "a\"\\/\b\f\n\r\té😀",
+		"map_string":    map[string]interface{}{},
+		"r_scalar":      []interface{}{},
+	}, dynamic)
+
+	for _, data := range []string{
+		`{"scalarInt32": 1} {}`,
+		`{"scalarInt32": 1,}`,
+		`{"scalarInt32": 01}`,
+		`{"scalarInt32": -}`,
+		`{"scalarBool": tru}`,
+		`{"scalarBool": truex}`,
+		`{"scalarString": "\ud83d"}`,
+		`{"scalarString": "\x"}`,
+		"{\"scalarString\": \"\xff\"}",
+		`{"scalarString": "abc`,
+		`{"mapString": {"a" "b"}}`,
+		`{"rScalar": [1 2]}`,
+		`[]`,
+		``,
+	} {
+		_, err = JSONUnmarshaler{}.Unmarshal([]byte(data), msgDesc)
+		require.Error(t, err, data)
+	}
+	_, err = JSONUnmarshaler{}.Unmarshal([]byte(`{"rScalar": {"a": 1}}`), msgDesc)
+	require.IsType(t, &FieldError{}, err)
+	require.Equal(t, "map[string]interface {}", err.(*FieldError).GoType)
+}
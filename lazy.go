@@ -0,0 +1,79 @@
+package shprotos
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// LazyMessage is a value of message field, which is decoded on first access. It's returned by Unmarshal
+// with LazyMessages option and keeps raw bytes of message, aliasing unmarshalled data. Marshal writes raw
+// bytes of lazy messages, which weren't decoded, as is.
+type LazyMessage struct {
+	msg        *Message
+	raw        []byte
+	options    UnmarshalOptions
+	projection *Projection
+	// depth is nesting level of message, checked against MaxDepth of options.
+	depth int
+
+	once    sync.Once
+	decoded uint32
+	data    map[string]interface{}
+	err     error
+}
+
+// NewLazyMessage returns lazy value of msg, encoded to raw bytes, which is decoded with given options.
+// Projection of options is ignored, as it's applied to the whole unmarshalled message.
+func NewLazyMessage(raw []byte, msg *Message, options UnmarshalOptions) *LazyMessage {
+	if options.Resolver == nil {
+		options.Resolver = defaultResolver(msg)
+	}
+	options.Projection = nil
+
+	return &LazyMessage{msg: msg, raw: raw, options: options, depth: 1}
+}
+
+func (l *LazyMessage) Message() *Message {
+	return l.msg
+}
+
+// Bytes returns raw bytes of message, as they were unmarshalled. They don't reflect changes of decoded map.
+func (l *LazyMessage) Bytes() []byte {
+	return l.raw
+}
+
+// Decoded reports whether message was decoded by Map.
+func (l *LazyMessage) Decoded() bool {
+	return atomic.LoadUint32(&l.decoded) == 1
+}
+
+// Map decodes message on first call and returns the same map on next ones, so its changes are marshalled.
+func (l *LazyMessage) Map() (map[string]interface{}, error) {
+	l.once.Do(func() {
+		l.data, l.err = l.options.unmarshalMessage(l.raw, l.msg, l.projection, l.depth)
+		atomic.StoreUint32(&l.decoded, 1)
+	})
+
+	return l.data, l.err
+}
+
+// messageMap returns fields of value of plain message: map or *LazyMessage of the same message, which is decoded.
+func messageMap(value interface{}, typ *Message, path string) (map[string]interface{}, error) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return value, nil
+	case *LazyMessage:
+		if value.msg.GetFullName() != typ.GetFullName() {
+			return nil, newFieldError(path, protoTypeName(typ), value, errors.Errorf("lazy message is %s", value.msg.GetFullName()))
+		}
+		data, err := value.Map()
+		if err != nil {
+			return nil, newFieldError(path, protoTypeName(typ), value, err)
+		}
+		return data, nil
+	}
+
+	return nil, newFieldError(path, protoTypeName(typ), value, nil)
+}
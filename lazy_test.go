@@ -0,0 +1,127 @@
+package shprotos
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	full "github.com/saturn4er/shprotos/testdata"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnmarshalAliasBytes(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/full.proto", nil, nil)
+	require.NoError(t, err)
+	msg, ok := parsedFile.Message(TypeName{"ComplexMessage"})
+	require.True(t, ok)
+
+	goMsg := &full.ComplexMessage{
+		Bytes:    []byte("payload"),
+		MapBytes: map[string][]byte{"a": []byte("value")},
+		RBytes:   [][]byte{[]byte("x"), {}},
+	}
+	data, err := proto.Marshal(goMsg)
+	require.NoError(t, err)
+
+	res, err := UnmarshalOptions{AliasBytes: true, EmitDefaults: true}.Unmarshal(data, msg)
+	require.NoError(t, err)
+	require.Equal(t, []byte("payload"), res["bytes"])
+	require.Equal(t, map[string]interface{}{"a": []byte("value")}, res["map_bytes"])
+	require.Equal(t, []interface{}{[]byte("x"), []byte{}}, res["r_bytes"])
+	require.Equal(t, "", res["scalar_string"])
+
+	// values alias unmarshalled data
+	payload := res["bytes"].([]byte)
+	payload[0] = 'P'
+	require.Contains(t, string(data), "Payload")
+	payload[0] = 'p'
+
+	marshalled, err := MarshalMessage(res, msg)
+	require.NoError(t, err)
+	resultMsg := &full.ComplexMessage{}
+	require.NoError(t, proto.Unmarshal(marshalled, resultMsg))
+	require.True(t, proto.Equal(goMsg, resultMsg))
+
+	fromGo, err := UnmarshalOptions{AliasBytes: true}.FromGoMessage(goMsg, msg)
+	require.NoError(t, err)
+	require.Equal(t, []byte("payload"), fromGo["bytes"])
+
+	res, err = UnmarshalOptions{AliasBytes: true, EmitDefaults: true}.Unmarshal(nil, msg)
+	require.NoError(t, err)
+	require.Equal(t, []byte{}, res["bytes"])
+}
+
+func TestUnmarshalLazyMessages(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/full.proto", nil, nil)
+	require.NoError(t, err)
+	msg, ok := parsedFile.Message(TypeName{"ComplexMessage"})
+	require.True(t, ok)
+
+	goMsg := &full.ComplexMessage{
+		ScalarInt32: 1,
+		Message:     &full.ComplexMessage_SimpleMessage{SomeField: 2, SomeField2: "nested"},
+		MapMsg:      map[string]*full.ComplexMessage_SimpleMessage{"a": {SomeField: 3}},
+		RMsg:        []*full.ComplexMessage_SimpleMessage{{SomeField: 4}, {SomeField2: "b"}},
+	}
+	data, err := proto.Marshal(goMsg)
+	require.NoError(t, err)
+
+	options := UnmarshalOptions{LazyMessages: true, AliasBytes: true}
+	res, err := options.Unmarshal(data, msg)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), res["scalar_int32"])
+	nested, ok := res["message"].(*LazyMessage)
+	require.True(t, ok)
+	require.False(t, nested.Decoded())
+	require.Equal(t, "SimpleMessage", nested.Message().Name)
+	require.IsType(t, &LazyMessage{}, res["map_msg"].(map[string]interface{})["a"])
+	require.IsType(t, &LazyMessage{}, res["r_msg"].([]interface{})[1])
+
+	// untouched messages are marshalled as is
+	marshalled, err := MarshalMessage(res, msg)
+	require.NoError(t, err)
+	require.False(t, nested.Decoded())
+	resultMsg := &full.ComplexMessage{}
+	require.NoError(t, proto.Unmarshal(marshalled, resultMsg))
+	require.True(t, proto.Equal(goMsg, resultMsg))
+
+	// changes of decoded message are marshalled
+	nestedData, err := nested.Map()
+	require.NoError(t, err)
+	require.True(t, nested.Decoded())
+	require.Equal(t, map[string]interface{}{"some_field": int32(2), "some_field2": "nested"}, nestedData)
+	nestedData["some_field"] = 20
+	marshalled, err = MarshalMessage(res, msg)
+	require.NoError(t, err)
+	resultMsg = &full.ComplexMessage{}
+	require.NoError(t, proto.Unmarshal(marshalled, resultMsg))
+	require.Equal(t, int32(20), resultMsg.Message.SomeField)
+	require.Equal(t, int32(3), resultMsg.MapMsg["a"].SomeField)
+
+	jsonData, err := JSONMarshaler{}.Marshal(res, msg)
+	require.NoError(t, err)
+	require.Contains(t, string(jsonData), `"someField":20`)
+	goResult := &full.ComplexMessage{}
+	require.NoError(t, ToGoMessage(res, msg, goResult))
+	require.Equal(t, int32(4), goResult.RMsg[0].SomeField)
+
+	// broken messages fail only when they are decoded
+	lazy := NewLazyMessage([]byte{0x12, 0x05}, nested.Message(), UnmarshalOptions{})
+	_, err = lazy.Map()
+	require.Error(t, err)
+	_, err = MarshalMessage(map[string]interface{}{"message": lazy}, msg)
+	require.IsType(t, &FieldError{}, err)
+
+	// lazy messages keep projection and defaults options
+	projection, err := NewProjection(msg, "r_msg.*.some_field")
+	require.NoError(t, err)
+	res, err = UnmarshalOptions{LazyMessages: true, EmitDefaults: true, Projection: projection}.Unmarshal(data, msg)
+	require.NoError(t, err)
+	element, err := res["r_msg"].([]interface{})[1].(*LazyMessage).Map()
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"some_field": int32(0)}, element)
+
+	_, err = MarshalMessage(map[string]interface{}{"message": NewLazyMessage(nil, msg, UnmarshalOptions{})}, msg)
+	require.IsType(t, &FieldError{}, err)
+}
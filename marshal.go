@@ -2,233 +2,476 @@ package shprotos
 
 import (
 	"encoding/base64"
+	"fmt"
+	"io"
 	"math"
 	"reflect"
+	"sort"
 	"strconv"
+	"sync"
 
-	"github.com/golang/protobuf/proto"
 	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
+type MarshalOptions struct {
+	// AllowOneOfConflicts makes marshal keep only the last declared member of a oneof, when several members
+	// are set, instead of returning *OneOfError.
+	AllowOneOfConflicts bool
+	// Resolver resolves type URLs of google.protobuf.Any values. If it's nil, messages are looked up
+	// in file of marshalled message and its imports.
+	Resolver MessageResolver
+	// Deterministic emits fields in key number order and map entries ordered by key, so the same value
+	// is always marshalled to the same bytes.
+	Deterministic bool
+}
+
 func MarshalMessage(data map[string]interface{}, message *Message) ([]byte, error) {
-	res := proto.NewBuffer(nil)
-	err := marshalMessage(res, data, message)
+	return MarshalOptions{}.Marshal(data, message)
+}
+
+// MarshalMessageAppend appends marshalled message to b.
+func MarshalMessageAppend(b []byte, data map[string]interface{}, message *Message) ([]byte, error) {
+	return MarshalOptions{}.MarshalAppend(b, data, message)
+}
+
+// MarshalMessageTo writes marshalled message to w.
+func MarshalMessageTo(w io.Writer, data map[string]interface{}, message *Message) error {
+	return MarshalOptions{}.MarshalTo(w, data, message)
+}
+
+func (o MarshalOptions) Marshal(data map[string]interface{}, message *Message) ([]byte, error) {
+	return o.MarshalAppend(nil, data, message)
+}
+
+// MarshalAppend appends marshalled message to b. It grows b at most once, to the size of message,
+// computed before encoding.
+func (o MarshalOptions) MarshalAppend(b []byte, data map[string]interface{}, message *Message) ([]byte, error) {
+	if o.Resolver == nil {
+		o.Resolver = defaultResolver(message)
+	}
+	return o.marshalAppend(b, data, message, "")
+}
+
+// marshalBuffers are reused by MarshalTo, because writers must not retain written bytes.
+var marshalBuffers = sync.Pool{
+	New: func() interface{} {
+		return new([]byte)
+	},
+}
+
+// MarshalTo writes marshalled message to w with single Write call.
+func (o MarshalOptions) MarshalTo(w io.Writer, data map[string]interface{}, message *Message) error {
+	buffer := marshalBuffers.Get().(*[]byte)
+	defer marshalBuffers.Put(buffer)
+	b, err := o.MarshalAppend((*buffer)[:0], data, message)
+	if err != nil {
+		return err
+	}
+	*buffer = b
+	if _, err := w.Write(b); err != nil {
+		return errors.Wrap(err, "failed to write message")
+	}
+	return nil
+}
+
+// marshalAppend computes size of message, grows b to fit it and appends encoded message. Errors about
+// wrong values are returned as *FieldError with path, prefixed with given one.
+func (o MarshalOptions) marshalAppend(b []byte, data map[string]interface{}, message *Message, path string) ([]byte, error) {
+	e := &encoder{o: o}
+	size, err := e.messageSize(data, message, path)
 	if err != nil {
 		return nil, err
 	}
-	return res.Bytes(), nil
+	if cap(b)-len(b) < size {
+		grown := make([]byte, len(b), len(b)+size)
+		copy(grown, b)
+		b = grown
+	}
+	return e.appendMessage(b, data, message)
+}
+
+// encoder marshals message in two passes. Size pass validates values and records sizes of length-delimited
+// values, data of embedded messages, keys of maps and decoded base64 bytes in encoding order. Append pass
+// consumes them in the same order and writes length prefixes directly before values, so every value
+// is encoded once.
+type encoder struct {
+	o        MarshalOptions
+	sizes    []int
+	messages []embeddedMessage
+	keys     [][]reflect.Value
+	bytes    [][]byte
+	// nextSize, nextMessage, nextKeys and nextBytes are positions of append pass in recorded values.
+	nextSize    int
+	nextMessage int
+	nextKeys    int
+	nextBytes   int
+}
+
+// embeddedMessage is data of embedded message, recorded by size pass, or raw bytes of lazy message,
+// which wasn't decoded.
+type embeddedMessage struct {
+	data map[string]interface{}
+	raw  []byte
 }
 
-func marshalMessage(buffer *proto.Buffer, data map[string]interface{}, message *Message) error {
-	for _, field := range message.GetFields() {
-		fieldValue, ok := data[field.GetName()]
-		if !ok {
+// reserveSize records placeholder for size of length-delimited value, which is set after its content is sized.
+func (e *encoder) reserveSize() int {
+	e.sizes = append(e.sizes, 0)
+	return len(e.sizes) - 1
+}
+
+func (e *encoder) appendSize(b []byte) []byte {
+	size := e.sizes[e.nextSize]
+	e.nextSize++
+	return protowire.AppendVarint(b, uint64(size))
+}
+
+// appendMessage is append pass of message. Values were validated by size pass, so errors here are possible
+// only, if data was changed concurrently, and they don't have paths.
+func (e *encoder) appendMessage(b []byte, data map[string]interface{}, message *Message) ([]byte, error) {
+	skipFields, err := e.o.resolveOneOfs(data, message, "")
+	if err != nil {
+		return nil, err
+	}
+	plan := planOf(message)
+	fields := plan.fields
+	if e.o.Deterministic {
+		fields = plan.ordered
+	}
+	for _, fp := range fields {
+		fieldValue, ok := data[fp.name]
+		if !ok || (fieldValue == nil && !holdsNullValue(fp.field)) || skipFields[fp.name] {
 			continue
 		}
-		switch fld := field.(type) {
-		case *NormalField:
-			if fld.IsRepeated() {
-				if err := marshalMessageNormalRepeatedField(buffer, fieldValue, fld.Type, fld.KeyNumber); err != nil {
-					return errors.Wrapf(err, "failed to marshal normal repeated field %s", field.GetName())
-				}
-			} else {
-				if err := marshalMessageNormalField(buffer, fieldValue, fld.Type, fld.KeyNumber); err != nil {
-					return errors.Wrapf(err, "failed to marshal normal field %s", field.GetName())
-				}
-			}
-		case *MapField:
-			mapValue := reflect.ValueOf(fieldValue)
-			iter := mapValue.MapRange()
-			for iter.Next() {
-				mapBuffer := proto.NewBuffer(nil)
-				mapKey := iter.Key().Interface()
-				mapValue := iter.Value().Interface()
-				if err := buffer.EncodeVarint(messageKeyVarint(fld.KeyNumber, WireTypeLengthDelimited)); err != nil {
-					return errors.Wrap(err, "failed to write field key")
-				}
-				if err := marshalMessageNormalField(mapBuffer, mapKey, fld.Map.KeyType, 1); err != nil {
-					return errors.Wrap(err, "failed to marshal map key")
-				}
-				if err := marshalMessageNormalField(mapBuffer, mapValue, fld.Map.ValueType, 2); err != nil {
-					return errors.Wrap(err, "failed to marshal map key")
-				}
-				if err := buffer.EncodeRawBytes(mapBuffer.Bytes()); err != nil {
-					return errors.Wrap(err, "failed to encode map buffer bytes")
-				}
-			}
+		switch {
+		case fp.mapField != nil:
+			b, err = e.appendMapField(b, fieldValue, fp)
+		case fp.normal.Repeated:
+			b, err = e.appendRepeatedField(b, fieldValue, fp)
+		default:
+			b, err = e.appendField(b, fieldValue, fp)
+		}
+		if err != nil {
+			return nil, err
 		}
 	}
-	return nil
+	if unknown, ok := data[UnknownFieldsKey]; ok {
+		raw, err := bytesFromInterface(unknown)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to resolve unknown fields")
+		}
+		b = append(b, raw...)
+	}
+	return b, nil
 }
 
-func marshalMessageNormalField(buffer *proto.Buffer, value interface{}, typ Type, keyNumber uint64) error {
-	switch typ := typ.(type) {
-	case *Scalar:
-		switch typ.ScalarName {
-		case "string":
-			if err := buffer.EncodeVarint(messageKeyVarint(keyNumber, WireTypeLengthDelimited)); err != nil {
-				return errors.Wrap(err, "failed to write field key")
-			}
-			if err := buffer.EncodeStringBytes(value.(string)); err != nil {
-				return errors.Wrap(err, "failed to encode string bytes")
-			}
-		case "bytes":
-			if err := buffer.EncodeVarint(messageKeyVarint(keyNumber, WireTypeLengthDelimited)); err != nil {
-				return errors.Wrap(err, "failed to write field key")
-			}
-			value, err := base64.StdEncoding.DecodeString(value.(string))
-			if err != nil {
-				return errors.Wrap(err, "failed to encode base64 string")
-			}
-			if err := buffer.EncodeRawBytes(value); err != nil {
-				return errors.Wrap(err, "failed to encode string bytes")
-			}
-		case "bool":
-			if err := buffer.EncodeVarint(messageKeyVarint(keyNumber, WireTypeVarint)); err != nil {
-				return errors.Wrap(err, "failed to write field key")
-			}
-			res := uint64(0)
-			if value.(bool) {
-				res = 1
-			}
-			if err := buffer.EncodeVarint(res); err != nil {
-				return errors.Wrap(err, "failed to encode bool")
-			}
-		case "sfixed32", "fixed32":
-			if err := buffer.EncodeVarint(messageKeyVarint(keyNumber, WireType32Bit)); err != nil {
-				return errors.Wrap(err, "failed to write field key")
-			}
-			val, err := uint64FromInterface(value)
-			if err != nil {
-				return errors.Wrap(err, "failed to resolve value")
-			}
-			if err := buffer.EncodeFixed32(val); err != nil {
-				return errors.Wrap(err, "failed to encode fixed 32")
-			}
-		case "sfixed64", "fixed64":
-			if err := buffer.EncodeVarint(messageKeyVarint(keyNumber, WireType64Bit)); err != nil {
-				return errors.Wrap(err, "failed to write field key")
-			}
-			val, err := uint64FromInterface(value)
-			if err != nil {
-				return errors.Wrap(err, "failed to resolve value")
-			}
-			if err := buffer.EncodeFixed64(val); err != nil {
-				return errors.Wrap(err, "failed to encode fixed 64")
-			}
-		case "sint32":
-			if err := buffer.EncodeVarint(messageKeyVarint(keyNumber, WireTypeVarint)); err != nil {
-				return errors.Wrap(err, "failed to write field key")
-			}
-			val, err := uint64FromInterface(value)
-			if err != nil {
-				return errors.Wrap(err, "failed to resolve value")
-			}
-			if err := buffer.EncodeZigzag32(val); err != nil {
-				return errors.Wrap(err, "failed to encode zigzag 32")
-			}
-		case "sint64":
-			if err := buffer.EncodeVarint(messageKeyVarint(keyNumber, WireTypeVarint)); err != nil {
-				return errors.Wrap(err, "failed to write field key")
-			}
-			val, err := uint64FromInterface(value)
-			if err != nil {
-				return errors.Wrap(err, "failed to resolve value")
-			}
-			if err := buffer.EncodeZigzag64(val); err != nil {
-				return errors.Wrap(err, "failed to encode zigzag 64")
-			}
-		case "float":
-			if err := buffer.EncodeVarint(messageKeyVarint(keyNumber, WireType32Bit)); err != nil {
-				return errors.Wrap(err, "failed to write field key")
-			}
-			fl32, err := float32FromInterface(value)
-			if err != nil {
-				return errors.Wrap(err, "failed to resolve value")
-			}
-			if err := buffer.EncodeFixed32(uint64(math.Float32bits(fl32))); err != nil {
-				return errors.Wrap(err, "failed to encode fixed 32")
-			}
-		case "double":
-			if err := buffer.EncodeVarint(messageKeyVarint(keyNumber, WireType64Bit)); err != nil {
-				return errors.Wrap(err, "failed to write field key")
-			}
-			fl64, err := float64FromInterface(value)
-			if err != nil {
-				return errors.Wrap(err, "failed to resolve value")
-			}
-			if err := buffer.EncodeFixed64(math.Float64bits(fl64)); err != nil {
-				return errors.Wrap(err, "failed to encode fixed 64")
-			}
-		default:
-			if err := buffer.EncodeVarint(messageKeyVarint(keyNumber, WireTypeVarint)); err != nil {
-				return errors.Wrap(err, "failed to write field key")
-			}
-			val, err := uint64FromInterface(value)
-			if err != nil {
-				return errors.Wrap(err, "failed to resolve value")
-			}
-			if err := buffer.EncodeVarint(val); err != nil {
-				return errors.Wrap(err, "failed to encode fixed 64")
+// resolveOneOfs checks, that only one member of each oneof is set, and returns names of members,
+// which should be skipped in lenient mode.
+func (o MarshalOptions) resolveOneOfs(data map[string]interface{}, message *Message, path string) (map[string]bool, error) {
+	var skipFields map[string]bool
+	for _, oneOf := range message.OneOffs {
+		var set []string
+		for _, fld := range oneOf.Fields {
+			if data[fld.Name] != nil {
+				set = append(set, fld.Name)
 			}
 		}
-	case *Enum:
-		if err := buffer.EncodeVarint(messageKeyVarint(keyNumber, WireTypeVarint)); err != nil {
-			return errors.Wrap(err, "failed to write field key")
+		if len(set) < 2 {
+			continue
 		}
-		res, err := uint64FromInterface(value)
-		if err != nil {
-			return errors.Wrap(err, "failed to resolve enum value")
+		if !o.AllowOneOfConflicts {
+			return nil, &OneOfError{Path: path, OneOf: oneOf.Name, Fields: set}
 		}
-		if err := buffer.EncodeVarint(res); err != nil {
-			return errors.Wrap(err, "failed to encode bool")
+		if skipFields == nil {
+			skipFields = make(map[string]bool)
 		}
-	case *Message:
-		if err := buffer.EncodeVarint(messageKeyVarint(keyNumber, WireTypeLengthDelimited)); err != nil {
-			return errors.Wrap(err, "failed to write field key")
+		for _, name := range set[:len(set)-1] {
+			skipFields[name] = true
 		}
-		msgData, err := MarshalMessage(value.(map[string]interface{}), typ)
-		if err != nil {
-			return errors.Wrap(err, "failed to marshal message")
+	}
+	return skipFields, nil
+}
+
+func (e *encoder) appendMapField(b []byte, value interface{}, fp *fieldPlan) ([]byte, error) {
+	mapValue := reflect.ValueOf(value)
+	keys := e.keys[e.nextKeys]
+	e.nextKeys++
+	var err error
+	for _, key := range keys {
+		entryValue := mapValue.MapIndex(key).Interface()
+		b = append(b, fp.key...)
+		b = e.appendSize(b)
+		if b, err = e.appendField(b, key.Interface(), fp.mapKey); err != nil {
+			return nil, err
 		}
-		if err := buffer.EncodeRawBytes(msgData); err != nil {
-			return errors.Wrap(err, "failed to encode message")
+		// nil google.protobuf.Value is a null value, not a missing one
+		if entryValue != nil || isWellKnownType(fp.mapField.Map.ValueType, wktValue) {
+			if b, err = e.appendField(b, entryValue, fp.mapValue); err != nil {
+				return nil, err
+			}
 		}
-
 	}
-	return nil
+	return b, nil
 }
 
-func marshalMessageNormalRepeatedField(buffer *proto.Buffer, value interface{}, typ Type, keyNumber uint64) error {
-	switch typ := typ.(type) {
-	case *Scalar, *Message:
-		values := value.([]interface{})
-		for _, value := range values {
-			if err := marshalMessageNormalField(buffer, value, typ, keyNumber); err != nil {
-				return errors.Wrap(err, "failed to marshal message normal field")
+func (e *encoder) appendRepeatedField(b []byte, value interface{}, fp *fieldPlan) ([]byte, error) {
+	values := reflect.ValueOf(value)
+	var err error
+	if !fp.packed {
+		for i := 0; i < values.Len(); i++ {
+			if b, err = e.appendField(b, values.Index(i).Interface(), fp); err != nil {
+				return nil, err
 			}
 		}
+		return b, nil
+	}
+	if values.Len() == 0 {
+		return b, nil
+	}
+	b = append(b, fp.packedKey...)
+	b = e.appendSize(b)
+	for i := 0; i < values.Len(); i++ {
+		if b, err = e.appendElement(b, values.Index(i).Interface(), fp); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// appendField encodes single field value with its pre-encoded key.
+func (e *encoder) appendField(b []byte, value interface{}, fp *fieldPlan) ([]byte, error) {
+	return e.appendElement(append(b, fp.key...), value, fp)
+}
+
+// appendElement encodes single value of field without key. Scalars are encoded by their encoders directly.
+func (e *encoder) appendElement(b []byte, value interface{}, fp *fieldPlan) ([]byte, error) {
+	if fp.scalar == ScalarUndefined {
+		return e.appendValue(b, value, fp.normal.Type)
+	}
+	return e.appendScalar(b, value, fp.scalar)
+}
+
+// appendValue encodes single value of given type without field key.
+func (e *encoder) appendValue(b []byte, value interface{}, typ Type) ([]byte, error) {
+	switch typ := typ.(type) {
+	case *Scalar:
+		return e.appendScalar(b, value, typ.scalarKind())
 	case *Enum:
-		fieldBuffer := proto.NewBuffer(nil)
-		values := value.([]interface{})
-		for _, value := range values {
-			enumValue, err := uint64FromInterface(value)
-			if err != nil {
-				return errors.Wrap(err, "failed to get enum value")
+		number, _, err := resolveEnumValue(value, typ)
+		if err != nil {
+			return nil, err
+		}
+		return protowire.AppendVarint(b, uint64(number)), nil
+	case *Message:
+		embedded := e.messages[e.nextMessage]
+		e.nextMessage++
+		if embedded.data == nil {
+			return append(e.appendSize(b), embedded.raw...), nil
+		}
+		return e.appendMessage(e.appendSize(b), embedded.data, typ)
+	}
+	return b, nil
+}
+
+// appendScalar encodes single scalar value. Bytes, given as base64 strings, were decoded by size pass.
+func (e *encoder) appendScalar(b []byte, value interface{}, kind ScalarKind) ([]byte, error) {
+	if _, ok := value.(string); ok && kind == ScalarBytes {
+		data := e.bytes[e.nextBytes]
+		e.nextBytes++
+		return protowire.AppendBytes(b, data), nil
+	}
+	return scalarEncoders[kind](b, value)
+}
+
+// sortedMapKeys returns keys of map value, ordered by their value of key type: numerically for integral keys,
+// false before true for bool ones and lexicographically for strings. Keys may be given in any form,
+// accepted by marshal, like strings for integral keys.
+func sortedMapKeys(mapValue reflect.Value, keyType Type, path string) ([]reflect.Value, error) {
+	keys := mapValue.MapKeys()
+	scalar, ok := keyType.(*Scalar)
+	if !ok {
+		return nil, newFieldError(path, protoTypeName(keyType), mapValue.Interface(), errors.New("invalid map key type"))
+	}
+	sortKeys := make([]uint64, len(keys))
+	strKeys := make([]string, len(keys))
+	for i, key := range keys {
+		var err error
+		switch {
+		case scalar.scalarKind() == ScalarString:
+			strKeys[i], ok = key.Interface().(string)
+			if !ok {
+				err = errors.Errorf("can't convert %T to string", key.Interface())
+			}
+		case scalar.scalarKind() == ScalarBool:
+			var boolKey bool
+			if boolKey, err = boolFromInterface(key.Interface()); boolKey {
+				sortKeys[i] = 1
 			}
-			if err := fieldBuffer.EncodeVarint(enumValue); err != nil {
-				return errors.Wrap(err, "failed to encode enum array value")
+		default:
+			sortKeys[i], err = uint64FromInterface(key.Interface())
+			if scalar.scalarKind().IsSigned() {
+				// flip sign bit, so negative numbers go first in unsigned order
+				sortKeys[i] ^= 1 << 63
 			}
 		}
-		if err := buffer.EncodeVarint(messageKeyVarint(keyNumber, WireTypeLengthDelimited)); err != nil {
-			return errors.Wrap(err, "failed to write field key")
+		if err != nil {
+			keyPath := fmt.Sprintf("%s[%v]", path, key.Interface())
+			return nil, newFieldError(keyPath, protoTypeName(keyType), key.Interface(), err)
 		}
-		if err := buffer.EncodeRawBytes(fieldBuffer.Bytes()); err != nil {
-			return errors.Wrap(err, "failed to encode enum array field")
+	}
+	indexes := make([]int, len(keys))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		a, b := indexes[i], indexes[j]
+		if strKeys[a] != strKeys[b] {
+			return strKeys[a] < strKeys[b]
 		}
+		return sortKeys[a] < sortKeys[b]
+	})
+	result := make([]reflect.Value, len(keys))
+	for i, index := range indexes {
+		result[i] = keys[index]
+	}
 
+	return result, nil
+}
+
+// messageData returns message map of value, converting canonical representations of well-known messages,
+// decoding lazy messages and packing expanded google.protobuf.Any values.
+func (o MarshalOptions) messageData(value interface{}, typ *Message, path string) (map[string]interface{}, error) {
+	if wkt, _, ok := wellKnownTypeOf(typ); ok {
+		data, err := wkt.toMessage(value, typ)
+		if err != nil {
+			return nil, newFieldError(path, protoTypeName(typ), value, err)
+		}
+		return data, nil
 	}
-	return nil
+	data, err := messageMap(value, typ, path)
+	if err != nil {
+		return nil, err
+	}
+	if typ.GetFullName() == wktAny {
+		return o.packAny(data, path)
+	}
+	return data, nil
+}
+
+// scalarEncoders append single value of each scalar kind. They return errors of values, which can't be
+// converted to the kind.
+var scalarEncoders = [...]func(b []byte, value interface{}) ([]byte, error){
+	ScalarDouble:   appendDouble,
+	ScalarFloat:    appendFloat,
+	ScalarInt32:    appendVarint,
+	ScalarInt64:    appendVarint,
+	ScalarUint32:   appendVarint,
+	ScalarUint64:   appendVarint,
+	ScalarSint32:   appendZigzag32,
+	ScalarSint64:   appendZigzag64,
+	ScalarFixed32:  appendFixed32,
+	ScalarFixed64:  appendFixed64,
+	ScalarSfixed32: appendFixed32,
+	ScalarSfixed64: appendFixed64,
+	ScalarBool:     appendBool,
+	ScalarString:   appendString,
+	ScalarBytes:    appendBytes,
+}
+
+func appendDouble(b []byte, value interface{}) ([]byte, error) {
+	fl64, err := float64FromInterface(value)
+	if err != nil {
+		return nil, err
+	}
+	return protowire.AppendFixed64(b, math.Float64bits(fl64)), nil
+}
+
+func appendFloat(b []byte, value interface{}) ([]byte, error) {
+	fl32, err := float32FromInterface(value)
+	if err != nil {
+		return nil, err
+	}
+	return protowire.AppendFixed32(b, math.Float32bits(fl32)), nil
+}
+
+func appendVarint(b []byte, value interface{}) ([]byte, error) {
+	val, err := uint64FromInterface(value)
+	if err != nil {
+		return nil, err
+	}
+	return protowire.AppendVarint(b, val), nil
+}
+
+func appendZigzag32(b []byte, value interface{}) ([]byte, error) {
+	val, err := uint64FromInterface(value)
+	if err != nil {
+		return nil, err
+	}
+	return protowire.AppendVarint(b, protowire.EncodeZigZag(int64(int32(val)))), nil
+}
+
+func appendZigzag64(b []byte, value interface{}) ([]byte, error) {
+	val, err := uint64FromInterface(value)
+	if err != nil {
+		return nil, err
+	}
+	return protowire.AppendVarint(b, protowire.EncodeZigZag(int64(val))), nil
+}
+
+func appendFixed32(b []byte, value interface{}) ([]byte, error) {
+	val, err := uint64FromInterface(value)
+	if err != nil {
+		return nil, err
+	}
+	return protowire.AppendFixed32(b, uint32(val)), nil
+}
+
+func appendFixed64(b []byte, value interface{}) ([]byte, error) {
+	val, err := uint64FromInterface(value)
+	if err != nil {
+		return nil, err
+	}
+	return protowire.AppendFixed64(b, val), nil
+}
+
+func appendBool(b []byte, value interface{}) ([]byte, error) {
+	boolValue, err := boolFromInterface(value)
+	if err != nil {
+		return nil, err
+	}
+	return protowire.AppendVarint(b, protowire.EncodeBool(boolValue)), nil
+}
+
+func appendString(b []byte, value interface{}) ([]byte, error) {
+	str, ok := value.(string)
+	if !ok {
+		return nil, errors.Errorf("can't convert %T to string", value)
+	}
+	return protowire.AppendString(b, str), nil
+}
+
+func appendBytes(b []byte, value interface{}) ([]byte, error) {
+	bytesValue, err := bytesFromInterface(value)
+	if err != nil {
+		return nil, err
+	}
+	return protowire.AppendBytes(b, bytesValue), nil
+}
+
+// protoTypeName returns type name as it's written in proto file.
+func protoTypeName(typ Type) string {
+	switch typ := typ.(type) {
+	case *Scalar:
+		return typ.ScalarName
+	case *Enum:
+		return typ.GetFullName()
+	case *Message:
+		return typ.GetFullName()
+	case *Map:
+		return "map<" + protoTypeName(typ.KeyType) + ", " + protoTypeName(typ.ValueType) + ">"
+	}
+	return typ.String()
 }
 
 func messageKeyVarint(fieldNum uint64, wireType uint64) uint64 {
@@ -261,13 +504,47 @@ func uint64FromInterface(val interface{}) (uint64, error) {
 		return uint64(val), nil
 	case string:
 		v, err := strconv.ParseInt(val, 10, 64)
-		if err != nil {
+		if err == nil {
+			return uint64(v), nil
+		}
+		uv, uerr := strconv.ParseUint(val, 10, 64)
+		if uerr != nil {
 			return 0, errors.Wrap(err, "failed to parse int from string")
 		}
-		return uint64(v), nil
+		return uv, nil
 	}
 	return 0, errors.Errorf("can't convert %T to uint64", val)
 }
+
+// bytesFromInterface accepts raw bytes or their base64 representation.
+func bytesFromInterface(val interface{}) ([]byte, error) {
+	switch val := val.(type) {
+	case []byte:
+		return val, nil
+	case string:
+		v, err := base64.StdEncoding.DecodeString(val)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode base64 string")
+		}
+		return v, nil
+	}
+	return nil, errors.Errorf("can't convert %T to bytes", val)
+}
+
+// boolFromInterface also accepts "true" and "false" strings, which are bool map keys.
+func boolFromInterface(val interface{}) (bool, error) {
+	switch val := val.(type) {
+	case bool:
+		return val, nil
+	case string:
+		v, err := strconv.ParseBool(val)
+		if err != nil {
+			return false, errors.Wrap(err, "failed to parse bool from string")
+		}
+		return v, nil
+	}
+	return false, errors.Errorf("can't convert %T to bool", val)
+}
 func float64FromInterface(val interface{}) (float64, error) {
 	switch val := val.(type) {
 	case int:
@@ -299,7 +576,7 @@ func float64FromInterface(val interface{}) (float64, error) {
 		}
 		return v, nil
 	}
-	return 0, errors.Errorf("can't convert %T to uint64", val)
+	return 0, errors.Errorf("can't convert %T to float64", val)
 }
 func float32FromInterface(val interface{}) (float32, error) {
 	switch val := val.(type) {
@@ -332,5 +609,5 @@ func float32FromInterface(val interface{}) (float32, error) {
 		}
 		return float32(v), nil
 	}
-	return 0, errors.Errorf("can't convert %T to uint64", val)
+	return 0, errors.Errorf("can't convert %T to float32", val)
 }
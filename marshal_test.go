@@ -1,7 +1,9 @@
 package shprotos
 
 import (
+	"bytes"
 	"encoding/json"
+	"math"
 	"testing"
 
 	"github.com/davecgh/go-spew/spew"
@@ -165,3 +167,348 @@ func TestMarshalMessage(t *testing.T) {
 	require.Equal(t, protoMessage, resultMsg)
 
 }
+
+func TestMarshalMessagePacked(t *testing.T) {
+	parser := Parser{}
+	proto2File, err := parser.Parse("./testdata/packed2.proto", nil, nil)
+	require.NoError(t, err)
+	proto3File, err := parser.Parse("./testdata/packed3.proto", nil, nil)
+	require.NoError(t, err)
+	proto2Msg, ok := proto2File.Message(TypeName{"Proto2Repeated"})
+	require.True(t, ok)
+	proto3Msg, ok := proto3File.Message(TypeName{"Proto3Repeated"})
+	require.True(t, ok)
+
+	expected := proto.NewBuffer(nil)
+	for _, v := range []uint64{1, 2} {
+		require.NoError(t, expected.EncodeVarint(messageKeyVarint(1, WireTypeVarint)))
+		require.NoError(t, expected.EncodeVarint(v))
+	}
+	packed := proto.NewBuffer(nil)
+	require.NoError(t, packed.EncodeZigzag64(uint64(3)))
+	require.NoError(t, packed.EncodeZigzag64(uint64(4)))
+	require.NoError(t, expected.EncodeVarint(messageKeyVarint(2, WireTypeLengthDelimited)))
+	require.NoError(t, expected.EncodeRawBytes(packed.Bytes()))
+	require.NoError(t, expected.EncodeVarint(messageKeyVarint(3, WireTypeVarint)))
+	require.NoError(t, expected.EncodeVarint(1))
+
+	res, err := MarshalMessage(map[string]interface{}{
+		"unpacked": []interface{}{1, 2},
+		"packed":   []interface{}{3, 4},
+		"kinds":    []interface{}{1},
+	}, proto2Msg)
+	require.NoError(t, err)
+	require.Equal(t, expected.Bytes(), res)
+
+	expected = proto.NewBuffer(nil)
+	packed = proto.NewBuffer(nil)
+	require.NoError(t, packed.EncodeFixed32(5))
+	require.NoError(t, packed.EncodeFixed32(6))
+	require.NoError(t, expected.EncodeVarint(messageKeyVarint(1, WireTypeLengthDelimited)))
+	require.NoError(t, expected.EncodeRawBytes(packed.Bytes()))
+	require.NoError(t, expected.EncodeVarint(messageKeyVarint(2, WireType64Bit)))
+	require.NoError(t, expected.EncodeFixed64(math.Float64bits(1.5)))
+	require.NoError(t, expected.EncodeVarint(messageKeyVarint(3, WireTypeLengthDelimited)))
+	require.NoError(t, expected.EncodeRawBytes([]byte{1}))
+
+	res, err = MarshalMessage(map[string]interface{}{
+		"packed":   []interface{}{5, 6},
+		"unpacked": []interface{}{1.5},
+		"kinds":    []interface{}{1},
+	}, proto3Msg)
+	require.NoError(t, err)
+	require.Equal(t, expected.Bytes(), res)
+
+	// decoded enums are []int32
+	decoded, err := UnmarshalMessage(res, proto3Msg)
+	require.NoError(t, err)
+	require.Equal(t, []int32{1}, decoded["kinds"])
+	res, err = MarshalMessage(decoded, proto3Msg)
+	require.NoError(t, err)
+	require.Equal(t, expected.Bytes(), res)
+}
+
+func TestMarshalMessageFieldErrors(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/full.proto", nil, nil)
+	require.NoError(t, err)
+	msgDesc, ok := parsedFile.Message(TypeName{"ComplexMessage"})
+	require.True(t, ok)
+
+	testCases := []struct {
+		data      map[string]interface{}
+		path      string
+		protoType string
+		goType    string
+	}{
+		{map[string]interface{}{"scalar_string": 10}, "scalar_string", "string", "int"},
+		{map[string]interface{}{"scalar_bool": 1.5}, "scalar_bool", "bool", "float64"},
+		{map[string]interface{}{"scalar_int32": "abc"}, "scalar_int32", "int32", "string"},
+		{map[string]interface{}{"message": "abc"}, "message", "ComplexMessage.SimpleMessage", "string"},
+		{map[string]interface{}{"r_scalar": 1}, "r_scalar", "repeated int32", "int"},
+		{map[string]interface{}{"map_msg": []interface{}{}}, "map_msg", "map<string, ComplexMessage.SimpleMessage>", "[]interface {}"},
+		{map[string]interface{}{"map_msg": map[string]interface{}{"a": 1}}, "map_msg[a]", "ComplexMessage.SimpleMessage", "int"},
+		{
+			map[string]interface{}{"r_msg": []interface{}{
+				map[string]interface{}{},
+				map[string]interface{}{"some_field": true},
+			}},
+			"r_msg[1].some_field", "int32", "bool",
+		},
+	}
+	for _, testCase := range testCases {
+		_, err := MarshalMessage(testCase.data, msgDesc)
+		require.Error(t, err)
+		fieldErr, ok := err.(*FieldError)
+		require.True(t, ok, "unexpected error %v", err)
+		require.Equal(t, testCase.path, fieldErr.Path)
+		require.Equal(t, testCase.protoType, fieldErr.ProtoType)
+		require.Equal(t, testCase.goType, fieldErr.GoType)
+	}
+}
+
+func TestMarshalMessageOneOf(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/full.proto", nil, nil)
+	require.NoError(t, err)
+	msgDesc, ok := parsedFile.Message(TypeName{"ComplexMessage"})
+	require.True(t, ok)
+
+	data := map[string]interface{}{
+		"oneof_scalar": 10,
+		"oneof_enum":   2,
+	}
+	_, err = MarshalMessage(data, msgDesc)
+	require.Error(t, err)
+	oneOfErr, ok := err.(*OneOfError)
+	require.True(t, ok)
+	require.Equal(t, "oneof", oneOfErr.OneOf)
+	require.Equal(t, []string{"oneof_scalar", "oneof_enum"}, oneOfErr.Fields)
+
+	res, err := MarshalOptions{AllowOneOfConflicts: true}.Marshal(data, msgDesc)
+	require.NoError(t, err)
+	resultMsg := &full.ComplexMessage{}
+	require.NoError(t, proto.Unmarshal(res, resultMsg))
+	require.Equal(t, &full.ComplexMessage_OneofEnum{OneofEnum: full.ComplexMessage_VALUE_B}, resultMsg.Oneof)
+}
+
+func TestMarshalMessageEnums(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/enums.proto", nil, nil)
+	require.NoError(t, err)
+	msg, ok := parsedFile.Message(TypeName{"Enums"})
+	require.True(t, ok)
+
+	res, err := MarshalMessage(map[string]interface{}{
+		"kind":    "C",
+		"kinds":   []interface{}{"A", 1, "5"},
+		"by_name": map[string]interface{}{"x": "B", "y": int32(5)},
+	}, msg)
+	require.NoError(t, err)
+	unmarshalled, err := UnmarshalMessage(res, msg)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"kind":    int32(5),
+		"kinds":   []int32{0, 1, 5},
+		"by_name": map[string]interface{}{"x": int32(1), "y": int32(5)},
+	}, unmarshalled)
+
+	for _, value := range []interface{}{7, "7", "D", -1, int64(math.MaxInt32) + 1} {
+		_, err = MarshalMessage(map[string]interface{}{"kind": value}, msg)
+		require.IsType(t, &FieldError{}, err, "%v", value)
+	}
+
+	// open enums accept unknown numbers, but not unknown names
+	fullFile, err := parser.Parse("./testdata/full.proto", nil, nil)
+	require.NoError(t, err)
+	fullMsg, ok := fullFile.Message(TypeName{"ComplexMessage"})
+	require.True(t, ok)
+	res, err = MarshalMessage(map[string]interface{}{"enum": 100, "r_enum": []string{"VALUE_B8", "-1"}}, fullMsg)
+	require.NoError(t, err)
+	resultMsg := &full.ComplexMessage{}
+	require.NoError(t, proto.Unmarshal(res, resultMsg))
+	require.Equal(t, &full.ComplexMessage{
+		Enum:  100,
+		REnum: []full.ComplexMessage_SimpleEnum{full.ComplexMessage_VALUE_B8, -1},
+	}, resultMsg)
+	_, err = MarshalMessage(map[string]interface{}{"enum": "VALUE_C"}, fullMsg)
+	require.IsType(t, &FieldError{}, err)
+}
+
+func TestMarshalMessageDeterministic(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/full.proto", nil, nil)
+	require.NoError(t, err)
+	msgDesc, ok := parsedFile.Message(TypeName{"ComplexMessage"})
+	require.True(t, ok)
+
+	protoMessage := jsonTestMessage()
+	protoMessage.MapEnum[10] = full.ComplexMessage_VALUE_B
+	protoMessage.MapEnum[-20] = full.ComplexMessage_VALUE_B1
+	protoMessage.MapMsg["a"] = &full.ComplexMessage_SimpleMessage{SomeField: 2}
+	protoMessage.MapString["b"] = "c"
+	expected := proto.NewBuffer(nil)
+	expected.SetDeterministic(true)
+	require.NoError(t, expected.Marshal(protoMessage))
+
+	dynamic, err := UnmarshalMessage(expected.Bytes(), msgDesc)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		res, err := MarshalOptions{Deterministic: true}.Marshal(dynamic, msgDesc)
+		require.NoError(t, err)
+		require.Equal(t, expected.Bytes(), res)
+	}
+
+	// integer keys are ordered numerically, whatever Go type they have
+	byStrings, err := MarshalOptions{Deterministic: true}.Marshal(map[string]interface{}{
+		"map_scalar": map[string]interface{}{"10": 1, "-2": 2, "3": 3},
+	}, msgDesc)
+	require.NoError(t, err)
+	byInts, err := MarshalOptions{Deterministic: true}.Marshal(map[string]interface{}{
+		"map_scalar": map[interface{}]interface{}{int32(10): 1, int64(-2): 2, 3: 3},
+	}, msgDesc)
+	require.NoError(t, err)
+	require.Equal(t, byStrings, byInts)
+	resultMsg := &full.ComplexMessage{}
+	require.NoError(t, proto.Unmarshal(byInts, resultMsg))
+	expected = proto.NewBuffer(nil)
+	expected.SetDeterministic(true)
+	require.NoError(t, expected.Marshal(resultMsg))
+	require.Equal(t, expected.Bytes(), byInts)
+
+	_, err = MarshalOptions{Deterministic: true}.Marshal(map[string]interface{}{
+		"map_scalar": map[string]interface{}{"x": 1},
+	}, msgDesc)
+	require.IsType(t, &FieldError{}, err)
+}
+
+func TestMarshalMessageAppend(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/full.proto", nil, nil)
+	require.NoError(t, err)
+	msgDesc, ok := parsedFile.Message(TypeName{"ComplexMessage"})
+	require.True(t, ok)
+
+	protoMessage := jsonTestMessage()
+	expected := proto.NewBuffer(nil)
+	expected.SetDeterministic(true)
+	require.NoError(t, expected.Marshal(protoMessage))
+	dynamic, err := UnmarshalMessage(expected.Bytes(), msgDesc)
+	require.NoError(t, err)
+
+	size, err := MessageSize(dynamic, msgDesc)
+	require.NoError(t, err)
+	require.Equal(t, proto.Size(protoMessage), size)
+
+	options := MarshalOptions{Deterministic: true}
+	prefix := []byte{1, 2, 3}
+	res, err := options.MarshalAppend(prefix, dynamic, msgDesc)
+	require.NoError(t, err)
+	require.Equal(t, prefix, res[:len(prefix)])
+	require.Equal(t, expected.Bytes(), res[len(prefix):])
+	require.Equal(t, len(prefix)+size, cap(res))
+
+	writer := &bytes.Buffer{}
+	require.NoError(t, options.MarshalTo(writer, dynamic, msgDesc))
+	require.NoError(t, options.MarshalTo(writer, dynamic, msgDesc))
+	require.Equal(t, append(expected.Bytes(), expected.Bytes()...), writer.Bytes())
+
+	_, err = MessageSize(map[string]interface{}{"r_msg": []interface{}{map[string]interface{}{"some_field": "x"}}}, msgDesc)
+	require.IsType(t, &FieldError{}, err)
+	require.Equal(t, "r_msg[0].some_field", err.(*FieldError).Path)
+	require.Error(t, MarshalMessageTo(writer, map[string]interface{}{"scalar_string": 1}, msgDesc))
+}
+
+func TestMarshalMessageBytes(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/full.proto", nil, nil)
+	require.NoError(t, err)
+	msgDesc, ok := parsedFile.Message(TypeName{"ComplexMessage"})
+	require.True(t, ok)
+
+	expected, err := proto.Marshal(&full.ComplexMessage{
+		Bytes:    []byte("a"),
+		MapBytes: map[string][]byte{"b": []byte("bb")},
+		RBytes:   [][]byte{[]byte("c"), []byte("cc")},
+	})
+	require.NoError(t, err)
+	dynamic := map[string]interface{}{
+		"bytes":     "YQ==",
+		"map_bytes": map[string]interface{}{"b": "YmI="},
+		"r_bytes":   []interface{}{"Yw==", []byte("cc")},
+	}
+
+	// base64 strings are decoded by size pass only
+	e := &encoder{o: MarshalOptions{Deterministic: true}}
+	size, err := e.messageSize(dynamic, msgDesc, "")
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("a"), []byte("bb"), []byte("c")}, e.bytes)
+	res, err := e.appendMessage(make([]byte, 0, size), dynamic, msgDesc)
+	require.NoError(t, err)
+	require.Equal(t, expected, res)
+	require.Equal(t, len(e.bytes), e.nextBytes)
+
+	_, err = MarshalMessage(map[string]interface{}{"r_bytes": []interface{}{"Yw==", "!"}}, msgDesc)
+	require.IsType(t, &FieldError{}, err)
+	require.Equal(t, "r_bytes[1]", err.(*FieldError).Path)
+}
+
+func TestMarshalMessageNested(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/tree.proto", nil, nil)
+	require.NoError(t, err)
+	msg, ok := parsedFile.Message(TypeName{"Node"})
+	require.True(t, ok)
+
+	// names are long enough to need multibyte length prefixes on every level
+	name := string(bytes.Repeat([]byte("n"), 200))
+	node := map[string]interface{}{"name": name, "required_kind": int32(3)}
+	for i := 0; i < 20; i++ {
+		node = map[string]interface{}{
+			"name":          name,
+			"child":         node,
+			"children":      []interface{}{map[string]interface{}{"name": name, "required_kind": int32(3)}},
+			"required_kind": int32(4),
+		}
+	}
+	size, err := MessageSize(node, msg)
+	require.NoError(t, err)
+	res, err := MarshalMessage(node, msg)
+	require.NoError(t, err)
+	require.Len(t, res, size)
+	unmarshalled, err := UnmarshalMessage(res, msg)
+	require.NoError(t, err)
+	require.Equal(t, node, unmarshalled)
+}
+
+func TestMarshalMessageManualScalar(t *testing.T) {
+	msg := &Message{Name: "Manual", NormalFields: []*NormalField{
+		{Name: "value", KeyNumber: 1, Type: &Scalar{ScalarName: "sint32"}},
+	}}
+
+	res, err := MarshalMessage(map[string]interface{}{"value": -1}, msg)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x08, 0x01}, res)
+
+	data, err := UnmarshalMessage(res, msg)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"value": int32(-1)}, data)
+}
+
+func TestMarshalMessageManualMap(t *testing.T) {
+	msg := &Message{Name: "Manual", MapFields: []*MapField{
+		{Name: "values", KeyNumber: 1, Map: &Map{KeyType: &Scalar{ScalarName: "string"}, ValueType: &Scalar{ScalarName: "int32"}}},
+	}}
+	value := map[string]interface{}{"values": map[string]interface{}{"a": int32(1)}}
+
+	size, err := MessageSize(value, msg)
+	require.NoError(t, err)
+	require.Equal(t, 7, size)
+	res, err := MarshalMessage(value, msg)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x0a, 0x05, 0x0a, 0x01, 'a', 0x10, 0x01}, res)
+
+	data, err := UnmarshalMessage(res, msg)
+	require.NoError(t, err)
+	require.Equal(t, value, data)
+}
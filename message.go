@@ -1,6 +1,9 @@
 package shprotos
 
 import (
+	"sort"
+	"sync/atomic"
+
 	"github.com/emicklei/proto"
 )
 
@@ -23,6 +26,8 @@ func (m Messages) Contains(msg *Message) bool {
 	return false
 }
 
+// Message is a message type. Its codec plan is compiled, when file is parsed or message is encoded or decoded
+// first time, so fields of message shouldn't be changed after that without calling Compile.
 type Message struct {
 	Name          string
 	QuotedComment string
@@ -33,9 +38,19 @@ type Message struct {
 	TypeName      TypeName
 	file          *File
 	parentMsg     *Message
+	plan          *messagePlan
+	// compiledPlan caches *messagePlan of message, which wasn't created by Parser.
+	compiledPlan atomic.Value
 }
 
 func (m Message) FieldByKeyNumber(key uint64) (Field, bool) {
+	if m.plan != nil {
+		fp, ok := m.plan.fieldByNumber(key)
+		if !ok {
+			return nil, false
+		}
+		return fp.field, true
+	}
 	for _, field := range m.GetFields() {
 		if field.GetKeyNumber() == key {
 			return field, true
@@ -60,6 +75,16 @@ func (m Message) GetFields() []Field {
 	return res
 }
 
+// GetFieldsByNumber returns message fields ordered by key number.
+func (m Message) GetFieldsByNumber() []Field {
+	res := m.GetFields()
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].GetKeyNumber() < res[j].GetKeyNumber()
+	})
+
+	return res
+}
+
 func (m Message) HaveFields() bool {
 	if len(m.NormalFields) > 0 || len(m.MapFields) > 0 {
 		return true
@@ -105,6 +130,11 @@ func (m Message) HaveFieldsExcept(field string) bool {
 	return false
 }
 
+// IsMapEntry reports whether message is synthesized map entry.
+func (m Message) IsMapEntry() bool {
+	return m.Descriptor != nil && optionIsTrue(elementsOptions(m.Descriptor.Elements), "map_entry")
+}
+
 func (m Message) File() *File {
 	return m.file
 }
@@ -136,8 +166,15 @@ func (m Message) GetFullName() string {
 type Field interface {
 	GetKeyNumber() uint64
 	GetName() string
+	GetJSONName() string
 	GetType() Type
+	GetQuotedComment() string
+	GetOptions() []*proto.Option
+	GetMessage() *Message
+	GetOneOf() *OneOf
 	IsRepeated() bool
+	IsDeprecated() bool
+	HasPresence() bool
 }
 
 type NormalField struct {
@@ -150,6 +187,7 @@ type NormalField struct {
 	Optional      bool
 	Required      bool
 	OneOf         *OneOf
+	message       *Message
 }
 
 func (n *NormalField) GetKeyNumber() uint64 {
@@ -160,20 +198,79 @@ func (n *NormalField) GetName() string {
 	return n.Name
 }
 
+func (n *NormalField) GetJSONName() string {
+	return fieldJSONName(n.Name, n.GetOptions())
+}
+
 func (n *NormalField) GetType() Type {
 	return n.Type
 }
 
+func (n *NormalField) GetQuotedComment() string {
+	return n.QuotedComment
+}
+
+func (n *NormalField) GetOptions() []*proto.Option {
+	if n.descriptor == nil {
+		return nil
+	}
+
+	return n.descriptor.Options
+}
+
+func (n *NormalField) GetMessage() *Message {
+	return n.message
+}
+
+func (n *NormalField) GetOneOf() *OneOf {
+	return n.OneOf
+}
+
 func (n *NormalField) IsRepeated() bool {
 	return n.Repeated
 }
 
+// IsPacked reports whether repeated field is encoded packed: by default in proto3 and
+// with [packed = true] in proto2.
+func (n *NormalField) IsPacked() bool {
+	if !n.Repeated || !typeIsPackable(n.Type) {
+		return false
+	}
+	if option, ok := findOption(n.GetOptions(), "packed"); ok {
+		return option.Constant.Source == "true"
+	}
+
+	return n.message != nil && n.message.file != nil && n.message.file.Syntax == SyntaxProto3
+}
+
+func (n *NormalField) IsDeprecated() bool {
+	return optionIsTrue(n.GetOptions(), "deprecated")
+}
+
+// HasPresence reports whether the field tracks if it was explicitly set:
+// singular message fields, oneof members, proto2 singular fields and
+// proto3 fields marked "optional".
+func (n *NormalField) HasPresence() bool {
+	if n.Repeated {
+		return false
+	}
+	if n.OneOf != nil || n.Optional || n.Required {
+		return true
+	}
+	if _, ok := n.Type.(*Message); ok {
+		return true
+	}
+
+	return n.message != nil && n.message.file != nil && n.message.file.Syntax == SyntaxProto2
+}
+
 type MapField struct {
 	KeyNumber     uint64
 	Name          string
 	QuotedComment string
 	descriptor    *proto.MapField
 	Map           *Map
+	message       *Message
 }
 
 func (n *MapField) GetKeyNumber() uint64 {
@@ -184,14 +281,51 @@ func (n *MapField) GetName() string {
 	return n.Name
 }
 
+// GetEntryMessage returns synthesized XxxEntry message with key and value fields.
+func (n *MapField) GetEntryMessage() *Message {
+	return n.Map.Entry
+}
+
+func (n *MapField) GetJSONName() string {
+	return fieldJSONName(n.Name, n.GetOptions())
+}
+
 func (n *MapField) GetType() Type {
 	return n.Map
 }
 
+func (n *MapField) GetQuotedComment() string {
+	return n.QuotedComment
+}
+
+func (n *MapField) GetOptions() []*proto.Option {
+	if n.descriptor == nil {
+		return nil
+	}
+
+	return n.descriptor.Options
+}
+
+func (n *MapField) GetMessage() *Message {
+	return n.message
+}
+
+func (n *MapField) GetOneOf() *OneOf {
+	return nil
+}
+
 func (n *MapField) IsRepeated() bool {
 	return false
 }
 
+func (n *MapField) IsDeprecated() bool {
+	return optionIsTrue(n.GetOptions(), "deprecated")
+}
+
+func (n *MapField) HasPresence() bool {
+	return false
+}
+
 type OneOf struct {
 	Name   string
 	Fields []*NormalField
@@ -202,6 +336,7 @@ type Map struct {
 	KeyType   Type
 	ValueType Type
 	Field     *proto.MapField
+	Entry     *Message
 	file      *File
 }
 
@@ -3,6 +3,7 @@ package shprotos
 import (
 	"testing"
 
+	"github.com/emicklei/proto"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -76,4 +77,70 @@ func TestMessageMethods(t *testing.T) {
 			c.So(msg.HaveFieldsExcept("b"), ShouldBeFalse)
 		})
 	})
+	Convey("Test Field accessors", t, func(c C) {
+		proto3Msg := &Message{file: &File{Syntax: SyntaxProto3}}
+		proto2Msg := &Message{file: &File{Syntax: SyntaxProto2}}
+		c.Convey("Should return lowerCamelCase json name", func(c C) {
+			c.So((&NormalField{Name: "some_field_2"}).GetJSONName(), ShouldEqual, "someField2")
+			c.So((&MapField{Name: "map_enum"}).GetJSONName(), ShouldEqual, "mapEnum")
+		})
+		c.Convey("Should return json_name option value", func(c C) {
+			fld := &NormalField{Name: "some_field", descriptor: &proto.Field{Options: []*proto.Option{
+				{Name: "json_name", Constant: proto.Literal{Source: "custom", IsString: true}},
+			}}}
+			c.So(fld.GetJSONName(), ShouldEqual, "custom")
+		})
+		c.Convey("Should check deprecated option", func(c C) {
+			fld := &NormalField{descriptor: &proto.Field{Options: []*proto.Option{
+				{Name: "deprecated", Constant: proto.Literal{Source: "true"}},
+			}}}
+			c.So(fld.IsDeprecated(), ShouldBeTrue)
+			c.So((&NormalField{}).IsDeprecated(), ShouldBeFalse)
+		})
+		c.Convey("Should check field presence", func(c C) {
+			c.So((&NormalField{Type: &Scalar{}, message: proto3Msg}).HasPresence(), ShouldBeFalse)
+			c.So((&NormalField{Type: &Scalar{}, message: proto3Msg, Optional: true}).HasPresence(), ShouldBeTrue)
+			c.So((&NormalField{Type: &Scalar{}, message: proto2Msg}).HasPresence(), ShouldBeTrue)
+			c.So((&NormalField{Type: &Scalar{}, message: proto2Msg, Repeated: true}).HasPresence(), ShouldBeFalse)
+			c.So((&NormalField{Type: &Message{}, message: proto3Msg}).HasPresence(), ShouldBeTrue)
+			c.So((&NormalField{Type: &Scalar{}, message: proto3Msg, OneOf: &OneOf{}}).HasPresence(), ShouldBeTrue)
+			c.So((&MapField{message: proto2Msg}).HasPresence(), ShouldBeFalse)
+		})
+	})
+}
+
+func TestMapEntryMessage(t *testing.T) {
+	Convey("Test map entry messages", t, func(c C) {
+		parser := Parser{}
+		file, err := parser.Parse("./testdata/full.proto", nil, nil)
+		c.So(err, ShouldBeNil)
+		msg, ok := file.Message(TypeName{"ComplexMessage"})
+		c.So(ok, ShouldBeTrue)
+
+		c.Convey("Should synthesize entry message for map field", func(c C) {
+			fld, ok := msg.GetFieldByName("map_enum")
+			c.So(ok, ShouldBeTrue)
+			entry := fld.(*MapField).GetEntryMessage()
+			c.So(entry, ShouldNotBeNil)
+			c.So(entry.IsMapEntry(), ShouldBeTrue)
+			c.So(msg.IsMapEntry(), ShouldBeFalse)
+			c.So(entry.TypeName, ShouldResemble, TypeName{"ComplexMessage", "MapEnumEntry"})
+			c.So(entry.GetFullName(), ShouldEqual, "ComplexMessage.MapEnumEntry")
+
+			key, ok := entry.FieldByKeyNumber(1)
+			c.So(ok, ShouldBeTrue)
+			c.So(key.GetName(), ShouldEqual, "key")
+			c.So(key.GetType(), ShouldEqual, ScalarByKind(ScalarInt32))
+			c.So(key.GetMessage(), ShouldEqual, entry)
+			value, ok := entry.FieldByKeyNumber(2)
+			c.So(ok, ShouldBeTrue)
+			c.So(value.GetName(), ShouldEqual, "value")
+			c.So(value.GetType().String(), ShouldEqual, "SimpleEnum enum")
+		})
+		c.Convey("Should fail on invalid map key type", func(c C) {
+			_, err := parser.Parse("./testdata/invalid/map_key.proto", nil, nil)
+			c.So(err, ShouldNotBeNil)
+			c.So(err.Error(), ShouldContainSubstring, "invalid key type double")
+		})
+	})
 }
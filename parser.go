@@ -15,6 +15,18 @@ type Parser struct {
 func (p *Parser) ParsedFiles() []*File {
 	return p.parsedFiles
 }
+
+// FindMessageByName looks up message by its full name in all parsed files.
+func (p *Parser) FindMessageByName(fullName string) (*Message, bool) {
+	for _, f := range p.parsedFiles {
+		if msg, ok := f.FindMessageByName(fullName); ok {
+			return msg, true
+		}
+	}
+
+	return nil, false
+}
+
 func (p *Parser) parsedFile(filePath string) (*File, bool) {
 	for _, f := range p.parsedFiles {
 		if f.FilePath == filePath {
@@ -89,6 +101,7 @@ func (p *Parser) Parse(path string, importAliases []map[string]string, paths []s
 		FilePath:    absPath,
 		protoFile:   f,
 		PkgName:     resolveFilePkgName(f),
+		Syntax:      resolveFileSyntax(f),
 		Descriptors: map[string]Type{},
 	}
 	result.parseGoPackage()
@@ -106,6 +119,8 @@ func (p *Parser) Parse(path string, importAliases []map[string]string, paths []s
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to parse messages fields")
 	}
+	result.compilePlans()
+	result.parseServicesHTTPRules()
 	p.parsedFiles = append(p.parsedFiles, result)
 	return result, nil
 }
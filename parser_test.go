@@ -8,9 +8,9 @@ import (
 )
 
 func testFileInfo(file *File) *File {
-	var Int32Type = &Scalar{file: file, ScalarName: "int32"}
+	var Int32Type = ScalarByKind(ScalarInt32)
 
-	var StringType = &Scalar{file: file, ScalarName: "string"}
+	var StringType = ScalarByKind(ScalarString)
 
 	var RootMessage = file.Messages[0]
 
@@ -354,9 +354,9 @@ func CompareTypes(c C, t1, t2 Type) {
 	c.So(t1, ShouldNotBeNil)
 	c.So(t2, ShouldNotBeNil)
 
-	switch protoType := t1.(type) {
+	switch t1.(type) {
 	case *Scalar:
-		c.So(protoType.ScalarName, ShouldEqual, t2.(*Scalar).ScalarName)
+		c.So(t1, ShouldEqual, t2)
 	case *Message:
 		c.So(t1, ShouldEqual, t2)
 		c.So(t1.File(), ShouldEqual, t2.File())
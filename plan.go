@@ -0,0 +1,168 @@
+package shprotos
+
+import (
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// denseFieldNumberLimit is the maximal key number, up to which fields are looked up by index instead of map.
+const denseFieldNumberLimit = 1024
+
+// messagePlan is a precompiled codec of message: its fields indexed by key number with pre-encoded keys
+// and encoders of their types. Plans are compiled, when file is parsed, so messages shouldn't be changed
+// after that without calling Message.Compile.
+type messagePlan struct {
+	// fields are in GetFields order.
+	fields []*fieldPlan
+	// ordered fields are in key number order.
+	ordered []*fieldPlan
+	dense   []*fieldPlan
+	sparse  map[uint64]*fieldPlan
+}
+
+// fieldPlan holds everything needed to encode or decode field without looking at its type.
+type fieldPlan struct {
+	field    Field
+	name     string
+	number   uint64
+	normal   *NormalField
+	mapField *MapField
+	// key is encoded key of single field value.
+	key []byte
+	// packedKey is encoded key of packed repeated field.
+	packedKey []byte
+	packed    bool
+	packable  bool
+	wireType  uint64
+	// scalar is kind of scalar field type, it's ScalarUndefined for other types.
+	scalar  ScalarKind
+	enum    *Enum
+	message *Message
+	// mapKey and mapValue are plans of map entry fields.
+	mapKey   *fieldPlan
+	mapValue *fieldPlan
+}
+
+// planOf returns plan of message. Plans of messages, which weren't created by Parser, are compiled on first use
+// and cached, so such messages shouldn't be changed after that without calling Message.Compile.
+func planOf(msg *Message) *messagePlan {
+	if msg.plan != nil {
+		return msg.plan
+	}
+	if plan, ok := msg.compiledPlan.Load().(*messagePlan); ok {
+		return plan
+	}
+	// concurrent callers may compile the plan twice, but they store equal plans
+	plan := compileMessagePlan(msg)
+	msg.compiledPlan.Store(plan)
+
+	return plan
+}
+
+func compileMessagePlan(msg *Message) *messagePlan {
+	plan := &messagePlan{}
+	var maxNumber uint64
+	for _, field := range msg.GetFields() {
+		fp := compileFieldPlan(field)
+		plan.fields = append(plan.fields, fp)
+		if fp.number > maxNumber {
+			maxNumber = fp.number
+		}
+	}
+	plan.ordered = make([]*fieldPlan, len(plan.fields))
+	copy(plan.ordered, plan.fields)
+	sort.Slice(plan.ordered, func(i, j int) bool {
+		return plan.ordered[i].number < plan.ordered[j].number
+	})
+	if maxNumber <= denseFieldNumberLimit {
+		plan.dense = make([]*fieldPlan, maxNumber+1)
+		for _, fp := range plan.fields {
+			plan.dense[fp.number] = fp
+		}
+	} else {
+		plan.sparse = make(map[uint64]*fieldPlan, len(plan.fields))
+		for _, fp := range plan.fields {
+			plan.sparse[fp.number] = fp
+		}
+	}
+
+	return plan
+}
+
+func compileFieldPlan(field Field) *fieldPlan {
+	fp := &fieldPlan{
+		field:  field,
+		name:   field.GetName(),
+		number: field.GetKeyNumber(),
+	}
+	switch fld := field.(type) {
+	case *NormalField:
+		fp.normal = fld
+		fp.packed = fld.IsPacked()
+		fp.packable = typeIsPackable(fld.Type)
+		fp.wireType = typeWireType(fld.Type)
+		switch typ := fld.Type.(type) {
+		case *Scalar:
+			fp.scalar = typ.scalarKind()
+		case *Enum:
+			fp.enum = typ
+		case *Message:
+			fp.message = typ
+		}
+	case *MapField:
+		fp.mapField = fld
+		fp.wireType = WireTypeLengthDelimited
+		key, value := mapEntryFields(fld)
+		fp.mapKey = compileFieldPlan(key)
+		fp.mapValue = compileFieldPlan(value)
+	}
+	fp.key = proto.EncodeVarint(messageKeyVarint(fp.number, fp.wireType))
+	fp.packedKey = proto.EncodeVarint(messageKeyVarint(fp.number, WireTypeLengthDelimited))
+
+	return fp
+}
+
+// mapEntryFields returns key and value fields of map entry. Maps, built without Parser, may have no entry
+// message, so their fields are made from map key and value types.
+func mapEntryFields(fld *MapField) (*NormalField, *NormalField) {
+	if entry := fld.GetEntryMessage(); entry != nil {
+		return entry.NormalFields[0], entry.NormalFields[1]
+	}
+
+	return &NormalField{KeyNumber: 1, Name: "key", Type: fld.Map.KeyType},
+		&NormalField{KeyNumber: 2, Name: "value", Type: fld.Map.ValueType}
+}
+
+// fieldByNumber looks up field by its key number.
+func (p *messagePlan) fieldByNumber(number uint64) (*fieldPlan, bool) {
+	if p.dense != nil {
+		if number >= uint64(len(p.dense)) || p.dense[number] == nil {
+			return nil, false
+		}
+		return p.dense[number], true
+	}
+	fp, ok := p.sparse[number]
+
+	return fp, ok
+}
+
+// Compile compiles codec plan of message and its map entries again. Plans are compiled, when file is parsed
+// or message is encoded or decoded first time, so Compile must be called after fields of message are changed.
+// Plans of nested messages are compiled separately. Compile mustn't be called concurrently with encoding
+// or decoding of message.
+func (m *Message) Compile() {
+	m.plan = compileMessagePlan(m)
+	for _, field := range m.MapFields {
+		if entry := field.GetEntryMessage(); entry != nil {
+			entry.plan = compileMessagePlan(entry)
+		}
+	}
+}
+
+// compilePlans compiles plans of all file messages, including map entries.
+func (f *File) compilePlans() {
+	for _, msg := range f.Messages {
+		msg.Compile()
+	}
+}
@@ -0,0 +1,123 @@
+package shprotos
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	full "github.com/saturn4er/shprotos/testdata"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessagePlan(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/full.proto", nil, nil)
+	require.NoError(t, err)
+	msg, ok := parsedFile.Message(TypeName{"ComplexMessage"})
+	require.True(t, ok)
+	require.NotNil(t, msg.plan)
+
+	for _, field := range msg.GetFields() {
+		fp, ok := msg.plan.fieldByNumber(field.GetKeyNumber())
+		require.True(t, ok, field.GetName())
+		require.Equal(t, field.GetName(), fp.name)
+		require.Equal(t, proto.EncodeVarint(messageKeyVarint(fp.number, fp.wireType)), fp.key)
+	}
+	_, ok = msg.plan.fieldByNumber(10000)
+	require.False(t, ok)
+	for i := 1; i < len(msg.plan.ordered); i++ {
+		require.True(t, msg.plan.ordered[i-1].number < msg.plan.ordered[i].number)
+	}
+
+	sparse := compileMessagePlan(&Message{
+		Name:         "Sparse",
+		NormalFields: []*NormalField{{Name: "a", KeyNumber: 1 << 20, Type: &Scalar{ScalarKind: ScalarInt32}}},
+	})
+	require.Nil(t, sparse.dense)
+	fp, ok := sparse.fieldByNumber(1 << 20)
+	require.True(t, ok)
+	require.Equal(t, "a", fp.name)
+	_, ok = sparse.fieldByNumber(1)
+	require.False(t, ok)
+
+	// plans of messages, built manually, are compiled once
+	manual := &Message{
+		Name:         "Manual",
+		NormalFields: []*NormalField{{Name: "a", KeyNumber: 1, Type: &Scalar{ScalarName: "int32"}}},
+	}
+	plan := planOf(manual)
+	require.Len(t, plan.fields, 1)
+	require.True(t, plan == planOf(manual))
+
+	// changed message is compiled again
+	manual.NormalFields = append(manual.NormalFields, &NormalField{Name: "b", KeyNumber: 2, Type: &Scalar{ScalarName: "int32"}})
+	require.Len(t, planOf(manual).fields, 1)
+	manual.Compile()
+	require.Len(t, planOf(manual).fields, 2)
+	data, err := MarshalMessage(map[string]interface{}{"b": 1}, manual)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x10, 0x01}, data)
+}
+
+func benchmarkMessage(b *testing.B) (*Message, []byte, map[string]interface{}) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/full.proto", nil, nil)
+	require.NoError(b, err)
+	msg, ok := parsedFile.Message(TypeName{"ComplexMessage"})
+	require.True(b, ok)
+	data, err := proto.Marshal(&full.ComplexMessage{
+		Enum:           full.ComplexMessage_VALUE_A,
+		ScalarInt32:    -10,
+		ScalarInt64:    -20,
+		ScalarUint32:   10,
+		ScalarUint64:   20,
+		ScalarSint32:   -50,
+		ScalarSint64:   -500,
+		ScalarFixed32:  1000,
+		ScalarFixed64:  10000,
+		ScalarSfixed32: -100000,
+		ScalarSfixed64: -1000000,
+		ScalarDouble:   1.5,
+		ScalarFloat:    2.5,
+		ScalarBool:     true,
+		ScalarString:   "string",
+		Message:        &full.ComplexMessage_SimpleMessage{SomeField: 1, SomeField2: "nested"},
+		Bytes:          []byte("bytes"),
+		MapEnum:        map[int32]full.ComplexMessage_SimpleEnum{1: full.ComplexMessage_VALUE_A, 2: full.ComplexMessage_VALUE_B},
+		MapScalar:      map[int32]int32{1: 2, 3: 4},
+		MapMsg:         map[string]*full.ComplexMessage_SimpleMessage{"a": {SomeField: 1}, "b": {SomeField2: "b"}},
+		MapBytes:       map[string][]byte{"a": []byte("a")},
+		MapString:      map[string]string{"a": "b"},
+		REnum:          []full.ComplexMessage_SimpleEnum{full.ComplexMessage_VALUE_A, full.ComplexMessage_VALUE_B},
+		RScalar:        []int32{1, 2, 3, 4, 5},
+		RMsg:           []*full.ComplexMessage_SimpleMessage{{SomeField: 1}, {SomeField: 2}, {SomeField2: "3"}},
+		RBytes:         [][]byte{[]byte("a"), []byte("b")},
+		Oneof:          &full.ComplexMessage_OneofMessage{OneofMessage: &full.ComplexMessage_SimpleMessage{SomeField: 1}},
+	})
+	require.NoError(b, err)
+	value, err := UnmarshalMessage(data, msg)
+	require.NoError(b, err)
+
+	return msg, data, value
+}
+
+func BenchmarkMarshalMessage(b *testing.B) {
+	msg, _, value := benchmarkMessage(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := MarshalMessage(value, msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalMessage(b *testing.B) {
+	msg, data, _ := benchmarkMessage(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := UnmarshalMessage(data, msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
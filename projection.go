@@ -0,0 +1,95 @@
+package shprotos
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ProjectionWildcard is a path segment, which selects all elements of repeated field or all values of map field.
+const ProjectionWildcard = "*"
+
+// Projection selects fields of message, which are decoded by Unmarshal, like google.protobuf.FieldMask does.
+// Other fields are skipped on the wire. Nil projection selects all fields.
+type Projection struct {
+	msg *Message
+	// fields are projections of selected fields by key number. Nil projection selects field entirely.
+	fields map[uint64]*Projection
+}
+
+// NewProjection compiles field paths of msg to projection. Path is a dot-separated list of field names,
+// like "message.some_field". Elements of repeated fields and values of map fields are selected with
+// ProjectionWildcard segment, like "r_msg.*.some_field2". Path selects field with all its subfields, so
+// "message" and "message.some_field" together select the whole message field.
+func NewProjection(msg *Message, paths ...string) (*Projection, error) {
+	projection := newProjection(msg)
+	for _, path := range paths {
+		if err := projection.add(strings.Split(path, ".")); err != nil {
+			return nil, errors.Wrapf(err, "invalid path %s", path)
+		}
+	}
+
+	return projection, nil
+}
+
+func newProjection(msg *Message) *Projection {
+	return &Projection{msg: msg, fields: make(map[uint64]*Projection)}
+}
+
+func (p *Projection) add(path []string) error {
+	field, ok := p.msg.GetFieldByName(path[0])
+	if !ok {
+		return errors.Errorf("message %s has no field %s", p.msg.GetFullName(), path[0])
+	}
+	number := field.GetKeyNumber()
+	fieldProjection, selected := p.fields[number]
+	if selected && fieldProjection == nil {
+		return nil
+	}
+	path = path[1:]
+	var msg *Message
+	switch fld := field.(type) {
+	case *MapField:
+		if len(path) > 0 && path[0] != ProjectionWildcard {
+			return errors.Errorf("values of map field %s should be selected with %s", fld.Name, ProjectionWildcard)
+		}
+		msg, _ = fld.Map.ValueType.(*Message)
+	case *NormalField:
+		if fld.Repeated && len(path) > 0 && path[0] != ProjectionWildcard {
+			return errors.Errorf("elements of repeated field %s should be selected with %s", fld.Name, ProjectionWildcard)
+		}
+		msg, _ = fld.Type.(*Message)
+	}
+	if len(path) > 0 && path[0] == ProjectionWildcard {
+		if fld, ok := field.(*NormalField); ok && !fld.Repeated {
+			return errors.Errorf("field %s isn't repeated", fld.Name)
+		}
+		path = path[1:]
+	}
+	if len(path) == 0 {
+		p.fields[number] = nil
+		return nil
+	}
+	if msg == nil {
+		return errors.Errorf("field %s isn't a message", field.GetName())
+	}
+	if !isPlainMessage(msg) {
+		return errors.Errorf("fields of %s can't be selected", msg.GetFullName())
+	}
+	if fieldProjection == nil {
+		fieldProjection = newProjection(msg)
+		p.fields[number] = fieldProjection
+	}
+
+	return fieldProjection.add(path)
+}
+
+// field reports whether field with given key number is selected and returns projection of its value.
+func (p *Projection) field(number uint64) (*Projection, bool) {
+	if p == nil {
+		return nil, true
+	}
+	fieldProjection, ok := p.fields[number]
+
+	return fieldProjection, ok
+}
@@ -0,0 +1,399 @@
+package shprotos
+
+import (
+	"path/filepath"
+
+	"github.com/emicklei/proto"
+	"github.com/pkg/errors"
+	protobuf "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+var scalarDescriptorTypes = map[ScalarKind]descriptorpb.FieldDescriptorProto_Type{
+	ScalarDouble:   descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+	ScalarFloat:    descriptorpb.FieldDescriptorProto_TYPE_FLOAT,
+	ScalarInt32:    descriptorpb.FieldDescriptorProto_TYPE_INT32,
+	ScalarInt64:    descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	ScalarUint32:   descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+	ScalarUint64:   descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+	ScalarSint32:   descriptorpb.FieldDescriptorProto_TYPE_SINT32,
+	ScalarSint64:   descriptorpb.FieldDescriptorProto_TYPE_SINT64,
+	ScalarFixed32:  descriptorpb.FieldDescriptorProto_TYPE_FIXED32,
+	ScalarFixed64:  descriptorpb.FieldDescriptorProto_TYPE_FIXED64,
+	ScalarSfixed32: descriptorpb.FieldDescriptorProto_TYPE_SFIXED32,
+	ScalarSfixed64: descriptorpb.FieldDescriptorProto_TYPE_SFIXED64,
+	ScalarBool:     descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+	ScalarString:   descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	ScalarBytes:    descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+}
+
+// reflectDescriptors holds protoreflect descriptors of file and its imports, built once per file.
+type reflectDescriptors struct {
+	files *protoregistry.Files
+	file  protoreflect.FileDescriptor
+	err   error
+}
+
+// ReflectDescriptor returns protoreflect descriptor of file, so it can be used with google.golang.org/protobuf
+// packages, like dynamicpb and protojson. Imported files are named as they're imported, the file itself
+// is named by its base name. Extensions are not supported.
+func (f *File) ReflectDescriptor() (protoreflect.FileDescriptor, error) {
+	descriptors := f.reflectDescriptors()
+
+	return descriptors.file, descriptors.err
+}
+
+// FileDescriptorProto returns descriptor.proto representation of file.
+func (f *File) FileDescriptorProto() (*descriptorpb.FileDescriptorProto, error) {
+	fd, err := f.ReflectDescriptor()
+	if err != nil {
+		return nil, err
+	}
+
+	return protodesc.ToFileDescriptorProto(fd), nil
+}
+
+func (f *File) reflectDescriptors() *reflectDescriptors {
+	f.reflectOnce.Do(func() {
+		builder := &descriptorBuilder{files: new(protoregistry.Files), built: map[*File]protoreflect.FileDescriptor{}}
+		f.reflect.files = builder.files
+		f.reflect.file, f.reflect.err = builder.build(f, filepath.Base(f.FilePath))
+	})
+
+	return &f.reflect
+}
+
+// findReflectDescriptor looks up descriptor by full name in descriptors of file and its imports.
+func (f *File) findReflectDescriptor(fullName string) (protoreflect.Descriptor, error) {
+	descriptors := f.reflectDescriptors()
+	if descriptors.err != nil {
+		return nil, descriptors.err
+	}
+	desc, err := descriptors.files.FindDescriptorByName(protoreflect.FullName(fullName))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find %s descriptor", fullName)
+	}
+
+	return desc, nil
+}
+
+// ReflectDescriptor returns protoreflect descriptor of message. See File.ReflectDescriptor.
+func (m Message) ReflectDescriptor() (protoreflect.MessageDescriptor, error) {
+	if m.file == nil {
+		return nil, errors.Errorf("message %s has no file", m.Name)
+	}
+	desc, err := m.file.findReflectDescriptor(m.GetFullName())
+	if err != nil {
+		return nil, err
+	}
+	md, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, errors.Errorf("%s is not a message", m.GetFullName())
+	}
+
+	return md, nil
+}
+
+// ReflectDescriptor returns protoreflect descriptor of enum. See File.ReflectDescriptor.
+func (e Enum) ReflectDescriptor() (protoreflect.EnumDescriptor, error) {
+	if e.file == nil {
+		return nil, errors.Errorf("enum %s has no file", e.Name)
+	}
+	desc, err := e.file.findReflectDescriptor(e.GetFullName())
+	if err != nil {
+		return nil, err
+	}
+	ed, ok := desc.(protoreflect.EnumDescriptor)
+	if !ok {
+		return nil, errors.Errorf("%s is not an enum", e.GetFullName())
+	}
+
+	return ed, nil
+}
+
+// ReflectDescriptor returns protoreflect descriptor of service. See File.ReflectDescriptor.
+func (s Service) ReflectDescriptor() (protoreflect.ServiceDescriptor, error) {
+	if s.File == nil {
+		return nil, errors.Errorf("service %s has no file", s.Name)
+	}
+	desc, err := s.File.findReflectDescriptor(s.GetFullName())
+	if err != nil {
+		return nil, err
+	}
+	sd, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, errors.Errorf("%s is not a service", s.GetFullName())
+	}
+
+	return sd, nil
+}
+
+// ReflectDescriptor returns protoreflect descriptor of method. See File.ReflectDescriptor.
+func (m Method) ReflectDescriptor() (protoreflect.MethodDescriptor, error) {
+	sd, err := m.Service.ReflectDescriptor()
+	if err != nil {
+		return nil, err
+	}
+	md := sd.Methods().ByName(protoreflect.Name(m.Name))
+	if md == nil {
+		return nil, errors.Errorf("failed to find %s descriptor", m.GetFullName())
+	}
+
+	return md, nil
+}
+
+// ReflectDescriptor returns protoreflect descriptor of field. See File.ReflectDescriptor.
+func (n *NormalField) ReflectDescriptor() (protoreflect.FieldDescriptor, error) {
+	return fieldReflectDescriptor(n)
+}
+
+// ReflectDescriptor returns protoreflect descriptor of map field. See File.ReflectDescriptor.
+func (n *MapField) ReflectDescriptor() (protoreflect.FieldDescriptor, error) {
+	return fieldReflectDescriptor(n)
+}
+
+func fieldReflectDescriptor(field Field) (protoreflect.FieldDescriptor, error) {
+	msg := field.GetMessage()
+	if msg == nil {
+		return nil, errors.Errorf("field %s has no message", field.GetName())
+	}
+	md, err := msg.ReflectDescriptor()
+	if err != nil {
+		return nil, err
+	}
+	fd := md.Fields().ByNumber(protoreflect.FieldNumber(field.GetKeyNumber()))
+	if fd == nil {
+		return nil, errors.Errorf("failed to find %s.%s descriptor", md.FullName(), field.GetName())
+	}
+
+	return fd, nil
+}
+
+// descriptorBuilder builds descriptors of file and its imports, registering them in files.
+type descriptorBuilder struct {
+	files *protoregistry.Files
+	built map[*File]protoreflect.FileDescriptor
+}
+
+func (b *descriptorBuilder) build(f *File, path string) (protoreflect.FileDescriptor, error) {
+	if fd, ok := b.built[f]; ok {
+		return fd, nil
+	}
+	importPaths := f.importPaths()
+	fdProto := f.fileDescriptorProto(path)
+	for i, imported := range f.Imports {
+		fd, err := b.build(imported, importPaths[i].Filename)
+		if err != nil {
+			return nil, err
+		}
+		// import may be already built under another name
+		fdProto.Dependency = append(fdProto.Dependency, fd.Path())
+		if importPaths[i].Kind == "public" {
+			fdProto.PublicDependency = append(fdProto.PublicDependency, int32(i))
+		}
+	}
+	fd, err := protodesc.NewFile(fdProto, b.files)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to build %s descriptor", path)
+	}
+	if err := b.files.RegisterFile(fd); err != nil {
+		return nil, errors.Wrapf(err, "failed to register %s descriptor", path)
+	}
+	b.built[f] = fd
+
+	return fd, nil
+}
+
+// importPaths returns import statements of file in the same order as File.Imports.
+func (f *File) importPaths() []*proto.Import {
+	var result []*proto.Import
+	for _, el := range f.protoFile.Elements {
+		if imprt, ok := el.(*proto.Import); ok {
+			result = append(result, imprt)
+		}
+	}
+
+	return result
+}
+
+// fileDescriptorProto converts file declarations to descriptor.proto representation without dependencies.
+func (f *File) fileDescriptorProto(path string) *descriptorpb.FileDescriptorProto {
+	result := &descriptorpb.FileDescriptorProto{
+		Name:   protobuf.String(path),
+		Syntax: protobuf.String(f.Syntax),
+	}
+	if f.PkgName != "" {
+		result.Package = protobuf.String(f.PkgName)
+	}
+	if f.GoPackage != "" {
+		result.Options = &descriptorpb.FileOptions{GoPackage: protobuf.String(f.GoPackage)}
+	}
+	for _, msg := range f.Messages {
+		if msg.parentMsg == nil {
+			result.MessageType = append(result.MessageType, f.messageDescriptorProto(msg))
+		}
+	}
+	for _, enum := range f.Enums {
+		if len(enum.TypeName) == 1 {
+			result.EnumType = append(result.EnumType, enumDescriptorProto(enum))
+		}
+	}
+	for _, srv := range f.Services {
+		result.Service = append(result.Service, serviceDescriptorProto(srv))
+	}
+
+	return result
+}
+
+func (f *File) messageDescriptorProto(msg *Message) *descriptorpb.DescriptorProto {
+	result := &descriptorpb.DescriptorProto{Name: protobuf.String(msg.Name)}
+	options := elementsOptions(msg.Descriptor.Elements)
+	if msg.IsMapEntry() || optionIsTrue(options, "deprecated") {
+		result.Options = &descriptorpb.MessageOptions{}
+		if msg.IsMapEntry() {
+			result.Options.MapEntry = protobuf.Bool(true)
+		}
+		if optionIsTrue(options, "deprecated") {
+			result.Options.Deprecated = protobuf.Bool(true)
+		}
+	}
+	for _, oneOf := range msg.OneOffs {
+		result.OneofDecl = append(result.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: protobuf.String(oneOf.Name)})
+	}
+	for _, field := range msg.GetFields() {
+		fieldProto := fieldDescriptorProto(field)
+		if oneOf := field.GetOneOf(); oneOf != nil {
+			for i := range msg.OneOffs {
+				if msg.OneOffs[i] == oneOf {
+					fieldProto.OneofIndex = protobuf.Int32(int32(i))
+				}
+			}
+		}
+		result.Field = append(result.Field, fieldProto)
+	}
+	// proto3 optional fields are members of synthetic oneofs, declared after real ones
+	for i, field := range msg.NormalFields {
+		if field.Optional && f.Syntax == SyntaxProto3 {
+			result.Field[i].Proto3Optional = protobuf.Bool(true)
+			result.Field[i].OneofIndex = protobuf.Int32(int32(len(result.OneofDecl)))
+			result.OneofDecl = append(result.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: protobuf.String("_" + field.Name)})
+		}
+	}
+	for _, nested := range f.Messages {
+		if nested.parentMsg == msg {
+			result.NestedType = append(result.NestedType, f.messageDescriptorProto(nested))
+		}
+	}
+	for _, field := range msg.MapFields {
+		result.NestedType = append(result.NestedType, f.messageDescriptorProto(field.GetEntryMessage()))
+	}
+	for _, enum := range f.Enums {
+		if len(enum.TypeName) == len(msg.TypeName)+1 && TypeName(enum.TypeName[:len(msg.TypeName)]).Equal(msg.TypeName) {
+			result.EnumType = append(result.EnumType, enumDescriptorProto(enum))
+		}
+	}
+
+	return result
+}
+
+func fieldDescriptorProto(field Field) *descriptorpb.FieldDescriptorProto {
+	result := &descriptorpb.FieldDescriptorProto{
+		Name:     protobuf.String(field.GetName()),
+		Number:   protobuf.Int32(int32(field.GetKeyNumber())),
+		JsonName: protobuf.String(field.GetJSONName()),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+	}
+	if field.IsRepeated() {
+		result.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	}
+	if normalField, ok := field.(*NormalField); ok && normalField.Required {
+		result.Label = descriptorpb.FieldDescriptorProto_LABEL_REQUIRED.Enum()
+	}
+	switch typ := field.GetType().(type) {
+	case *Scalar:
+		result.Type = scalarDescriptorTypes[typ.scalarKind()].Enum()
+	case *Enum:
+		result.Type = descriptorpb.FieldDescriptorProto_TYPE_ENUM.Enum()
+		result.TypeName = protobuf.String("." + typ.GetFullName())
+	case *Message:
+		result.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+		result.TypeName = protobuf.String("." + typ.GetFullName())
+	case *Map:
+		result.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		result.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+		result.TypeName = protobuf.String("." + typ.Entry.GetFullName())
+	}
+	options := field.GetOptions()
+	if option, ok := findOption(options, "default"); ok {
+		result.DefaultValue = protobuf.String(option.Constant.Source)
+	}
+	_, packed := findOption(options, "packed")
+	if packed || field.IsDeprecated() {
+		result.Options = &descriptorpb.FieldOptions{}
+		if packed {
+			result.Options.Packed = protobuf.Bool(optionIsTrue(options, "packed"))
+		}
+		if field.IsDeprecated() {
+			result.Options.Deprecated = protobuf.Bool(true)
+		}
+	}
+
+	return result
+}
+
+func enumDescriptorProto(enum *Enum) *descriptorpb.EnumDescriptorProto {
+	result := &descriptorpb.EnumDescriptorProto{Name: protobuf.String(enum.Name)}
+	options := elementsOptions(enum.Descriptor.Elements)
+	if optionIsTrue(options, "allow_alias") || optionIsTrue(options, "deprecated") {
+		result.Options = &descriptorpb.EnumOptions{}
+		if optionIsTrue(options, "allow_alias") {
+			result.Options.AllowAlias = protobuf.Bool(true)
+		}
+		if optionIsTrue(options, "deprecated") {
+			result.Options.Deprecated = protobuf.Bool(true)
+		}
+	}
+	for _, value := range enum.Values {
+		result.Value = append(result.Value, &descriptorpb.EnumValueDescriptorProto{
+			Name:   protobuf.String(value.Name),
+			Number: protobuf.Int32(int32(value.Value)),
+		})
+	}
+
+	return result
+}
+
+func serviceDescriptorProto(srv *Service) *descriptorpb.ServiceDescriptorProto {
+	result := &descriptorpb.ServiceDescriptorProto{Name: protobuf.String(srv.Name)}
+	if srv.IsDeprecated() {
+		result.Options = &descriptorpb.ServiceOptions{Deprecated: protobuf.Bool(true)}
+	}
+	for _, method := range srv.Methods {
+		methodProto := &descriptorpb.MethodDescriptorProto{
+			Name:       protobuf.String(method.Name),
+			InputType:  protobuf.String("." + method.InputMessage.GetFullName()),
+			OutputType: protobuf.String("." + method.OutputMessage.GetFullName()),
+		}
+		if method.StreamRequest {
+			methodProto.ClientStreaming = protobuf.Bool(true)
+		}
+		if method.StreamResponse {
+			methodProto.ServerStreaming = protobuf.Bool(true)
+		}
+		if method.IsDeprecated() || method.IdempotencyLevel() != IdempotencyUnknown {
+			methodProto.Options = &descriptorpb.MethodOptions{}
+			if method.IsDeprecated() {
+				methodProto.Options.Deprecated = protobuf.Bool(true)
+			}
+			if level := method.IdempotencyLevel(); level != IdempotencyUnknown {
+				methodProto.Options.IdempotencyLevel = descriptorpb.MethodOptions_IdempotencyLevel(level).Enum()
+			}
+		}
+		result.Method = append(result.Method, methodProto)
+	}
+
+	return result
+}
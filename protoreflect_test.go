@@ -0,0 +1,143 @@
+package shprotos
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	full "github.com/saturn4er/shprotos/testdata"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	protobuf "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestReflectDescriptor(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/full.proto", nil, nil)
+	require.NoError(t, err)
+	msg, ok := parsedFile.Message(TypeName{"ComplexMessage"})
+	require.True(t, ok)
+
+	md, err := msg.ReflectDescriptor()
+	require.NoError(t, err)
+	require.Equal(t, protoreflect.FullName(msg.GetFullName()), md.FullName())
+	require.Equal(t, len(msg.GetFields()), md.Fields().Len())
+	require.True(t, md.Fields().ByName("map_msg").IsMap())
+	require.Equal(t, "oneof", string(md.Oneofs().Get(0).Name()))
+	require.Equal(t, 3, md.Oneofs().Get(0).Fields().Len())
+	fd, err := msg.MapFields[0].ReflectDescriptor()
+	require.NoError(t, err)
+	require.Equal(t, protoreflect.EnumKind, fd.MapValue().Kind())
+	ed, err := parsedFile.Enums[0].ReflectDescriptor()
+	require.NoError(t, err)
+	require.Equal(t, len(parsedFile.Enums[0].Values), ed.Values().Len())
+
+	goMsg := &full.ComplexMessage{
+		Enum:         full.ComplexMessage_VALUE_B,
+		ScalarSint64: -10,
+		ScalarString: "hello",
+		Message:      &full.ComplexMessage_SimpleMessage{SomeField: 1},
+		MapEnum:      map[int32]full.ComplexMessage_SimpleEnum{1: full.ComplexMessage_VALUE_A},
+		MapMsg:       map[string]*full.ComplexMessage_SimpleMessage{"a": {SomeField2: "x"}},
+		RScalar:      []int32{1, 2},
+		RBytes:       [][]byte{{1}},
+		Oneof:        &full.ComplexMessage_OneofScalar{OneofScalar: 5},
+	}
+	data, err := MarshalMessage(map[string]interface{}{
+		"enum":          "VALUE_B",
+		"scalar_sint64": -10,
+		"scalar_string": "hello",
+		"message":       map[string]interface{}{"some_field": 1},
+		"map_enum":      map[int32]interface{}{1: "VALUE_A"},
+		"map_msg":       map[string]interface{}{"a": map[string]interface{}{"some_field2": "x"}},
+		"r_scalar":      []int32{1, 2},
+		"r_bytes":       [][]byte{{1}},
+		"oneof_scalar":  5,
+	}, msg)
+	require.NoError(t, err)
+	dynamicMsg := dynamicpb.NewMessage(md)
+	require.NoError(t, protobuf.Unmarshal(data, dynamicMsg))
+	expected, err := protojson.Marshal(proto.MessageV2(goMsg))
+	require.NoError(t, err)
+	actual, err := protojson.Marshal(dynamicMsg)
+	require.NoError(t, err)
+	require.JSONEq(t, string(expected), string(actual))
+}
+
+func TestReflectDescriptorImports(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/wkt.proto", nil, []string{"./testdata"})
+	require.NoError(t, err)
+
+	fd, err := parsedFile.ReflectDescriptor()
+	require.NoError(t, err)
+	require.Equal(t, "wkt.proto", fd.Path())
+	require.Equal(t, "google/protobuf/timestamp.proto", fd.Imports().Get(0).Path())
+	md := fd.Messages().ByName("WellKnown")
+	require.Equal(t, protoreflect.FullName("google.protobuf.Timestamp"), md.Fields().ByName("timestamp").Message().FullName())
+	require.Equal(t, protoreflect.FullName("google.protobuf.Value"), md.Fields().ByName("values").MapValue().Message().FullName())
+
+	fdProto, err := parsedFile.FileDescriptorProto()
+	require.NoError(t, err)
+	require.Equal(t, "example.wkt", fdProto.GetPackage())
+	require.Len(t, fdProto.GetDependency(), 6)
+
+	dynamicMsg := dynamicpb.NewMessage(md)
+	require.NoError(t, protojson.Unmarshal([]byte(`{"timestamp": "2019-01-01T00:00:00.5Z", "values": {"a": null}}`), dynamicMsg))
+	data, err := protobuf.Marshal(dynamicMsg)
+	require.NoError(t, err)
+	msg, ok := parsedFile.Message(TypeName{"WellKnown"})
+	require.True(t, ok)
+	value, err := UnmarshalMessage(data, msg)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"timestamp": "2019-01-01T00:00:00.500Z",
+		"values":    map[string]interface{}{"a": nil},
+	}, value)
+}
+
+func TestReflectDescriptorServices(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/service.proto", nil, nil)
+	require.NoError(t, err)
+
+	sd, err := parsedFile.Services[0].ReflectDescriptor()
+	require.NoError(t, err)
+	require.Equal(t, protoreflect.FullName("example.api.Storage"), sd.FullName())
+	require.Equal(t, 4, sd.Methods().Len())
+	for _, method := range parsedFile.Services[0].Methods {
+		md, err := method.ReflectDescriptor()
+		require.NoError(t, err)
+		require.Equal(t, method.StreamRequest, md.IsStreamingClient())
+		require.Equal(t, method.StreamResponse, md.IsStreamingServer())
+		require.Equal(t, protoreflect.FullName(method.InputMessage.GetFullName()), md.Input().FullName())
+		options := md.Options().(*descriptorpb.MethodOptions)
+		require.Equal(t, method.IsDeprecated(), options.GetDeprecated())
+		require.Equal(t, int32(method.IdempotencyLevel()), int32(options.GetIdempotencyLevel()))
+	}
+}
+
+func TestReflectDescriptorTestdata(t *testing.T) {
+	for _, path := range []string{"any.proto", "enums.proto", "full.proto", "http.proto", "maps.proto", "packed2.proto", "packed3.proto", "tree.proto"} {
+		parser := Parser{}
+		parsedFile, err := parser.Parse("./testdata/"+path, nil, []string{"./testdata"})
+		require.NoError(t, err, path)
+		fd, err := parsedFile.ReflectDescriptor()
+		require.NoError(t, err, path)
+		require.Equal(t, len(parsedFile.Services), fd.Services().Len(), path)
+	}
+}
+
+func TestReflectDescriptorWithoutFile(t *testing.T) {
+	msg := &Message{Name: "Manual", NormalFields: []*NormalField{{Name: "a", KeyNumber: 1, Type: &Scalar{ScalarName: "int32"}}}}
+	_, err := msg.ReflectDescriptor()
+	require.EqualError(t, err, "message Manual has no file")
+	_, err = msg.NormalFields[0].ReflectDescriptor()
+	require.EqualError(t, err, "field a has no message")
+	_, err = (&Enum{Name: "Kind"}).ReflectDescriptor()
+	require.EqualError(t, err, "enum Kind has no file")
+	_, err = (&Service{Name: "Storage"}).ReflectDescriptor()
+	require.EqualError(t, err, "service Storage has no file")
+}
@@ -0,0 +1,227 @@
+package shprotos
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// maxRawDepth limits nesting of groups and guessed messages, decoded by DecodeRaw. Deeper values
+// are kept as bytes, groups without their end group key.
+const maxRawDepth = 100
+
+// RawMessage is a message, decoded without schema by DecodeRaw. Fields are in wire order, so
+// elements of repeated fields are separate fields with the same number.
+type RawMessage struct {
+	Fields []RawField
+}
+
+// RawField is a field, decoded without schema. Value is:
+//   - uint64 for varint, 64-bit and 32-bit fields
+//   - *RawMessage for groups and length-delimited fields, which look like messages
+//   - string for length-delimited fields, which look like text
+//   - []uint64 for length-delimited fields, which look like packed varints
+//   - []byte for other length-delimited fields and groups, nested too deep
+type RawField struct {
+	Number   uint64
+	WireType uint64
+	Value    interface{}
+}
+
+// DecodeRaw decodes message without schema, like protoc --decode_raw does. Values of length-delimited
+// fields are guessed: printable UTF-8 is decoded to string, then bytes, which can be decoded as message,
+// are decoded to *RawMessage, then bytes of valid varints are decoded to packed values.
+func DecodeRaw(data []byte) (*RawMessage, error) {
+	fields, _, err := decodeRawFields(data, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RawMessage{Fields: fields}, nil
+}
+
+// decodeRawFields decodes fields until the end of data or end of group with given number, if it isn't zero.
+// It returns number of read bytes, including end group key.
+func decodeRawFields(data []byte, group protowire.Number, depth int) ([]RawField, int, error) {
+	var fields []RawField
+	n := 0
+	for n < len(data) {
+		number, wireType, l := protowire.ConsumeTag(data[n:])
+		if l < 0 {
+			return nil, 0, errors.Wrap(protowire.ParseError(l), "failed to decode field key")
+		}
+		n += l
+		field := RawField{Number: uint64(number), WireType: uint64(wireType)}
+		switch wireType {
+		case protowire.VarintType:
+			var value uint64
+			value, l = protowire.ConsumeVarint(data[n:])
+			field.Value = value
+		case protowire.Fixed64Type:
+			var value uint64
+			value, l = protowire.ConsumeFixed64(data[n:])
+			field.Value = value
+		case protowire.Fixed32Type:
+			var value uint32
+			value, l = protowire.ConsumeFixed32(data[n:])
+			field.Value = uint64(value)
+		case protowire.BytesType:
+			var value []byte
+			value, l = protowire.ConsumeBytes(data[n:])
+			field.Value = guessRawValue(value, depth+1)
+		case protowire.StartGroupType:
+			if depth >= maxRawDepth {
+				var value []byte
+				value, l = protowire.ConsumeGroup(number, data[n:])
+				field.Value = value
+				break
+			}
+			groupFields, groupLen, err := decodeRawFields(data[n:], number, depth+1)
+			if err != nil {
+				return nil, 0, errors.Wrapf(err, "failed to decode group %d", number)
+			}
+			field.Value = &RawMessage{Fields: groupFields}
+			l = groupLen
+		case protowire.EndGroupType:
+			if number != group {
+				return nil, 0, errors.Errorf("unexpected end of group %d", number)
+			}
+			return fields, n, nil
+		default:
+			return nil, 0, errors.Errorf("unexpected wire type %d of field %d", wireType, number)
+		}
+		if l < 0 {
+			return nil, 0, errors.Wrapf(protowire.ParseError(l), "failed to decode field %d", number)
+		}
+		n += l
+		fields = append(fields, field)
+	}
+	if group != 0 {
+		return nil, 0, errors.Errorf("group %d isn't ended", group)
+	}
+
+	return fields, n, nil
+}
+
+// guessRawValue decodes value of length-delimited field to string, message or packed varints, if it looks
+// like one, or returns it as is.
+func guessRawValue(data []byte, depth int) interface{} {
+	if isRawText(data) {
+		return string(data)
+	}
+	if depth < maxRawDepth {
+		if fields, _, err := decodeRawFields(data, 0, depth); err == nil {
+			return &RawMessage{Fields: fields}
+		}
+	}
+	if values, ok := decodeRawPacked(data); ok {
+		return values
+	}
+
+	return data
+}
+
+// isRawText reports whether data is UTF-8 text without control characters, except whitespace ones.
+func isRawText(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if !unicode.IsPrint(r) && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func decodeRawPacked(data []byte) ([]uint64, bool) {
+	var values []uint64
+	for len(data) > 0 {
+		value, n := protowire.ConsumeVarint(data)
+		if n < 0 {
+			return nil, false
+		}
+		values = append(values, value)
+		data = data[n:]
+	}
+
+	return values, true
+}
+
+// String formats message like protoc --decode_raw: varints in decimal, fixed values in hex, nested
+// messages and groups in braces.
+func (m *RawMessage) String() string {
+	var b strings.Builder
+	m.format(&b, "")
+
+	return b.String()
+}
+
+func (m *RawMessage) format(b *strings.Builder, indent string) {
+	for _, field := range m.Fields {
+		b.WriteString(indent)
+		switch value := field.Value.(type) {
+		case *RawMessage:
+			fmt.Fprintf(b, "%d {\n", field.Number)
+			value.format(b, indent+"  ")
+			b.WriteString(indent)
+			b.WriteString("}\n")
+			continue
+		case uint64:
+			switch field.WireType {
+			case WireType64Bit:
+				fmt.Fprintf(b, "%d: 0x%016x", field.Number, value)
+			case WireType32Bit:
+				fmt.Fprintf(b, "%d: 0x%08x", field.Number, value)
+			default:
+				fmt.Fprintf(b, "%d: %d", field.Number, value)
+			}
+		case string:
+			fmt.Fprintf(b, "%d: %s", field.Number, quoteRawBytes([]byte(value), true))
+		case []uint64:
+			fmt.Fprintf(b, "%d: [", field.Number)
+			for i, v := range value {
+				if i > 0 {
+					b.WriteString(", ")
+				}
+				fmt.Fprint(b, v)
+			}
+			b.WriteString("]")
+		case []byte:
+			fmt.Fprintf(b, "%d: %s", field.Number, quoteRawBytes(value, false))
+		}
+		b.WriteString("\n")
+	}
+}
+
+// quoteRawBytes quotes value with C escapes, writing bytes out of printable ASCII in octal. If value is text,
+// non-ASCII characters are written as is.
+func quoteRawBytes(value []byte, text bool) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, c := range value {
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c >= 0x20 && c < 0x7f, text && c >= 0x80:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, `\%03o`, c)
+		}
+	}
+	b.WriteByte('"')
+
+	return b.String()
+}
@@ -0,0 +1,79 @@
+package shprotos
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	full "github.com/saturn4er/shprotos/testdata"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeRaw(t *testing.T) {
+	data, err := proto.Marshal(&full.ComplexMessage{
+		ScalarInt32:   -1,
+		ScalarFixed32: 7,
+		ScalarDouble:  1,
+		ScalarString:  "hello\n\"world\"",
+		Message:       &full.ComplexMessage_SimpleMessage{SomeField: 150, SomeField2: "nested"},
+		Bytes:         []byte{0, 1, 0xff},
+		RScalar:       []int32{1, 300},
+	})
+	require.NoError(t, err)
+
+	raw, err := DecodeRaw(data)
+	require.NoError(t, err)
+	require.Equal(t, &RawMessage{Fields: []RawField{
+		{Number: 2, WireType: WireTypeVarint, Value: uint64(1<<64 - 1)},
+		{Number: 8, WireType: WireType32Bit, Value: uint64(7)},
+		{Number: 12, WireType: WireType64Bit, Value: uint64(0x3ff0000000000000)},
+		{Number: 15, WireType: WireTypeLengthDelimited, Value: "hello\n\"world\""},
+		{Number: 16, WireType: WireTypeLengthDelimited, Value: &RawMessage{Fields: []RawField{
+			{Number: 1, WireType: WireTypeVarint, Value: uint64(150)},
+			{Number: 2, WireType: WireTypeLengthDelimited, Value: "nested"},
+		}}},
+		{Number: 17, WireType: WireTypeLengthDelimited, Value: []byte{0, 1, 0xff}},
+		{Number: 24, WireType: WireTypeLengthDelimited, Value: []uint64{1, 300}},
+	}}, raw)
+	require.Equal(t, `2: 18446744073709551615
+8: 0x00000007
+12: 0x3ff0000000000000
+15: "hello\n\"world\""
+16 {
+  1: 150
+  2: "nested"
+}
+17: "\000\001\377"
+24: [1, 300]
+`, raw.String())
+
+	group := append(proto.EncodeVarint(messageKeyVarint(5, WireTypeStartGroup)), 8, 1)
+	group = append(group, proto.EncodeVarint(messageKeyVarint(5, WireTypeEndGroup))...)
+	raw, err = DecodeRaw(group)
+	require.NoError(t, err)
+	require.Equal(t, "5 {\n  1: 1\n}\n", raw.String())
+
+	// groups deeper than the limit are kept as bytes
+	var deep []byte
+	for i := 0; i <= maxRawDepth; i++ {
+		deep = append(deep, proto.EncodeVarint(messageKeyVarint(5, WireTypeStartGroup))...)
+	}
+	deep = append(deep, 8, 1)
+	for i := 0; i <= maxRawDepth; i++ {
+		deep = append(deep, proto.EncodeVarint(messageKeyVarint(5, WireTypeEndGroup))...)
+	}
+	raw, err = DecodeRaw(deep)
+	require.NoError(t, err)
+	innermost := raw
+	for i := 0; i < maxRawDepth; i++ {
+		require.Len(t, innermost.Fields, 1)
+		innermost = innermost.Fields[0].Value.(*RawMessage)
+	}
+	require.Equal(t, []RawField{{Number: 5, WireType: WireTypeStartGroup, Value: []byte{8, 1}}}, innermost.Fields)
+	_, err = DecodeRaw(deep[:len(deep)-1])
+	require.Error(t, err)
+
+	for _, invalid := range [][]byte{{0x0a, 0x05, 1}, {0x80}, {0x2b, 8, 1}, {0x2c}, {0x0f}, {0x00}} {
+		_, err := DecodeRaw(invalid)
+		require.Error(t, err, "%v", invalid)
+	}
+}
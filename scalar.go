@@ -1,8 +1,158 @@
 package shprotos
 
+import (
+	"reflect"
+)
+
+type ScalarKind byte
+
+const (
+	ScalarUndefined ScalarKind = iota
+	ScalarDouble
+	ScalarFloat
+	ScalarInt32
+	ScalarInt64
+	ScalarUint32
+	ScalarUint64
+	ScalarSint32
+	ScalarSint64
+	ScalarFixed32
+	ScalarFixed64
+	ScalarSfixed32
+	ScalarSfixed64
+	ScalarBool
+	ScalarString
+	ScalarBytes
+)
+
+type scalarKindInfo struct {
+	name     string
+	wireType uint64
+	goType   reflect.Type
+	zero     interface{}
+	signed   bool
+}
+
+var scalarKinds = [...]scalarKindInfo{
+	ScalarUndefined: {},
+	ScalarDouble:    {"double", WireType64Bit, reflect.TypeOf(float64(0)), float64(0), true},
+	ScalarFloat:     {"float", WireType32Bit, reflect.TypeOf(float32(0)), float32(0), true},
+	ScalarInt32:     {"int32", WireTypeVarint, reflect.TypeOf(int32(0)), int32(0), true},
+	ScalarInt64:     {"int64", WireTypeVarint, reflect.TypeOf(int64(0)), int64(0), true},
+	ScalarUint32:    {"uint32", WireTypeVarint, reflect.TypeOf(uint32(0)), uint32(0), false},
+	ScalarUint64:    {"uint64", WireTypeVarint, reflect.TypeOf(uint64(0)), uint64(0), false},
+	ScalarSint32:    {"sint32", WireTypeVarint, reflect.TypeOf(int32(0)), int32(0), true},
+	ScalarSint64:    {"sint64", WireTypeVarint, reflect.TypeOf(int64(0)), int64(0), true},
+	ScalarFixed32:   {"fixed32", WireType32Bit, reflect.TypeOf(uint32(0)), uint32(0), false},
+	ScalarFixed64:   {"fixed64", WireType64Bit, reflect.TypeOf(uint64(0)), uint64(0), false},
+	ScalarSfixed32:  {"sfixed32", WireType32Bit, reflect.TypeOf(int32(0)), int32(0), true},
+	ScalarSfixed64:  {"sfixed64", WireType64Bit, reflect.TypeOf(int64(0)), int64(0), true},
+	ScalarBool:      {"bool", WireTypeVarint, reflect.TypeOf(false), false, false},
+	ScalarString:    {"string", WireTypeLengthDelimited, reflect.TypeOf(""), "", false},
+	ScalarBytes:     {"bytes", WireTypeLengthDelimited, reflect.TypeOf([]byte(nil)), []byte(nil), false},
+}
+
+// canonicalScalars holds the only Scalar instance of every kind, so scalar types can be compared with ==.
+var canonicalScalars [len(scalarKinds)]*Scalar
+
+func init() {
+	for kind := range scalarKinds {
+		if ScalarKind(kind) == ScalarUndefined {
+			continue
+		}
+		canonicalScalars[kind] = &Scalar{ScalarName: scalarKinds[kind].name, ScalarKind: ScalarKind(kind)}
+	}
+}
+
+// ScalarKindByName returns scalar kind by its proto name, like "int32" or "bytes".
+func ScalarKindByName(name string) (ScalarKind, bool) {
+	for kind, info := range scalarKinds {
+		if ScalarKind(kind) != ScalarUndefined && info.name == name {
+			return ScalarKind(kind), true
+		}
+	}
+
+	return ScalarUndefined, false
+}
+
+func (k ScalarKind) String() string {
+	if int(k) >= len(scalarKinds) || k == ScalarUndefined {
+		return "undefined"
+	}
+
+	return scalarKinds[k].name
+}
+
+// WireType returns wire type used to encode non-packed value of this kind.
+func (k ScalarKind) WireType() uint64 {
+	return scalarKinds[k].wireType
+}
+
+// GoType returns type of Go value, which represents value of this kind.
+func (k ScalarKind) GoType() reflect.Type {
+	return scalarKinds[k].goType
+}
+
+// Zero returns Go zero value of this kind.
+func (k ScalarKind) Zero() interface{} {
+	return scalarKinds[k].zero
+}
+
+// IsSigned reports whether value of this kind can be negative.
+func (k ScalarKind) IsSigned() bool {
+	return scalarKinds[k].signed
+}
+
+// IsIntegral reports whether kind represents integer value.
+func (k ScalarKind) IsIntegral() bool {
+	switch k {
+	case ScalarInt32, ScalarInt64, ScalarUint32, ScalarUint64, ScalarSint32, ScalarSint64,
+		ScalarFixed32, ScalarFixed64, ScalarSfixed32, ScalarSfixed64:
+		return true
+	}
+
+	return false
+}
+
+// IsPackable reports whether repeated field of this kind can use packed encoding.
+func (k ScalarKind) IsPackable() bool {
+	return k != ScalarUndefined && k != ScalarString && k != ScalarBytes
+}
+
+// ScalarByKind returns canonical Scalar of given kind.
+func ScalarByKind(kind ScalarKind) *Scalar {
+	if int(kind) >= len(canonicalScalars) {
+		return nil
+	}
+
+	return canonicalScalars[kind]
+}
+
+// ScalarByName returns canonical Scalar by its proto name.
+func ScalarByName(name string) (*Scalar, bool) {
+	kind, ok := ScalarKindByName(name)
+	if !ok {
+		return nil, false
+	}
+
+	return canonicalScalars[kind], true
+}
+
+// Scalar is a built-in scalar type. Parser uses canonical instances, returned by ScalarByName, so scalars
+// aren't bound to a file. ScalarKind of manually built Scalar may be left unset, then it's resolved by ScalarName.
 type Scalar struct {
-	file       *File
 	ScalarName string
+	ScalarKind ScalarKind
+}
+
+// scalarKind returns ScalarKind or resolves it by ScalarName, if it's unset.
+func (s Scalar) scalarKind() ScalarKind {
+	if s.ScalarKind != ScalarUndefined {
+		return s.ScalarKind
+	}
+	kind, _ := ScalarKindByName(s.ScalarName)
+
+	return kind
 }
 
 func (s Scalar) String() string {
@@ -13,6 +163,8 @@ func (s Scalar) Kind() TypeKind {
 	return TypeScalar
 }
 
+// File always returns nil, as scalars are shared between all parsed files. Use File of field's message
+// to find the file, where scalar is used.
 func (s Scalar) File() *File {
-	return s.file
+	return nil
 }
@@ -0,0 +1,36 @@
+package shprotos
+
+import (
+	"reflect"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestScalar(t *testing.T) {
+	Convey("Test Scalar", t, func() {
+		Convey("Should return canonical scalars", func() {
+			int32Type, ok := ScalarByName("int32")
+			So(ok, ShouldBeTrue)
+			So(int32Type, ShouldEqual, ScalarByKind(ScalarInt32))
+			So(int32Type.ScalarKind, ShouldEqual, ScalarInt32)
+			So(int32Type.ScalarName, ShouldEqual, "int32")
+
+			_, ok = ScalarByName("SomeMessage")
+			So(ok, ShouldBeFalse)
+		})
+		Convey("Should return scalar kind metadata", func() {
+			So(ScalarSint64.WireType(), ShouldEqual, WireTypeVarint)
+			So(ScalarSfixed32.WireType(), ShouldEqual, WireType32Bit)
+			So(ScalarDouble.WireType(), ShouldEqual, WireType64Bit)
+			So(ScalarBytes.WireType(), ShouldEqual, WireTypeLengthDelimited)
+			So(ScalarFixed32.GoType(), ShouldEqual, reflect.TypeOf(uint32(0)))
+			So(ScalarSint64.Zero(), ShouldEqual, int64(0))
+			So(ScalarSfixed64.IsSigned(), ShouldBeTrue)
+			So(ScalarFixed64.IsSigned(), ShouldBeFalse)
+			So(ScalarBool.IsIntegral(), ShouldBeFalse)
+			So(ScalarUint32.IsIntegral(), ShouldBeTrue)
+			So(ScalarString.String(), ShouldEqual, "string")
+		})
+	})
+}
@@ -1,10 +1,76 @@
 package shprotos
 
+import (
+	"github.com/emicklei/proto"
+)
+
+type StreamingKind byte
+
+const (
+	StreamingUnary StreamingKind = iota
+	StreamingClient
+	StreamingServer
+	StreamingBidi
+)
+
+func (s StreamingKind) String() string {
+	switch s {
+	case StreamingClient:
+		return "client streaming"
+	case StreamingServer:
+		return "server streaming"
+	case StreamingBidi:
+		return "bidi streaming"
+	}
+
+	return "unary"
+}
+
+type IdempotencyLevel byte
+
+const (
+	IdempotencyUnknown IdempotencyLevel = iota
+	IdempotencyNoSideEffects
+	IdempotencyIdempotent
+)
+
+func (i IdempotencyLevel) String() string {
+	switch i {
+	case IdempotencyNoSideEffects:
+		return "NO_SIDE_EFFECTS"
+	case IdempotencyIdempotent:
+		return "IDEMPOTENT"
+	}
+
+	return "IDEMPOTENCY_UNKNOWN"
+}
+
 type Service struct {
 	Name          string
 	QuotedComment string
 	Methods       []*Method
 	File          *File
+	Descriptor    *proto.Service
+}
+
+func (s Service) GetFullName() string {
+	if s.File == nil || s.File.PkgName == "" {
+		return s.Name
+	}
+
+	return s.File.PkgName + "." + s.Name
+}
+
+func (s Service) GetOptions() []*proto.Option {
+	if s.Descriptor == nil {
+		return nil
+	}
+
+	return elementsOptions(s.Descriptor.Elements)
+}
+
+func (s Service) IsDeprecated() bool {
+	return optionIsTrue(s.GetOptions(), "deprecated")
 }
 
 type Method struct {
@@ -15,4 +81,66 @@ type Method struct {
 	StreamRequest  bool
 	StreamResponse bool
 	Service        *Service
+	Descriptor     *proto.RPC
+	HTTPRule       *HTTPRule
+	// HTTPRuleErr is an error of google.api.http option parsing. HTTPRule is nil, if it's set.
+	HTTPRuleErr error
+}
+
+func (m Method) GetFullName() string {
+	return m.Service.GetFullName() + "." + m.Name
+}
+
+// FullPath returns gRPC method path, like "/pkg.Service/Method".
+func (m Method) FullPath() string {
+	return "/" + m.Service.GetFullName() + "/" + m.Name
+}
+
+func (m Method) StreamingKind() StreamingKind {
+	switch {
+	case m.StreamRequest && m.StreamResponse:
+		return StreamingBidi
+	case m.StreamRequest:
+		return StreamingClient
+	case m.StreamResponse:
+		return StreamingServer
+	}
+
+	return StreamingUnary
+}
+
+func (m Method) GetOptions() []*proto.Option {
+	if m.Descriptor == nil {
+		return nil
+	}
+
+	return elementsOptions(m.Descriptor.Elements)
+}
+
+// HTTPRules returns method http rule followed by its additional bindings.
+func (m Method) HTTPRules() []*HTTPRule {
+	if m.HTTPRule == nil {
+		return nil
+	}
+
+	return append([]*HTTPRule{m.HTTPRule}, m.HTTPRule.AdditionalBindings...)
+}
+
+func (m Method) IsDeprecated() bool {
+	return optionIsTrue(m.GetOptions(), "deprecated")
+}
+
+func (m Method) IdempotencyLevel() IdempotencyLevel {
+	option, ok := findOption(m.GetOptions(), "idempotency_level")
+	if !ok {
+		return IdempotencyUnknown
+	}
+	switch option.Constant.Source {
+	case "NO_SIDE_EFFECTS":
+		return IdempotencyNoSideEffects
+	case "IDEMPOTENT":
+		return IdempotencyIdempotent
+	}
+
+	return IdempotencyUnknown
 }
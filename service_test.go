@@ -0,0 +1,46 @@
+package shprotos
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestService(t *testing.T) {
+	Convey("Test Service", t, func(c C) {
+		parser := Parser{}
+		file, err := parser.Parse("./testdata/service.proto", nil, nil)
+		c.So(err, ShouldBeNil)
+		c.So(file.Services, ShouldHaveLength, 1)
+		srv := file.Services[0]
+
+		c.Convey("Should return service full name", func(c C) {
+			c.So(srv.GetFullName(), ShouldEqual, "example.api.Storage")
+		})
+		c.Convey("Should return methods metadata", func(c C) {
+			c.So(srv.Methods, ShouldHaveLength, 4)
+			get, upload, watch, sync := srv.Methods[0], srv.Methods[1], srv.Methods[2], srv.Methods[3]
+
+			c.So(get.FullPath(), ShouldEqual, "/example.api.Storage/Get")
+			c.So(get.GetFullName(), ShouldEqual, "example.api.Storage.Get")
+			c.So(get.StreamingKind(), ShouldEqual, StreamingUnary)
+			c.So(get.IdempotencyLevel(), ShouldEqual, IdempotencyNoSideEffects)
+			c.So(get.IsDeprecated(), ShouldBeFalse)
+
+			c.So(upload.StreamingKind(), ShouldEqual, StreamingClient)
+			c.So(upload.IdempotencyLevel(), ShouldEqual, IdempotencyUnknown)
+
+			c.So(watch.StreamingKind(), ShouldEqual, StreamingServer)
+			c.So(watch.IsDeprecated(), ShouldBeTrue)
+
+			c.So(sync.StreamingKind(), ShouldEqual, StreamingBidi)
+			c.So(sync.IdempotencyLevel(), ShouldEqual, IdempotencyIdempotent)
+			c.So(sync.GetOptions(), ShouldHaveLength, 1)
+		})
+		c.Convey("Should fail, if method request type is not a message", func(c C) {
+			_, err := parser.Parse("./testdata/invalid/service.proto", nil, nil)
+			c.So(err, ShouldNotBeNil)
+			c.So(err.Error(), ShouldContainSubstring, "Status enum is not a message")
+		})
+	})
+}
@@ -0,0 +1,292 @@
+package shprotos
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// MessageSize returns length of data, marshalled as message.
+func MessageSize(data map[string]interface{}, message *Message) (int, error) {
+	return MarshalOptions{}.Size(data, message)
+}
+
+// Size returns length of data, marshalled as message. Values are validated the same way, as Marshal does.
+func (o MarshalOptions) Size(data map[string]interface{}, message *Message) (int, error) {
+	if o.Resolver == nil {
+		o.Resolver = defaultResolver(message)
+	}
+	return (&encoder{o: o}).messageSize(data, message, "")
+}
+
+// messageSize is size pass of message. Errors about wrong values are returned as *FieldError with path,
+// prefixed with given one.
+func (e *encoder) messageSize(data map[string]interface{}, message *Message, path string) (int, error) {
+	skipFields, err := e.o.resolveOneOfs(data, message, path)
+	if err != nil {
+		return 0, err
+	}
+	plan := planOf(message)
+	fields := plan.fields
+	if e.o.Deterministic {
+		fields = plan.ordered
+	}
+	size := 0
+	for _, fp := range fields {
+		fieldValue, ok := data[fp.name]
+		if !ok || (fieldValue == nil && !holdsNullValue(fp.field)) || skipFields[fp.name] {
+			continue
+		}
+		fieldPath := joinFieldPath(path, fp.name)
+		var n int
+		switch {
+		case fp.mapField != nil:
+			n, err = e.mapFieldSize(fieldValue, fp, fieldPath)
+		case fp.normal.Repeated:
+			n, err = e.repeatedFieldSize(fieldValue, fp, fieldPath)
+		default:
+			n, err = e.fieldSize(fieldValue, fp, fieldPath)
+		}
+		if err != nil {
+			return 0, err
+		}
+		size += n
+	}
+	if unknown, ok := data[UnknownFieldsKey]; ok {
+		raw, err := bytesFromInterface(unknown)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to resolve unknown fields")
+		}
+		size += len(raw)
+	}
+	return size, nil
+}
+
+func (e *encoder) mapFieldSize(value interface{}, fp *fieldPlan, path string) (int, error) {
+	fld := fp.mapField
+	mapValue := reflect.ValueOf(value)
+	if mapValue.Kind() != reflect.Map {
+		return 0, newFieldError(path, protoTypeName(fld.Map), value, nil)
+	}
+	keys := mapValue.MapKeys()
+	if e.o.Deterministic {
+		var err error
+		if keys, err = sortedMapKeys(mapValue, fld.Map.KeyType, path); err != nil {
+			return 0, err
+		}
+	}
+	// keys are recorded, so append pass iterates map in the same order
+	e.keys = append(e.keys, keys)
+	size := 0
+	for _, key := range keys {
+		index := e.reserveSize()
+		mapKey := key.Interface()
+		entryValue := mapValue.MapIndex(key).Interface()
+		entryPath := fmt.Sprintf("%s[%v]", path, mapKey)
+		entrySize, err := e.fieldSize(mapKey, fp.mapKey, entryPath)
+		if err != nil {
+			return 0, err
+		}
+		// nil google.protobuf.Value is a null value, not a missing one
+		if entryValue != nil || isWellKnownType(fld.Map.ValueType, wktValue) {
+			n, err := e.fieldSize(entryValue, fp.mapValue, entryPath)
+			if err != nil {
+				return 0, err
+			}
+			entrySize += n
+		}
+		e.sizes[index] = entrySize
+		size += len(fp.key) + protowire.SizeBytes(entrySize)
+	}
+	return size, nil
+}
+
+func (e *encoder) repeatedFieldSize(value interface{}, fp *fieldPlan, path string) (int, error) {
+	values := reflect.ValueOf(value)
+	if values.Kind() != reflect.Slice && values.Kind() != reflect.Array {
+		return 0, newFieldError(path, "repeated "+protoTypeName(fp.normal.Type), value, nil)
+	}
+	size := 0
+	if !fp.packed {
+		for i := 0; i < values.Len(); i++ {
+			n, err := e.elementSize(values.Index(i).Interface(), fp, path, i)
+			if err != nil {
+				return 0, err
+			}
+			size += len(fp.key) + n
+		}
+		return size, nil
+	}
+	if values.Len() == 0 {
+		return 0, nil
+	}
+	index := e.reserveSize()
+	for i := 0; i < values.Len(); i++ {
+		n, err := e.elementSize(values.Index(i).Interface(), fp, path, i)
+		if err != nil {
+			return 0, err
+		}
+		size += n
+	}
+	e.sizes[index] = size
+	return len(fp.packedKey) + protowire.SizeBytes(size), nil
+}
+
+// fieldSize returns size of single field value with its key.
+func (e *encoder) fieldSize(value interface{}, fp *fieldPlan, path string) (int, error) {
+	if fp.scalar == ScalarUndefined {
+		n, err := e.valueSize(value, fp.normal.Type, path)
+		return len(fp.key) + n, err
+	}
+	n, err := e.scalarSize(value, fp.scalar)
+	if err != nil {
+		return 0, newFieldError(path, fp.scalar.String(), value, err)
+	}
+	return len(fp.key) + n, nil
+}
+
+// elementSize returns size of element of repeated field without key. Path of scalar element is formatted
+// only on error.
+func (e *encoder) elementSize(value interface{}, fp *fieldPlan, path string, i int) (int, error) {
+	if fp.scalar == ScalarUndefined {
+		return e.valueSize(value, fp.normal.Type, fmt.Sprintf("%s[%d]", path, i))
+	}
+	n, err := e.scalarSize(value, fp.scalar)
+	if err != nil {
+		return 0, newFieldError(fmt.Sprintf("%s[%d]", path, i), fp.scalar.String(), value, err)
+	}
+	return n, nil
+}
+
+// valueSize returns size of single value of given type without field key. Data of embedded messages
+// and their sizes are recorded for append pass. Lazy messages, which weren't decoded, are recorded as raw bytes.
+func (e *encoder) valueSize(value interface{}, typ Type, path string) (int, error) {
+	switch typ := typ.(type) {
+	case *Scalar:
+		n, err := e.scalarSize(value, typ.scalarKind())
+		if err != nil {
+			return 0, newFieldError(path, protoTypeName(typ), value, err)
+		}
+		return n, nil
+	case *Enum:
+		number, name, err := resolveEnumValue(value, typ)
+		if err != nil {
+			return 0, newFieldError(path, protoTypeName(typ), value, err)
+		}
+		if name == "" && typ.IsClosed() {
+			return 0, newFieldError(path, protoTypeName(typ), value, errors.Errorf("unknown %s value %d", typ.Name, number))
+		}
+		return protowire.SizeVarint(uint64(number)), nil
+	case *Message:
+		if lazy, ok := value.(*LazyMessage); ok && !lazy.Decoded() && lazy.msg.GetFullName() == typ.GetFullName() {
+			e.sizes = append(e.sizes, len(lazy.raw))
+			e.messages = append(e.messages, embeddedMessage{raw: lazy.raw})
+			return protowire.SizeBytes(len(lazy.raw)), nil
+		}
+		data, err := e.o.messageData(value, typ, path)
+		if err != nil {
+			return 0, err
+		}
+		if data == nil {
+			data = map[string]interface{}{}
+		}
+		index := e.reserveSize()
+		e.messages = append(e.messages, embeddedMessage{data: data})
+		size, err := e.messageSize(data, typ, path)
+		if err != nil {
+			return 0, err
+		}
+		e.sizes[index] = size
+		return protowire.SizeBytes(size), nil
+	}
+	return 0, nil
+}
+
+// scalarSize returns size of single scalar value. Bytes, given as base64 strings, are decoded once
+// and recorded for append pass.
+func (e *encoder) scalarSize(value interface{}, kind ScalarKind) (int, error) {
+	if str, ok := value.(string); ok && kind == ScalarBytes {
+		data, err := bytesFromInterface(str)
+		if err != nil {
+			return 0, err
+		}
+		e.bytes = append(e.bytes, data)
+		return protowire.SizeBytes(len(data)), nil
+	}
+	return scalarSizers[kind](value)
+}
+
+// scalarSizers return size of single value of each scalar kind. They validate values the same way,
+// as scalarEncoders do.
+var scalarSizers = [...]func(value interface{}) (int, error){
+	ScalarDouble:   sizeFixed64Float,
+	ScalarFloat:    sizeFixed32Float,
+	ScalarInt32:    sizeVarint,
+	ScalarInt64:    sizeVarint,
+	ScalarUint32:   sizeVarint,
+	ScalarUint64:   sizeVarint,
+	ScalarSint32:   sizeZigzag32,
+	ScalarSint64:   sizeZigzag64,
+	ScalarFixed32:  sizeFixed32,
+	ScalarFixed64:  sizeFixed64,
+	ScalarSfixed32: sizeFixed32,
+	ScalarSfixed64: sizeFixed64,
+	ScalarBool:     sizeBool,
+	ScalarString:   sizeString,
+	ScalarBytes:    sizeBytes,
+}
+
+func sizeFixed64Float(value interface{}) (int, error) {
+	_, err := float64FromInterface(value)
+	return protowire.SizeFixed64(), err
+}
+
+func sizeFixed32Float(value interface{}) (int, error) {
+	_, err := float32FromInterface(value)
+	return protowire.SizeFixed32(), err
+}
+
+func sizeVarint(value interface{}) (int, error) {
+	val, err := uint64FromInterface(value)
+	return protowire.SizeVarint(val), err
+}
+
+func sizeZigzag32(value interface{}) (int, error) {
+	val, err := uint64FromInterface(value)
+	return protowire.SizeVarint(protowire.EncodeZigZag(int64(int32(val)))), err
+}
+
+func sizeZigzag64(value interface{}) (int, error) {
+	val, err := uint64FromInterface(value)
+	return protowire.SizeVarint(protowire.EncodeZigZag(int64(val))), err
+}
+
+func sizeFixed32(value interface{}) (int, error) {
+	_, err := uint64FromInterface(value)
+	return protowire.SizeFixed32(), err
+}
+
+func sizeFixed64(value interface{}) (int, error) {
+	_, err := uint64FromInterface(value)
+	return protowire.SizeFixed64(), err
+}
+
+func sizeBool(value interface{}) (int, error) {
+	_, err := boolFromInterface(value)
+	return 1, err
+}
+
+func sizeString(value interface{}) (int, error) {
+	str, ok := value.(string)
+	if !ok {
+		return 0, errors.Errorf("can't convert %T to string", value)
+	}
+	return protowire.SizeBytes(len(str)), nil
+}
+
+func sizeBytes(value interface{}) (int, error) {
+	bytesValue, err := bytesFromInterface(value)
+	return protowire.SizeBytes(len(bytesValue)), err
+}
@@ -13,5 +13,6 @@ const (
 type Type interface {
 	String() string
 	Kind() TypeKind
+	// File returns file, where type is declared. Scalars are built-in, so it's always nil for them.
 	File() *File
 }
@@ -3,11 +3,11 @@ package shprotos
 import (
 	"encoding/base64"
 	"fmt"
-	"io"
 	"math"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
 const (
@@ -19,300 +19,657 @@ const (
 	WireType32Bit           = 5
 )
 
+const (
+	// UnknownFieldsKey is a reserved key, which holds raw bytes of fields, missing in message schema.
+	UnknownFieldsKey = "@unknown"
+	// OneOfCasesKey is a reserved key, which holds map[string]string of oneof names to their set field names.
+	OneOfCasesKey = "@oneof"
+	// AnyTypeKey is a reserved key of expanded google.protobuf.Any value, which holds its type URL.
+	AnyTypeKey = "@type"
+)
+
+type UnmarshalOptions struct {
+	// KeepUnknownFields stores fields, missing in message schema, under UnknownFieldsKey as raw []byte,
+	// so MarshalMessage can emit them back. Otherwise such fields are skipped.
+	KeepUnknownFields bool
+	// TypedMapKeys makes map fields decode to map[interface{}]interface{} with keys of key scalar Go type
+	// (int32, uint64, bool, string...). Otherwise keys are formatted as strings.
+	TypedMapKeys bool
+	// ReportOneOfCases stores names of set oneof members under OneOfCasesKey.
+	ReportOneOfCases bool
+	// EnumsAsNames decodes enum values to their names. Numbers, unknown to enum, are kept as int32.
+	// Otherwise enums are decoded to int32.
+	EnumsAsNames bool
+	// Resolver resolves type URLs of google.protobuf.Any values. If it's nil, messages are looked up
+	// in file of unmarshalled message and its imports.
+	Resolver MessageResolver
+	// EmitDefaults populates every declared field, missing on the wire, with its zero value: scalars with
+	// zero values, enums with their default value, repeated and map fields with empty containers. Fields with
+	// explicit presence, like messages and proto2 scalars, are set to nil. Oneof members and google.protobuf.Value
+	// fields, where nil is a null value, are left unset.
+	EmitDefaults bool
+	// EmptyMessages makes EmitDefaults populate message fields with empty messages, which have their own
+	// defaults populated, instead of nil. Recursive message fields are still set to nil.
+	EmptyMessages bool
+	// NilContainers makes EmitDefaults populate repeated and map fields with nil slices and maps
+	// of the same types instead of empty ones.
+	NilContainers bool
+	// Projection selects fields to decode, others are skipped on the wire and aren't populated by EmitDefaults.
+	// If it's nil, all fields are decoded.
+	Projection *Projection
+	// AliasBytes decodes bytes values to []byte, which alias unmarshalled data or bytes of Go message,
+	// instead of base64 strings. Source shouldn't be changed, while decoded values are used.
+	AliasBytes bool
+	// LazyMessages decodes values of message fields, except well-known types and Any, to *LazyMessage,
+	// which keeps raw bytes, aliasing unmarshalled data, and decodes them with the same options on first access.
+	LazyMessages bool
+	// MaxDepth limits nesting of messages and groups, root message has depth 1. Exceeding it
+	// returns *DepthLimitError. Zero means no limit.
+	MaxDepth int
+	// MaxSize limits length of unmarshalled data. Exceeding it returns *SizeLimitError. Zero means no limit.
+	MaxSize int
+	// MaxElements limits number of elements of each repeated field and entries of each map field.
+	// Exceeding it returns *ElementsLimitError. Zero means no limit.
+	MaxElements int
+	// MaxLength limits length of each string and bytes value. It's checked before value is read,
+	// exceeding it returns *LengthLimitError. Zero means no limit.
+	MaxLength int
+}
+
 func UnmarshalMessage(data []byte, msg *Message) (map[string]interface{}, error) {
-	return unmarshalMessageBytesToMap(proto.NewBuffer(data), msg)
+	return UnmarshalOptions{}.Unmarshal(data, msg)
+}
+
+// UnmarshalMessageFields decodes only fields of msg, selected by paths. See NewProjection for paths format.
+func UnmarshalMessageFields(data []byte, msg *Message, paths ...string) (map[string]interface{}, error) {
+	projection, err := NewProjection(msg, paths...)
+	if err != nil {
+		return nil, err
+	}
+	return UnmarshalOptions{Projection: projection}.Unmarshal(data, msg)
+}
+
+func (o UnmarshalOptions) Unmarshal(data []byte, msg *Message) (map[string]interface{}, error) {
+	if o.Resolver == nil {
+		o.Resolver = defaultResolver(msg)
+	}
+	if o.Projection != nil && o.Projection.msg.GetFullName() != msg.GetFullName() {
+		return nil, errors.Errorf("projection of %s can't be applied to %s", o.Projection.msg.GetFullName(), msg.GetFullName())
+	}
+	if o.MaxSize > 0 && len(data) > o.MaxSize {
+		return nil, &SizeLimitError{Size: len(data), Limit: o.MaxSize}
+	}
+	return o.unmarshalMessage(data, msg, o.Projection, 1)
+}
+
+// unmarshalMessage decodes message with given projection and depth and populates its defaults.
+func (o UnmarshalOptions) unmarshalMessage(data []byte, msg *Message, projection *Projection, depth int) (map[string]interface{}, error) {
+	result, err := o.unmarshalMessageBytesToMap(proto.NewBuffer(data), msg, projection, depth)
+	if err != nil {
+		return nil, err
+	}
+	if o.EmitDefaults {
+		o.populateDefaults(result, msg, projection, nil)
+	}
+	return result, nil
 }
-func unmarshalMessageBytesToMap(buffer *proto.Buffer, msg *Message) (map[string]interface{}, error) {
+
+// unmarshalMessageBytesToMap decodes message fields. depth is nesting level of message, checked against MaxDepth.
+func (o UnmarshalOptions) unmarshalMessageBytesToMap(buffer *proto.Buffer, msg *Message, projection *Projection, depth int) (map[string]interface{}, error) {
+	if o.MaxDepth > 0 && depth > o.MaxDepth {
+		return nil, &DepthLimitError{Limit: o.MaxDepth}
+	}
 	result := make(map[string]interface{})
-	for {
+	plan := planOf(msg)
+	for len(buffer.Unread()) > 0 {
 		key, err := buffer.DecodeVarint()
 		if err != nil {
-			if err == io.ErrUnexpectedEOF {
-				return result, nil
-			}
 			return nil, errors.Wrap(err, "failed to get key")
 		}
 		fieldNum := key >> 3
-		messageField, ok := msg.FieldByKeyNumber(fieldNum)
+		fp, ok := plan.fieldByNumber(fieldNum)
 		if !ok {
-			return nil, errors.Errorf("can't find field %d in message %s", fieldNum, msg.Name)
+			raw, err := o.skipField(buffer, key, depth+1)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to skip unknown field %d in message %s", fieldNum, msg.Name)
+			}
+			if o.KeepUnknownFields {
+				unknown, _ := result[UnknownFieldsKey].([]byte)
+				result[UnknownFieldsKey] = append(unknown, raw...)
+			}
+			continue
 		}
-		switch key & 7 {
-		case WireTypeVarint:
-			switch typ := messageField.GetType().(type) {
-			case *Enum:
-				value, err := buffer.DecodeVarint()
-				if err != nil {
-					return nil, errors.Wrap(err, "failed to decode varint")
-				}
-				result[messageField.GetName()] = value
-			case *Scalar:
-				if typ.ScalarName == "bytes" {
-					return nil, errors.New("can't assign varint to bytes field")
-				}
-
-				res, err := unmarshaScalar(buffer, typ)
-				if err != nil {
-					return nil, errors.Wrap(err, "failed to unmarshal varint scalar")
-				}
-				result[messageField.GetName()] = res
+		fieldProjection, selected := projection.field(fieldNum)
+		if !selected {
+			if err := skipFieldValue(buffer, key); err != nil {
+				return nil, errors.Wrapf(err, "failed to skip field %s", fp.name)
 			}
-		case WireType64Bit:
-			res, err := unmarshaScalar(buffer, messageField.GetType().(*Scalar))
-			if err != nil {
-				return nil, errors.Wrap(err, "failed to unmarshal varint scalar")
+			continue
+		}
+		switch fld := fp.field.(type) {
+		case *MapField:
+			if key&7 != WireTypeLengthDelimited {
+				return nil, errors.Errorf("can't assign wire type %d to map field %s", key&7, fld.Name)
 			}
-			result[messageField.GetName()] = res
-		case WireTypeLengthDelimited:
 			data, err := buffer.DecodeRawBytes(false)
 			if err != nil {
 				return nil, errors.Wrap(err, "failed to decode raw bytes")
 			}
-
-			switch typ := messageField.GetType().(type) {
-			case *Map:
-				if _, ok := result[messageField.GetName()]; !ok {
-					result[messageField.GetName()] = make(map[string]interface{})
+			mapKey, mapValue, err := o.unmarshalMapEntry(data, fp, fieldProjection, depth+1)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to unmarshal %s map entry", fld.Name)
+			}
+			if enum := fp.mapValue.enum; enum != nil {
+				if mapValue, ok = o.enumValue(mapValue.(int32), enum); !ok {
+					o.keepUnknownField(result, key, proto.EncodeVarint(uint64(len(data))), data)
+					continue
 				}
-				resultMap := result[messageField.GetName()].(map[string]interface{})
-
-				mapBuffer := proto.NewBuffer(data)
-				mapKeyKey, err := mapBuffer.DecodeVarint()
-				if err != nil {
-					return nil, errors.Wrap(err, "failed to decode map key key")
+			}
+			if o.TypedMapKeys {
+				resultMap, ok := result[fld.Name].(map[interface{}]interface{})
+				if !ok {
+					resultMap = make(map[interface{}]interface{})
+					result[fld.Name] = resultMap
 				}
-				mapKeyWireType := mapKeyKey & 7
-
-				var mapKey string
-				var mapValue interface{}
-				switch mapKeyWireType {
-				case WireTypeVarint, WireType64Bit, WireType32Bit:
-					res, err := unmarshaScalar(mapBuffer, typ.KeyType.(*Scalar))
-					if err != nil {
-						return nil, errors.Wrap(err, "failed to unmarshal varint scalar")
-					}
-					mapKey = fmt.Sprint(res)
-				case WireTypeLengthDelimited:
-					str, err := mapBuffer.DecodeStringBytes()
-					if err != nil {
-						return nil, errors.Wrap(err, "failed to decode string bytes")
-					}
-					mapKey = str
+				resultMap[mapKey] = mapValue
+				if err := o.checkElements(len(resultMap), fld.Name); err != nil {
+					return nil, err
 				}
-
-				mapValueKey, err := mapBuffer.DecodeVarint()
-				if err != nil {
-					return nil, errors.Wrap(err, "failed to decode map value key")
+			} else {
+				resultMap, ok := result[fld.Name].(map[string]interface{})
+				if !ok {
+					resultMap = make(map[string]interface{})
+					result[fld.Name] = resultMap
 				}
-				mapValueWireType := mapValueKey & 7
-				switch mapValueWireType {
-				case WireTypeVarint, WireType32Bit, WireType64Bit:
-					res, err := unmarshaScalar(mapBuffer, typ.KeyType.(*Scalar))
-					if err != nil {
-						return nil, errors.Wrap(err, "failed to unmarshal varint scalar")
-					}
-					mapValue = res
-				case WireTypeLengthDelimited:
-					valueBytes, err := mapBuffer.DecodeRawBytes(false)
-					if err != nil {
-						return nil, errors.Wrap(err, "failed to decode map value raw bytes")
-					}
-					switch valueType := typ.ValueType.(type) {
-					case *Message:
-						msgValue, err := unmarshalMessageBytesToMap(proto.NewBuffer(valueBytes), valueType)
-						if err != nil {
-							return nil, errors.WithStack(err)
-						}
-						mapValue = msgValue
-					}
+				resultMap[fmt.Sprint(mapKey)] = mapValue
+				if err := o.checkElements(len(resultMap), fld.Name); err != nil {
+					return nil, err
 				}
-				resultMap[mapKey] = mapValue
-			case *Message:
-				msgValue, err := UnmarshalMessage(data, typ)
+			}
+		case *NormalField:
+			if fld.Repeated && key&7 == WireTypeLengthDelimited && fp.packable {
+				data, err := buffer.DecodeRawBytes(false)
 				if err != nil {
-					return nil, errors.WithStack(err)
+					return nil, errors.Wrap(err, "failed to decode raw bytes")
 				}
-				if messageField.IsRepeated() {
-					if _, ok := result[messageField.GetName()]; !ok {
-						result[messageField.GetName()] = []interface{}{}
-					}
-					result[messageField.GetName()] = append(result[messageField.GetName()].([]interface{}), msgValue)
-				} else {
-					result[messageField.GetName()] = msgValue
+				values, err := o.unmarshalPacked(data, fp)
+				if err != nil {
+					return nil, errors.Wrapf(err, "failed to unmarshal packed field %s", fld.Name)
 				}
-			case *Scalar:
-				switch typ.ScalarName {
-				case "string":
-					if messageField.IsRepeated() {
-						if _, ok := result[messageField.GetName()]; !ok {
-							result[messageField.GetName()] = []interface{}{}
-						}
-						result[messageField.GetName()] = append(result[messageField.GetName()].([]interface{}), string(data))
-					} else {
-						result[messageField.GetName()] = string(data)
-					}
-				case "bytes":
-					val := base64.StdEncoding.EncodeToString(data)
-					if messageField.IsRepeated() {
-						if _, ok := result[messageField.GetName()]; !ok {
-							result[messageField.GetName()] = []interface{}{}
+				for _, value := range values {
+					if enum := fp.enum; enum != nil {
+						if value, ok = o.enumValue(value.(int32), enum); !ok {
+							unknownKey := messageKeyVarint(fld.KeyNumber, WireTypeVarint)
+							o.keepUnknownField(result, unknownKey, proto.EncodeVarint(uint64(value.(int32))))
+							continue
 						}
-						result[messageField.GetName()] = append(result[messageField.GetName()].([]interface{}), string(val))
-					} else {
-						result[messageField.GetName()] = string(val)
-					}
-				default:
-					if messageField.IsRepeated() {
-						buff := proto.NewBuffer(data)
-						var values []interface{}
-						for {
-							elem, err := unmarshaScalar(buff, typ)
-							if err != nil {
-								if err == io.ErrUnexpectedEOF {
-									break
-								}
-								return nil, errors.Wrap(err, "failed to unmarshal scalar")
-							}
-							values = append(values, elem)
-						}
-						result[messageField.GetName()] = values
-					} else {
-						result[messageField.GetName()] = string(data)
 					}
+					o.appendRepeatedValue(result, fld, value)
 				}
-
-			case *Enum:
-				buff := proto.NewBuffer(data)
-				var values []int32
-				for {
-					elem, err := buff.DecodeVarint()
-					if err != nil {
-						if err == io.ErrUnexpectedEOF {
-							break
-						}
-						return nil, errors.Wrap(err, "failed to get varint")
-					}
-					values = append(values, int32(elem))
+				if err := o.checkElements(repeatedLen(result[fld.Name]), fld.Name); err != nil {
+					return nil, err
 				}
-				result[messageField.GetName()] = values
-			default:
-				fmt.Printf("unknown length delimited value%T\n", messageField.GetType())
+				continue
 			}
-		case WireTypeStartGroup:
-			fmt.Println("Unhandled group start")
-		case WireTypeEndGroup:
-			fmt.Println("Unhandled group end")
-		case WireType32Bit:
-			res, err := unmarshaScalar(buffer, messageField.GetType().(*Scalar))
+			value, err := o.unmarshalField(buffer, key&7, fp, fieldProjection, depth+1)
 			if err != nil {
-				return nil, errors.Wrap(err, "failed to unmarshal fixed 32 scalar")
-
+				return nil, errors.Wrapf(err, "failed to unmarshal field %s", fld.Name)
+			}
+			if enum := fp.enum; enum != nil {
+				if value, ok = o.enumValue(value.(int32), enum); !ok {
+					o.keepUnknownField(result, key, proto.EncodeVarint(uint64(value.(int32))))
+					continue
+				}
+			}
+			if fld.Repeated {
+				o.appendRepeatedValue(result, fld, value)
+				if err := o.checkElements(repeatedLen(result[fld.Name]), fld.Name); err != nil {
+					return nil, err
+				}
+			} else {
+				result[fld.Name] = value
+			}
+			if fld.OneOf != nil {
+				o.setOneOfCase(result, fld)
 			}
-			result[messageField.GetName()] = res
 		}
 	}
+
 	return result, nil
 }
 
-func unmarshaScalar(buffer *proto.Buffer, scalar *Scalar) (interface{}, error) {
-	switch scalar.ScalarName {
-	case "sfixed32":
-		value, err := buffer.DecodeFixed32()
-		if err != nil {
-			if err == io.ErrUnexpectedEOF {
-				return nil, err
-			}
-			return nil, errors.Wrap(err, "failed to decode zigzag32")
+// setOneOfCase removes other members of field oneof, as the last one on the wire wins.
+func (o UnmarshalOptions) setOneOfCase(result map[string]interface{}, fld *NormalField) {
+	for _, member := range fld.OneOf.Fields {
+		if member != fld {
+			delete(result, member.Name)
 		}
-		return int32(value), nil
-	case "sfixed64":
-		value, err := buffer.DecodeFixed64()
+	}
+	if !o.ReportOneOfCases {
+		return
+	}
+	cases, ok := result[OneOfCasesKey].(map[string]string)
+	if !ok {
+		cases = make(map[string]string)
+		result[OneOfCasesKey] = cases
+	}
+	cases[fld.OneOf.Name] = fld.Name
+}
+
+// enumValue converts decoded enum number according to options. It reports false for numbers, unknown
+// to closed enum, as such values should be handled like unknown fields.
+func (o UnmarshalOptions) enumValue(number int32, enum *Enum) (interface{}, bool) {
+	enumValue, ok := enum.ValueByNumber(int(number))
+	if !ok {
+		return number, !enum.IsClosed()
+	}
+	if o.EnumsAsNames {
+		return enumValue.Name, true
+	}
+
+	return number, true
+}
+
+// keepUnknownField appends field with given key and raw encoded value to unknown fields, if they are kept.
+func (o UnmarshalOptions) keepUnknownField(result map[string]interface{}, key uint64, value ...[]byte) {
+	if !o.KeepUnknownFields {
+		return
+	}
+	unknown, _ := result[UnknownFieldsKey].([]byte)
+	unknown = append(unknown, proto.EncodeVarint(key)...)
+	for _, part := range value {
+		unknown = append(unknown, part...)
+	}
+	result[UnknownFieldsKey] = unknown
+}
+
+// appendRepeatedValue appends value to repeated field. Enum numbers are collected to []int32,
+// others, including enum names, to []interface{}.
+func (o UnmarshalOptions) appendRepeatedValue(result map[string]interface{}, fld *NormalField, value interface{}) {
+	if _, ok := fld.Type.(*Enum); ok && !o.EnumsAsNames {
+		values, _ := result[fld.Name].([]int32)
+		result[fld.Name] = append(values, value.(int32))
+		return
+	}
+	values, _ := result[fld.Name].([]interface{})
+	result[fld.Name] = append(values, value)
+}
+
+// checkElements returns *ElementsLimitError, if field has more elements, than MaxElements.
+func (o UnmarshalOptions) checkElements(count int, field string) error {
+	if o.MaxElements > 0 && count > o.MaxElements {
+		return &ElementsLimitError{Field: field, Limit: o.MaxElements}
+	}
+	return nil
+}
+
+// repeatedLen returns number of decoded elements of repeated field.
+func repeatedLen(values interface{}) int {
+	switch values := values.(type) {
+	case []int32:
+		return len(values)
+	case []interface{}:
+		return len(values)
+	}
+	return 0
+}
+
+// unmarshalPacked decodes all values of packed repeated field.
+func (o UnmarshalOptions) unmarshalPacked(data []byte, fp *fieldPlan) ([]interface{}, error) {
+	buffer := proto.NewBuffer(data)
+	var values []interface{}
+	for len(buffer.Unread()) > 0 {
+		value, err := o.unmarshalField(buffer, fp.wireType, fp, nil, 0)
 		if err != nil {
-			if err == io.ErrUnexpectedEOF {
-				return nil, err
-			}
-			return nil, errors.Wrap(err, "failed to decode zigzag64")
+			return nil, err
+		}
+		values = append(values, value)
+		if err := o.checkElements(len(values), fp.name); err != nil {
+			return nil, err
 		}
-		return int64(value), nil
-	case "sint32":
-		value, err := buffer.DecodeZigzag32()
+	}
+
+	return values, nil
+}
+
+// unmarshalField decodes single field value, checking it was encoded with expected wire type.
+// Scalars are decoded by their decoders directly. Projection and depth are applied to message values.
+func (o UnmarshalOptions) unmarshalField(buffer *proto.Buffer, wireType uint64, fp *fieldPlan, projection *Projection, depth int) (interface{}, error) {
+	if fp.scalar == ScalarUndefined {
+		return o.unmarshalFieldValue(buffer, wireType, fp.normal.Type, projection, depth)
+	}
+	if wireType != fp.wireType {
+		return nil, errors.Errorf("can't assign wire type %d to %s, expected %d", wireType, fp.normal.Type, fp.wireType)
+	}
+
+	return o.decodeScalar(buffer, fp.scalar)
+}
+
+// decodeScalar decodes single value of scalar kind. Bytes are aliased, if AliasBytes is set.
+func (o UnmarshalOptions) decodeScalar(buffer *proto.Buffer, kind ScalarKind) (interface{}, error) {
+	if o.MaxLength > 0 && (kind == ScalarString || kind == ScalarBytes) {
+		if err := o.checkLength(buffer); err != nil {
+			return nil, err
+		}
+	}
+	if kind == ScalarBytes && o.AliasBytes {
+		return buffer.DecodeRawBytes(false)
+	}
+	return scalarDecoders[kind](buffer)
+}
+
+// unmarshalFieldValue decodes single value of given type, checking it was encoded with expected wire type.
+func (o UnmarshalOptions) unmarshalFieldValue(buffer *proto.Buffer, wireType uint64, typ Type, projection *Projection, depth int) (interface{}, error) {
+	if expected := typeWireType(typ); wireType != expected {
+		return nil, errors.Errorf("can't assign wire type %d to %s, expected %d", wireType, typ, expected)
+	}
+	switch typ := typ.(type) {
+	case *Scalar:
+		return o.decodeScalar(buffer, typ.scalarKind())
+	case *Enum:
+		value, err := buffer.DecodeVarint()
 		if err != nil {
-			if err == io.ErrUnexpectedEOF {
-				return nil, err
-			}
-			return nil, errors.Wrap(err, "failed to decode zigzag32")
+			return nil, err
 		}
 		return int32(value), nil
-	case "sint64":
-		value, err := buffer.DecodeZigzag64()
+	case *Message:
+		data, err := buffer.DecodeRawBytes(false)
 		if err != nil {
-			if err == io.ErrUnexpectedEOF {
-				return nil, err
-			}
-			return nil, errors.Wrap(err, "failed to decode zigzag64")
+			return nil, errors.Wrap(err, "failed to decode raw bytes")
 		}
-		return int64(value), nil
-	case "fixed32":
-		value, err := buffer.DecodeFixed32()
+		if o.LazyMessages && isPlainMessage(typ) {
+			return &LazyMessage{msg: typ, raw: data, options: o, projection: projection, depth: depth}, nil
+		}
+		value, err := o.unmarshalMessageBytesToMap(proto.NewBuffer(data), typ, projection, depth)
 		if err != nil {
-			if err == io.ErrUnexpectedEOF {
-				return nil, err
-			}
-			return nil, errors.Wrap(err, "failed to decode fixed 32")
+			return nil, err
 		}
-		return uint32(value), nil
-	case "float":
-		value, err := buffer.DecodeFixed32()
+		return o.messageValue(value, typ, depth)
+	}
+	return nil, errors.Errorf("unexpected field type %s", typ)
+}
+
+// unmarshalMapEntry decodes map entry message. Key and value may come in any order, missing ones
+// are set to default values. Projection and depth are applied to message values.
+func (o UnmarshalOptions) unmarshalMapEntry(data []byte, fp *fieldPlan, projection *Projection, depth int) (key interface{}, value interface{}, err error) {
+	buffer := proto.NewBuffer(data)
+	for len(buffer.Unread()) > 0 {
+		fieldKey, err := buffer.DecodeVarint()
 		if err != nil {
-			if err == io.ErrUnexpectedEOF {
-				return nil, err
+			return nil, nil, errors.Wrap(err, "failed to get key")
+		}
+		switch fieldKey >> 3 {
+		case 1:
+			key, err = o.unmarshalField(buffer, fieldKey&7, fp.mapKey, nil, depth)
+			if err != nil {
+				return nil, nil, errors.Wrap(err, "failed to unmarshal map key")
+			}
+		case 2:
+			value, err = o.unmarshalField(buffer, fieldKey&7, fp.mapValue, projection, depth)
+			if err != nil {
+				return nil, nil, errors.Wrap(err, "failed to unmarshal map value")
+			}
+		default:
+			if _, err := o.skipField(buffer, fieldKey, depth); err != nil {
+				return nil, nil, errors.Wrapf(err, "failed to skip map entry field %d", fieldKey>>3)
 			}
-			return nil, errors.Wrap(err, "failed to decode fixed 32")
 		}
-		return math.Float32frombits(uint32(value)), nil
-	case "double":
-		value, err := buffer.DecodeFixed64()
+	}
+	if key == nil {
+		key = o.defaultValue(fp.mapField.Map.KeyType)
+	}
+	if value == nil {
+		value = o.defaultValue(fp.mapField.Map.ValueType)
+	}
+
+	return key, value, nil
+}
+
+// defaultValue returns value of type, missing on the wire. Bytes are empty []byte, if AliasBytes is set.
+func (o UnmarshalOptions) defaultValue(typ Type) interface{} {
+	if scalar, ok := typ.(*Scalar); ok && scalar.scalarKind() == ScalarBytes && o.AliasBytes {
+		return []byte{}
+	}
+	return mapEntryDefaultValue(typ)
+}
+
+func mapEntryDefaultValue(typ Type) interface{} {
+	switch typ := typ.(type) {
+	case *Scalar:
+		if typ.scalarKind() == ScalarBytes {
+			return ""
+		}
+		return typ.scalarKind().Zero()
+	case *Enum:
+		if len(typ.Values) == 0 {
+			return int32(0)
+		}
+		return int32(typ.Values[0].Value)
+	}
+	if wkt, msg, ok := wellKnownTypeOf(typ); ok {
+		if value, err := wkt.fromMessage(map[string]interface{}{}, msg); err == nil {
+			return value
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// skipFieldValue skips field value with given key in buffer without copying it.
+func skipFieldValue(buffer *proto.Buffer, key uint64) error {
+	unread := buffer.Unread()
+	n := protowire.ConsumeFieldValue(protowire.Number(key>>3), protowire.Type(key&7), unread)
+	if n < 0 {
+		return protowire.ParseError(n)
+	}
+	buffer.SetBuf(unread[n:])
+
+	return nil
+}
+
+// checkLength returns *LengthLimitError, if length prefix of the next value in buffer exceeds MaxLength.
+// Malformed prefixes are left to be reported by decoders.
+func (o UnmarshalOptions) checkLength(buffer *proto.Buffer) error {
+	length, n := protowire.ConsumeVarint(buffer.Unread())
+	if n > 0 && length > uint64(o.MaxLength) {
+		return &LengthLimitError{Length: length, Limit: o.MaxLength}
+	}
+	return nil
+}
+
+// skipField reads field value with given key from buffer and returns raw field bytes, including key.
+// depth is nesting level of group, checked against MaxDepth.
+func (o UnmarshalOptions) skipField(buffer *proto.Buffer, key uint64, depth int) ([]byte, error) {
+	raw := proto.NewBuffer(nil)
+	if err := raw.EncodeVarint(key); err != nil {
+		return nil, errors.Wrap(err, "failed to encode key")
+	}
+	switch key & 7 {
+	case WireTypeVarint:
+		value, err := buffer.DecodeVarint()
 		if err != nil {
-			if err == io.ErrUnexpectedEOF {
-				return nil, err
-			}
-			return nil, errors.Wrap(err, "failed to decode fixed 64")
+			return nil, errors.Wrap(err, "failed to decode varint")
+		}
+		if err := raw.EncodeVarint(value); err != nil {
+			return nil, errors.Wrap(err, "failed to encode varint")
 		}
-		return math.Float64frombits(value), nil
-	case "fixed64":
+	case WireType64Bit:
 		value, err := buffer.DecodeFixed64()
 		if err != nil {
-			if err == io.ErrUnexpectedEOF {
-				return nil, err
-			}
 			return nil, errors.Wrap(err, "failed to decode fixed 64")
 		}
-		return uint64(value), nil
-	case "int32", "int64", "uint32", "uint64", "bool":
-		value, err := buffer.DecodeVarint()
+		if err := raw.EncodeFixed64(value); err != nil {
+			return nil, errors.Wrap(err, "failed to encode fixed 64")
+		}
+	case WireType32Bit:
+		value, err := buffer.DecodeFixed32()
 		if err != nil {
-			if err == io.ErrUnexpectedEOF {
-				return nil, err
-			}
-			return nil, errors.Wrap(err, "failed to decode varint")
+			return nil, errors.Wrap(err, "failed to decode fixed 32")
 		}
-		switch scalar.ScalarName {
-		case "int32":
-			return int32(value), nil
-		case "int64":
-			return int64(value), nil
-		case "uint32":
-			return uint32(value), nil
-		case "uint64":
-			return uint64(value), nil
-		case "bool":
-			return value == 1, nil
-		case "double":
-			return math.Float64frombits(value), nil
-		case "float":
-			return math.Float32frombits(uint32(value)), nil
+		if err := raw.EncodeFixed32(value); err != nil {
+			return nil, errors.Wrap(err, "failed to encode fixed 32")
 		}
-		return int32(value), nil
+	case WireTypeLengthDelimited:
+		value, err := buffer.DecodeRawBytes(false)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode raw bytes")
+		}
+		if err := raw.EncodeRawBytes(value); err != nil {
+			return nil, errors.Wrap(err, "failed to encode raw bytes")
+		}
+	case WireTypeStartGroup:
+		if o.MaxDepth > 0 && depth > o.MaxDepth {
+			return nil, &DepthLimitError{Limit: o.MaxDepth}
+		}
+		for {
+			groupKey, err := buffer.DecodeVarint()
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to decode group field key")
+			}
+			if groupKey&7 == WireTypeEndGroup {
+				if groupKey>>3 != key>>3 {
+					return nil, errors.Errorf("group %d ended with end group %d", key>>3, groupKey>>3)
+				}
+				if err := raw.EncodeVarint(groupKey); err != nil {
+					return nil, errors.Wrap(err, "failed to encode end group key")
+				}
+				break
+			}
+			groupField, err := o.skipField(buffer, groupKey, depth+1)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to skip group field %d", groupKey>>3)
+			}
+			raw.SetBuf(append(raw.Bytes(), groupField...))
+		}
+	default:
+		return nil, errors.Errorf("unexpected wire type %d", key&7)
+	}
+
+	return raw.Bytes(), nil
+}
+
+// scalarDecoders decode single value of each scalar kind to its Go type. Bytes are decoded to base64 string.
+var scalarDecoders = [...]func(buffer *proto.Buffer) (interface{}, error){
+	ScalarDouble:   decodeDouble,
+	ScalarFloat:    decodeFloat,
+	ScalarInt32:    decodeInt32,
+	ScalarInt64:    decodeInt64,
+	ScalarUint32:   decodeUint32,
+	ScalarUint64:   decodeUint64,
+	ScalarSint32:   decodeSint32,
+	ScalarSint64:   decodeSint64,
+	ScalarFixed32:  decodeFixed32,
+	ScalarFixed64:  decodeFixed64,
+	ScalarSfixed32: decodeSfixed32,
+	ScalarSfixed64: decodeSfixed64,
+	ScalarBool:     decodeBool,
+	ScalarString:   decodeString,
+	ScalarBytes:    decodeBytes,
+}
+
+func decodeDouble(buffer *proto.Buffer) (interface{}, error) {
+	value, err := buffer.DecodeFixed64()
+	if err != nil {
+		return nil, err
+	}
+	return math.Float64frombits(value), nil
+}
+
+func decodeFloat(buffer *proto.Buffer) (interface{}, error) {
+	value, err := buffer.DecodeFixed32()
+	if err != nil {
+		return nil, err
+	}
+	return math.Float32frombits(uint32(value)), nil
+}
+
+func decodeInt32(buffer *proto.Buffer) (interface{}, error) {
+	value, err := buffer.DecodeVarint()
+	if err != nil {
+		return nil, err
+	}
+	return int32(value), nil
+}
+
+func decodeInt64(buffer *proto.Buffer) (interface{}, error) {
+	value, err := buffer.DecodeVarint()
+	if err != nil {
+		return nil, err
+	}
+	return int64(value), nil
+}
+
+func decodeUint32(buffer *proto.Buffer) (interface{}, error) {
+	value, err := buffer.DecodeVarint()
+	if err != nil {
+		return nil, err
+	}
+	return uint32(value), nil
+}
+
+func decodeUint64(buffer *proto.Buffer) (interface{}, error) {
+	return buffer.DecodeVarint()
+}
+
+func decodeSint32(buffer *proto.Buffer) (interface{}, error) {
+	value, err := buffer.DecodeZigzag32()
+	if err != nil {
+		return nil, err
+	}
+	return int32(value), nil
+}
+
+func decodeSint64(buffer *proto.Buffer) (interface{}, error) {
+	value, err := buffer.DecodeZigzag64()
+	if err != nil {
+		return nil, err
+	}
+	return int64(value), nil
+}
+
+func decodeFixed32(buffer *proto.Buffer) (interface{}, error) {
+	value, err := buffer.DecodeFixed32()
+	if err != nil {
+		return nil, err
+	}
+	return uint32(value), nil
+}
+
+func decodeFixed64(buffer *proto.Buffer) (interface{}, error) {
+	return buffer.DecodeFixed64()
+}
+
+func decodeSfixed32(buffer *proto.Buffer) (interface{}, error) {
+	value, err := buffer.DecodeFixed32()
+	if err != nil {
+		return nil, err
+	}
+	return int32(value), nil
+}
+
+func decodeSfixed64(buffer *proto.Buffer) (interface{}, error) {
+	value, err := buffer.DecodeFixed64()
+	if err != nil {
+		return nil, err
+	}
+	return int64(value), nil
+}
+
+func decodeBool(buffer *proto.Buffer) (interface{}, error) {
+	value, err := buffer.DecodeVarint()
+	if err != nil {
+		return nil, err
+	}
+	return value != 0, nil
+}
+
+func decodeString(buffer *proto.Buffer) (interface{}, error) {
+	return buffer.DecodeStringBytes()
+}
+
+func decodeBytes(buffer *proto.Buffer) (interface{}, error) {
+	data, err := buffer.DecodeRawBytes(false)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode raw bytes")
 	}
-	return nil, errors.Errorf("unknown scalar type: %s", scalar.ScalarName)
+	return base64.StdEncoding.EncodeToString(data), nil
 }
@@ -2,9 +2,11 @@ package shprotos
 
 import (
 	"encoding/base64"
+	"math"
 	"testing"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
 	"github.com/saturn4er/shprotos/testdata"
 	"github.com/stretchr/testify/require"
 )
@@ -55,8 +57,13 @@ func TestUnmarshalMessage(t *testing.T) {
 				SomeField2: "world",
 			},
 		},
-		MapBytes:  nil,
-		MapString: nil,
+		MapBytes: map[string][]byte{
+			"hello": []byte("world"),
+			"empty": nil,
+		},
+		MapString: map[string]string{
+			"hello": "world",
+		},
 		REnum: []full.ComplexMessage_SimpleEnum{
 			full.ComplexMessage_VALUE_B7,
 			full.ComplexMessage_VALUE_B8,
@@ -87,7 +94,7 @@ func TestUnmarshalMessage(t *testing.T) {
 	res, err := UnmarshalMessage(data, msg)
 	require.NoError(t, err)
 
-	require.Equal(t, uint64(protoMessage.Enum), res["enum"])
+	require.Equal(t, int32(protoMessage.Enum), res["enum"])
 
 	require.Equal(t, protoMessage.ScalarInt32, res["scalar_int32"])
 	require.Equal(t, protoMessage.ScalarInt64, res["scalar_int64"])
@@ -129,6 +136,13 @@ func TestUnmarshalMessage(t *testing.T) {
 			"some_field2": "world",
 		},
 	}, res["map_msg"])
+	require.Equal(t, map[string]interface{}{
+		"hello": base64.StdEncoding.EncodeToString([]byte("world")),
+		"empty": "",
+	}, res["map_bytes"])
+	require.Equal(t, map[string]interface{}{
+		"hello": "world",
+	}, res["map_string"])
 
 	require.Equal(t, []int32{
 		int32(full.ComplexMessage_VALUE_B7),
@@ -152,3 +166,489 @@ func TestUnmarshalMessage(t *testing.T) {
 		"d29ybGQ=",
 	}, res["r_bytes"])
 }
+
+func TestUnmarshalMessageUnknownFields(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/full.proto", nil, nil)
+	require.NoError(t, err)
+	msg, ok := parsedFile.Message(TypeName{"ComplexMessage", "SimpleMessage"})
+	require.True(t, ok)
+
+	data, err := proto.Marshal(&full.ComplexMessage_SimpleMessage{SomeField: 10, SomeField2: "hello"})
+	require.NoError(t, err)
+
+	unknown := proto.NewBuffer(nil)
+	require.NoError(t, unknown.EncodeVarint(messageKeyVarint(100, WireTypeVarint)))
+	require.NoError(t, unknown.EncodeVarint(150))
+	require.NoError(t, unknown.EncodeVarint(messageKeyVarint(101, WireTypeLengthDelimited)))
+	require.NoError(t, unknown.EncodeStringBytes("newer field"))
+	require.NoError(t, unknown.EncodeVarint(messageKeyVarint(102, WireType32Bit)))
+	require.NoError(t, unknown.EncodeFixed32(7))
+	require.NoError(t, unknown.EncodeVarint(messageKeyVarint(103, WireType64Bit)))
+	require.NoError(t, unknown.EncodeFixed64(8))
+	require.NoError(t, unknown.EncodeVarint(messageKeyVarint(104, WireTypeStartGroup)))
+	require.NoError(t, unknown.EncodeVarint(messageKeyVarint(1, WireTypeVarint)))
+	require.NoError(t, unknown.EncodeVarint(1))
+	require.NoError(t, unknown.EncodeVarint(messageKeyVarint(104, WireTypeEndGroup)))
+	data = append(data, unknown.Bytes()...)
+
+	res, err := UnmarshalMessage(data, msg)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"some_field":  int32(10),
+		"some_field2": "hello",
+	}, res)
+
+	res, err = UnmarshalOptions{KeepUnknownFields: true}.Unmarshal(data, msg)
+	require.NoError(t, err)
+	require.Equal(t, unknown.Bytes(), res[UnknownFieldsKey])
+
+	marshalled, err := MarshalMessage(res, msg)
+	require.NoError(t, err)
+	require.Equal(t, data, marshalled)
+}
+
+func TestUnmarshalMessageMaps(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/maps.proto", nil, nil)
+	require.NoError(t, err)
+	msg, ok := parsedFile.Message(TypeName{"Maps"})
+	require.True(t, ok)
+
+	entry := func(fieldNum uint64, encode func(b *proto.Buffer)) []byte {
+		entryBuffer := proto.NewBuffer(nil)
+		encode(entryBuffer)
+		buffer := proto.NewBuffer(nil)
+		require.NoError(t, buffer.EncodeVarint(messageKeyVarint(fieldNum, WireTypeLengthDelimited)))
+		require.NoError(t, buffer.EncodeRawBytes(entryBuffer.Bytes()))
+		return buffer.Bytes()
+	}
+	var data []byte
+	// value goes before key
+	data = append(data, entry(1, func(b *proto.Buffer) {
+		require.NoError(t, b.EncodeVarint(messageKeyVarint(2, WireTypeLengthDelimited)))
+		require.NoError(t, b.EncodeStringBytes("yes"))
+		require.NoError(t, b.EncodeVarint(messageKeyVarint(1, WireTypeVarint)))
+		require.NoError(t, b.EncodeVarint(1))
+	})...)
+	// missing value
+	data = append(data, entry(1, func(b *proto.Buffer) {
+		require.NoError(t, b.EncodeVarint(messageKeyVarint(1, WireTypeVarint)))
+		require.NoError(t, b.EncodeVarint(0))
+	})...)
+	data = append(data, entry(2, func(b *proto.Buffer) {
+		require.NoError(t, b.EncodeVarint(messageKeyVarint(1, WireTypeVarint)))
+		require.NoError(t, b.EncodeZigzag64(uint64(-5&0xFFFFFFFFFFFFFFFF)))
+		require.NoError(t, b.EncodeVarint(messageKeyVarint(2, WireTypeVarint)))
+		require.NoError(t, b.EncodeVarint(1))
+	})...)
+	data = append(data, entry(3, func(b *proto.Buffer) {
+		require.NoError(t, b.EncodeVarint(messageKeyVarint(1, WireTypeVarint)))
+		require.NoError(t, b.EncodeVarint(7))
+		require.NoError(t, b.EncodeVarint(messageKeyVarint(2, WireTypeLengthDelimited)))
+		require.NoError(t, b.EncodeRawBytes([]byte("raw")))
+	})...)
+	// missing key and unknown entry field
+	data = append(data, entry(4, func(b *proto.Buffer) {
+		require.NoError(t, b.EncodeVarint(messageKeyVarint(3, WireTypeVarint)))
+		require.NoError(t, b.EncodeVarint(100))
+		require.NoError(t, b.EncodeVarint(messageKeyVarint(2, WireTypeLengthDelimited)))
+		require.NoError(t, b.EncodeStringBytes("\n\x03abc"))
+	})...)
+	data = append(data, entry(5, func(b *proto.Buffer) {
+		require.NoError(t, b.EncodeVarint(messageKeyVarint(1, WireType64Bit)))
+		require.NoError(t, b.EncodeFixed64(uint64(-3&0xFFFFFFFFFFFFFFFF)))
+		require.NoError(t, b.EncodeVarint(messageKeyVarint(2, WireType64Bit)))
+		require.NoError(t, b.EncodeFixed64(math.Float64bits(1.5)))
+	})...)
+
+	res, err := UnmarshalMessage(data, msg)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"bool_string":     map[string]interface{}{"true": "yes", "false": ""},
+		"sint64_enum":     map[string]interface{}{"-5": int32(1)},
+		"uint64_bytes":    map[string]interface{}{"7": base64.StdEncoding.EncodeToString([]byte("raw"))},
+		"fixed32_msg":     map[string]interface{}{"0": map[string]interface{}{"name": "abc"}},
+		"sfixed64_double": map[string]interface{}{"-3": float64(1.5)},
+	}, res)
+
+	res, err = UnmarshalOptions{TypedMapKeys: true}.Unmarshal(data, msg)
+	require.NoError(t, err)
+	require.Equal(t, map[interface{}]interface{}{true: "yes", false: ""}, res["bool_string"])
+	require.Equal(t, map[interface{}]interface{}{int64(-5): int32(1)}, res["sint64_enum"])
+	require.Equal(t, map[interface{}]interface{}{uint32(0): map[string]interface{}{"name": "abc"}}, res["fixed32_msg"])
+
+	marshalled, err := MarshalMessage(res, msg)
+	require.NoError(t, err)
+	remarshalled, err := UnmarshalOptions{TypedMapKeys: true}.Unmarshal(marshalled, msg)
+	require.NoError(t, err)
+	require.Equal(t, res, remarshalled)
+}
+
+func TestUnmarshalMessageRepeated(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/packed2.proto", nil, nil)
+	require.NoError(t, err)
+	msg, ok := parsedFile.Message(TypeName{"Proto2Repeated"})
+	require.True(t, ok)
+
+	buffer := proto.NewBuffer(nil)
+	// unpacked and packed chunks of the same field are merged
+	require.NoError(t, buffer.EncodeVarint(messageKeyVarint(1, WireTypeVarint)))
+	require.NoError(t, buffer.EncodeVarint(1))
+	require.NoError(t, buffer.EncodeVarint(messageKeyVarint(1, WireTypeLengthDelimited)))
+	require.NoError(t, buffer.EncodeRawBytes([]byte{2, 3}))
+	require.NoError(t, buffer.EncodeVarint(messageKeyVarint(1, WireTypeVarint)))
+	require.NoError(t, buffer.EncodeVarint(4))
+	require.NoError(t, buffer.EncodeVarint(messageKeyVarint(2, WireTypeVarint)))
+	require.NoError(t, buffer.EncodeZigzag64(uint64(5)))
+	require.NoError(t, buffer.EncodeVarint(messageKeyVarint(3, WireTypeVarint)))
+	require.NoError(t, buffer.EncodeVarint(1))
+	require.NoError(t, buffer.EncodeVarint(messageKeyVarint(3, WireTypeLengthDelimited)))
+	require.NoError(t, buffer.EncodeRawBytes([]byte{0, 1}))
+
+	res, err := UnmarshalMessage(buffer.Bytes(), msg)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{int32(1), int32(2), int32(3), int32(4)}, res["unpacked"])
+	require.Equal(t, []interface{}{int64(5)}, res["packed"])
+	require.Equal(t, []int32{1, 0, 1}, res["kinds"])
+}
+
+func TestUnmarshalMessageOneOf(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/full.proto", nil, nil)
+	require.NoError(t, err)
+	msg, ok := parsedFile.Message(TypeName{"ComplexMessage"})
+	require.True(t, ok)
+
+	first, err := proto.Marshal(&full.ComplexMessage{Oneof: &full.ComplexMessage_OneofScalar{OneofScalar: 10}})
+	require.NoError(t, err)
+	second, err := proto.Marshal(&full.ComplexMessage{Oneof: &full.ComplexMessage_OneofEnum{OneofEnum: full.ComplexMessage_VALUE_A}})
+	require.NoError(t, err)
+
+	res, err := UnmarshalMessage(append(first, second...), msg)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"oneof_enum": int32(1)}, res)
+
+	res, err = UnmarshalOptions{ReportOneOfCases: true}.Unmarshal(first, msg)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"oneof_scalar": int32(10),
+		OneOfCasesKey:  map[string]string{"oneof": "oneof_scalar"},
+	}, res)
+}
+
+func TestUnmarshalMessageEnums(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/enums.proto", nil, nil)
+	require.NoError(t, err)
+	msg, ok := parsedFile.Message(TypeName{"Enums"})
+	require.True(t, ok)
+
+	buffer := proto.NewBuffer(nil)
+	require.NoError(t, buffer.EncodeVarint(messageKeyVarint(1, WireTypeVarint)))
+	require.NoError(t, buffer.EncodeVarint(7))
+	require.NoError(t, buffer.EncodeVarint(messageKeyVarint(2, WireTypeLengthDelimited)))
+	require.NoError(t, buffer.EncodeRawBytes([]byte{1, 7, 5}))
+	for key, value := range map[string]uint64{"x": 7, "y": 5} {
+		entry := proto.NewBuffer(nil)
+		require.NoError(t, entry.EncodeVarint(messageKeyVarint(1, WireTypeLengthDelimited)))
+		require.NoError(t, entry.EncodeStringBytes(key))
+		require.NoError(t, entry.EncodeVarint(messageKeyVarint(2, WireTypeVarint)))
+		require.NoError(t, entry.EncodeVarint(value))
+		require.NoError(t, buffer.EncodeVarint(messageKeyVarint(3, WireTypeLengthDelimited)))
+		require.NoError(t, buffer.EncodeRawBytes(entry.Bytes()))
+	}
+
+	// numbers, unknown to closed enum, are skipped
+	res, err := UnmarshalMessage(buffer.Bytes(), msg)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"kinds":   []int32{1, 5},
+		"by_name": map[string]interface{}{"y": int32(5)},
+	}, res)
+
+	res, err = UnmarshalOptions{EnumsAsNames: true}.Unmarshal(buffer.Bytes(), msg)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"kinds":   []interface{}{"B", "C"},
+		"by_name": map[string]interface{}{"y": "C"},
+	}, res)
+
+	// or moved to unknown fields
+	res, err = UnmarshalOptions{KeepUnknownFields: true}.Unmarshal(buffer.Bytes(), msg)
+	require.NoError(t, err)
+	require.NotEmpty(t, res[UnknownFieldsKey])
+	marshalled, err := MarshalMessage(res, msg)
+	require.NoError(t, err)
+	remarshalled, err := UnmarshalOptions{KeepUnknownFields: true}.Unmarshal(marshalled, msg)
+	require.NoError(t, err)
+	require.Equal(t, res, remarshalled)
+
+	// open enums keep unknown numbers
+	fullFile, err := parser.Parse("./testdata/full.proto", nil, nil)
+	require.NoError(t, err)
+	fullMsg, ok := fullFile.Message(TypeName{"ComplexMessage"})
+	require.True(t, ok)
+	data, err := proto.Marshal(&full.ComplexMessage{
+		Enum:  100,
+		REnum: []full.ComplexMessage_SimpleEnum{full.ComplexMessage_VALUE_B8, 100},
+	})
+	require.NoError(t, err)
+	res, err = UnmarshalOptions{EnumsAsNames: true}.Unmarshal(data, fullMsg)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"enum":   int32(100),
+		"r_enum": []interface{}{"VALUE_B8", int32(100)},
+	}, res)
+}
+
+func TestUnmarshalMessageDefaults(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/full.proto", nil, nil)
+	require.NoError(t, err)
+	msg, ok := parsedFile.Message(TypeName{"ComplexMessage"})
+	require.True(t, ok)
+
+	data, err := proto.Marshal(&full.ComplexMessage{
+		ScalarInt32: 1,
+		Message:     &full.ComplexMessage_SimpleMessage{},
+		RMsg:        []*full.ComplexMessage_SimpleMessage{{SomeField2: "a"}},
+	})
+	require.NoError(t, err)
+	defaults := map[string]interface{}{
+		"enum":            int32(0),
+		"scalar_int32":    int32(1),
+		"scalar_int64":    int64(0),
+		"scalar_uint32":   uint32(0),
+		"scalar_uint64":   uint64(0),
+		"scalar_sint32":   int32(0),
+		"scalar_sint64":   int64(0),
+		"scalar_fixed32":  uint32(0),
+		"scalar_fixed64":  uint64(0),
+		"scalar_sfixed32": int32(0),
+		"scalar_sfixed64": int64(0),
+		"scalar_double":   float64(0),
+		"scalar_float":    float32(0),
+		"scalar_bool":     false,
+		"scalar_string":   "",
+		"message":         map[string]interface{}{"some_field": int32(0), "some_field2": ""},
+		"bytes":           "",
+		"map_enum":        map[string]interface{}{},
+		"map_scalar":      map[string]interface{}{},
+		"map_msg":         map[string]interface{}{},
+		"map_bytes":       map[string]interface{}{},
+		"map_string":      map[string]interface{}{},
+		"r_enum":          []int32{},
+		"r_scalar":        []interface{}{},
+		"r_msg":           []interface{}{map[string]interface{}{"some_field": int32(0), "some_field2": "a"}},
+		"r_bytes":         []interface{}{},
+	}
+	res, err := UnmarshalOptions{EmitDefaults: true}.Unmarshal(data, msg)
+	require.NoError(t, err)
+	require.Equal(t, defaults, res)
+
+	// defaults are marshalled back to the same message
+	marshalled, err := MarshalMessage(res, msg)
+	require.NoError(t, err)
+	resultMsg := &full.ComplexMessage{}
+	require.NoError(t, proto.Unmarshal(marshalled, resultMsg))
+	require.Equal(t, int32(1), resultMsg.ScalarInt32)
+	require.Len(t, resultMsg.RMsg, 1)
+
+	res, err = UnmarshalOptions{EmitDefaults: true, NilContainers: true, EnumsAsNames: true, TypedMapKeys: true}.Unmarshal(nil, msg)
+	require.NoError(t, err)
+	require.Equal(t, "UNSPECIFIED", res["enum"])
+	require.Nil(t, res["message"])
+	require.Contains(t, res, "message")
+	require.NotContains(t, res, "oneof_scalar")
+	require.Equal(t, map[interface{}]interface{}(nil), res["map_enum"])
+	require.Equal(t, []interface{}(nil), res["r_enum"])
+	require.Equal(t, []interface{}(nil), res["r_msg"])
+
+	res, err = UnmarshalOptions{EmitDefaults: true, EmptyMessages: true}.Unmarshal(nil, msg)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"some_field": int32(0), "some_field2": ""}, res["message"])
+
+	treeFile, err := parser.Parse("./testdata/tree.proto", nil, nil)
+	require.NoError(t, err)
+	node, ok := treeFile.Message(TypeName{"Node"})
+	require.True(t, ok)
+	res, err = UnmarshalOptions{EmitDefaults: true, EmptyMessages: true}.Unmarshal(nil, node)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"name":          nil,
+		"child":         nil,
+		"children":      []interface{}{},
+		"kind":          nil,
+		"required_kind": nil,
+	}, res)
+}
+
+func TestUnmarshalMessageProjection(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/full.proto", nil, nil)
+	require.NoError(t, err)
+	msg, ok := parsedFile.Message(TypeName{"ComplexMessage"})
+	require.True(t, ok)
+
+	data, err := proto.Marshal(&full.ComplexMessage{
+		ScalarInt32:  1,
+		ScalarString: "skipped",
+		Message:      &full.ComplexMessage_SimpleMessage{SomeField: 2, SomeField2: "skipped"},
+		MapMsg:       map[string]*full.ComplexMessage_SimpleMessage{"a": {SomeField: 3, SomeField2: "b"}},
+		MapScalar:    map[int32]int32{1: 2},
+		RScalar:      []int32{1, 2},
+		RMsg:         []*full.ComplexMessage_SimpleMessage{{SomeField: 4, SomeField2: "c"}, {SomeField: 5}},
+	})
+	require.NoError(t, err)
+
+	res, err := UnmarshalMessageFields(data, msg, "scalar_int32", "message.some_field", "r_msg.*.some_field2",
+		"map_msg.*.some_field", "r_scalar")
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"scalar_int32": int32(1),
+		"message":      map[string]interface{}{"some_field": int32(2)},
+		"map_msg":      map[string]interface{}{"a": map[string]interface{}{"some_field": int32(3)}},
+		"r_scalar":     []interface{}{int32(1), int32(2)},
+		"r_msg":        []interface{}{map[string]interface{}{"some_field2": "c"}, map[string]interface{}{}},
+	}, res)
+
+	// path of whole field wins over paths of its subfields
+	res, err = UnmarshalMessageFields(data, msg, "message.some_field", "message", "r_msg.*")
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"message": map[string]interface{}{"some_field": int32(2), "some_field2": "skipped"},
+		"r_msg": []interface{}{
+			map[string]interface{}{"some_field": int32(4), "some_field2": "c"},
+			map[string]interface{}{"some_field": int32(5)},
+		},
+	}, res)
+
+	projection, err := NewProjection(msg, "message.some_field2", "map_scalar")
+	require.NoError(t, err)
+	res, err = UnmarshalOptions{Projection: projection, EmitDefaults: true, KeepUnknownFields: true}.Unmarshal(data, msg)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"message":    map[string]interface{}{"some_field2": "skipped"},
+		"map_scalar": map[string]interface{}{"1": int32(2)},
+	}, res)
+
+	for _, path := range []string{"unknown", "message.unknown", "scalar_int32.x", "message.*.some_field", "r_msg.some_field",
+		"map_msg.a", "r_scalar.*.x"} {
+		_, err := NewProjection(msg, path)
+		require.Error(t, err, path)
+	}
+	other, ok := parsedFile.Message(TypeName{"ComplexMessage", "SimpleMessage"})
+	require.True(t, ok)
+	_, err = UnmarshalOptions{Projection: projection}.Unmarshal(data, other)
+	require.Error(t, err)
+}
+
+func TestUnmarshalMessageLimits(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/full.proto", nil, nil)
+	require.NoError(t, err)
+	msg, ok := parsedFile.Message(TypeName{"ComplexMessage"})
+	require.True(t, ok)
+
+	data, err := proto.Marshal(&full.ComplexMessage{
+		ScalarString: "hello",
+		Bytes:        []byte("bytes"),
+		MapScalar:    map[int32]int32{1: 1, 2: 2, 3: 3},
+		RScalar:      []int32{1, 2, 3},
+		RMsg:         []*full.ComplexMessage_SimpleMessage{{SomeField2: "a"}, {}, {}},
+	})
+	require.NoError(t, err)
+
+	_, err = UnmarshalOptions{MaxDepth: 2, MaxSize: len(data), MaxElements: 3, MaxLength: 5}.Unmarshal(data, msg)
+	require.NoError(t, err)
+	_, err = UnmarshalOptions{MaxSize: len(data) - 1}.Unmarshal(data, msg)
+	require.Equal(t, &SizeLimitError{Size: len(data), Limit: len(data) - 1}, errors.Cause(err))
+	_, err = UnmarshalOptions{MaxDepth: 1}.Unmarshal(data, msg)
+	require.Equal(t, &DepthLimitError{Limit: 1}, errors.Cause(err))
+	_, err = UnmarshalOptions{MaxLength: 4}.Unmarshal(data, msg)
+	require.Equal(t, &LengthLimitError{Length: 5, Limit: 4}, errors.Cause(err))
+	for _, field := range []string{"map_scalar", "r_scalar", "r_msg"} {
+		fieldData, err := proto.Marshal(&full.ComplexMessage{
+			MapScalar: map[int32]int32{1: 1, 2: 2, 3: 3},
+			RScalar:   []int32{1, 2, 3},
+			RMsg:      []*full.ComplexMessage_SimpleMessage{{}, {}, {}},
+		})
+		require.NoError(t, err)
+		projection, err := NewProjection(msg, field)
+		require.NoError(t, err)
+		_, err = UnmarshalOptions{MaxElements: 2, Projection: projection}.Unmarshal(fieldData, msg)
+		require.Equal(t, &ElementsLimitError{Field: field, Limit: 2}, errors.Cause(err), field)
+	}
+
+	// unpacked elements are counted as well
+	unpacked := append(proto.EncodeVarint(messageKeyVarint(24, WireTypeVarint)), 1)
+	unpacked = append(unpacked, unpacked...)
+	_, err = UnmarshalOptions{MaxElements: 1}.Unmarshal(unpacked, msg)
+	require.Equal(t, &ElementsLimitError{Field: "r_scalar", Limit: 1}, errors.Cause(err))
+
+	// unknown groups are nested as messages
+	group := proto.EncodeVarint(messageKeyVarint(100, WireTypeStartGroup))
+	groupEnd := proto.EncodeVarint(messageKeyVarint(100, WireTypeEndGroup))
+	var nested []byte
+	nested = append(append(append(append(nested, group...), group...), groupEnd...), groupEnd...)
+	_, err = UnmarshalOptions{MaxDepth: 3}.Unmarshal(nested, msg)
+	require.NoError(t, err)
+	_, err = UnmarshalOptions{MaxDepth: 2}.Unmarshal(nested, msg)
+	require.Equal(t, &DepthLimitError{Limit: 2}, errors.Cause(err))
+
+	treeFile, err := parser.Parse("./testdata/tree.proto", nil, nil)
+	require.NoError(t, err)
+	node, ok := treeFile.Message(TypeName{"Node"})
+	require.True(t, ok)
+	tree := map[string]interface{}{"required_kind": 3}
+	for i := 0; i < 9; i++ {
+		tree = map[string]interface{}{"child": tree, "required_kind": 4}
+	}
+	treeData, err := MarshalMessage(tree, node)
+	require.NoError(t, err)
+	_, err = UnmarshalOptions{MaxDepth: 10}.Unmarshal(treeData, node)
+	require.NoError(t, err)
+	_, err = UnmarshalOptions{MaxDepth: 9}.Unmarshal(treeData, node)
+	require.Equal(t, &DepthLimitError{Limit: 9}, errors.Cause(err))
+
+	// lazy messages keep their depth
+	res, err := UnmarshalOptions{MaxDepth: 9, LazyMessages: true}.Unmarshal(treeData, node)
+	require.NoError(t, err)
+	child := res["child"].(*LazyMessage)
+	for i := 0; i < 8; i++ {
+		childData, err := child.Map()
+		require.NoError(t, err)
+		child = childData["child"].(*LazyMessage)
+	}
+	_, err = child.Map()
+	require.Equal(t, &DepthLimitError{Limit: 9}, errors.Cause(err))
+}
+
+func TestUnmarshalMessageTruncated(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/full.proto", nil, nil)
+	require.NoError(t, err)
+	msg, ok := parsedFile.Message(TypeName{"ComplexMessage"})
+	require.True(t, ok)
+
+	data, err := proto.Marshal(&full.ComplexMessage{ScalarString: "hello"})
+	require.NoError(t, err)
+	_, err = UnmarshalMessage(data, msg)
+	require.NoError(t, err)
+
+	// key of the last field is cut
+	_, err = UnmarshalMessage(append(data, 0xc0), msg)
+	require.Error(t, err)
+
+	// the last packed element is cut
+	packed := append(proto.EncodeVarint(messageKeyVarint(24, WireTypeLengthDelimited)), 2, 0x01, 0x80)
+	_, err = UnmarshalMessage(packed, msg)
+	require.Error(t, err)
+
+	// key of map entry field is cut
+	entry := append(proto.EncodeVarint(messageKeyVarint(19, WireTypeLengthDelimited)), 3, 0x08, 0x01, 0x90)
+	_, err = UnmarshalMessage(entry, msg)
+	require.Error(t, err)
+}
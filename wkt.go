@@ -0,0 +1,448 @@
+package shprotos
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	wktTimestamp   = "google.protobuf.Timestamp"
+	wktDuration    = "google.protobuf.Duration"
+	wktStruct      = "google.protobuf.Struct"
+	wktValue       = "google.protobuf.Value"
+	wktListValue   = "google.protobuf.ListValue"
+	wktFieldMask   = "google.protobuf.FieldMask"
+	wktEmpty       = "google.protobuf.Empty"
+	wktDoubleValue = "google.protobuf.DoubleValue"
+	wktFloatValue  = "google.protobuf.FloatValue"
+	wktInt64Value  = "google.protobuf.Int64Value"
+	wktUInt64Value = "google.protobuf.UInt64Value"
+	wktInt32Value  = "google.protobuf.Int32Value"
+	wktUInt32Value = "google.protobuf.UInt32Value"
+	wktBoolValue   = "google.protobuf.BoolValue"
+	wktStringValue = "google.protobuf.StringValue"
+	wktBytesValue  = "google.protobuf.BytesValue"
+)
+
+const (
+	// Timestamp range is limited to years 0001-9999, the same way as in RFC 3339.
+	minTimestampSeconds = -62135596800
+	maxTimestampSeconds = 253402300799
+	// Duration range is limited to about 10000 years.
+	maxDurationSeconds = 315576000000
+)
+
+// wellKnownType converts value of well-known message between decoded message map and its canonical representation:
+//   - Timestamp is an RFC 3339 string in UTC, like "2019-01-02T15:04:05.5Z". time.Time is also accepted.
+//   - Duration is a string of seconds with "s" suffix, like "1.5s". time.Duration is also accepted.
+//   - Wrappers are bare values of wrapped scalar type.
+//   - Struct is map[string]interface{}, ListValue is []interface{} and Value is any of them, nil, float64,
+//     string or bool, the same way as encoding/json decodes JSON into interface{}.
+//   - FieldMask is a comma-joined string of paths, like "a.b,c". []string is also accepted.
+//   - Empty is an empty map[string]interface{}.
+//
+// Timestamp, Duration, wrappers and FieldMask are also accepted as message maps on marshal.
+type wellKnownType struct {
+	fromMessage func(data map[string]interface{}, msg *Message) (interface{}, error)
+	toMessage   func(value interface{}, msg *Message) (map[string]interface{}, error)
+}
+
+var wellKnownTypes map[string]wellKnownType
+
+func init() {
+	wrapper := wellKnownType{fromMessage: wrapperFromMessage, toMessage: wrapperToMessage}
+	wellKnownTypes = map[string]wellKnownType{
+		wktTimestamp:   {fromMessage: timestampFromMessage, toMessage: timestampToMessage},
+		wktDuration:    {fromMessage: durationFromMessage, toMessage: durationToMessage},
+		wktStruct:      {fromMessage: structFromMessage, toMessage: structToMessage},
+		wktValue:       {fromMessage: valueFromMessage, toMessage: valueToMessage},
+		wktListValue:   {fromMessage: listValueFromMessage, toMessage: listValueToMessage},
+		wktFieldMask:   {fromMessage: fieldMaskFromMessage, toMessage: fieldMaskToMessage},
+		wktEmpty:       {fromMessage: emptyFromMessage, toMessage: emptyToMessage},
+		wktDoubleValue: wrapper,
+		wktFloatValue:  wrapper,
+		wktInt64Value:  wrapper,
+		wktUInt64Value: wrapper,
+		wktInt32Value:  wrapper,
+		wktUInt32Value: wrapper,
+		wktBoolValue:   wrapper,
+		wktStringValue: wrapper,
+		wktBytesValue:  wrapper,
+	}
+}
+
+// wellKnownTypeOf returns well-known type converter, if typ is one of well-known messages.
+func wellKnownTypeOf(typ Type) (wellKnownType, *Message, bool) {
+	msg, ok := typ.(*Message)
+	if !ok {
+		return wellKnownType{}, nil, false
+	}
+	wkt, ok := wellKnownTypes[msg.GetFullName()]
+
+	return wkt, msg, ok
+}
+
+// holdsNullValue reports whether nil value of field is a null value of google.protobuf.Value, not a missing one.
+func holdsNullValue(field Field) bool {
+	fld, ok := field.(*NormalField)
+
+	return ok && !fld.Repeated && isWellKnownType(fld.Type, wktValue)
+}
+
+func isWellKnownType(typ Type, fullName string) bool {
+	msg, ok := typ.(*Message)
+
+	return ok && msg.GetFullName() == fullName
+}
+
+func int64Field(data map[string]interface{}, name string) (int64, error) {
+	value, ok := data[name]
+	if !ok {
+		return 0, nil
+	}
+	intValue, err := uint64FromInterface(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to resolve %s", name)
+	}
+
+	return int64(intValue), nil
+}
+
+func secondsAndNanos(data map[string]interface{}) (int64, int32, error) {
+	seconds, err := int64Field(data, "seconds")
+	if err != nil {
+		return 0, 0, err
+	}
+	nanos, err := int64Field(data, "nanos")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return seconds, int32(nanos), nil
+}
+
+// secondsAndNanosMessage returns message map, omitting zero fields the same way proto3 encoding does.
+func secondsAndNanosMessage(seconds int64, nanos int32) map[string]interface{} {
+	result := make(map[string]interface{}, 2)
+	if seconds != 0 {
+		result["seconds"] = seconds
+	}
+	if nanos != 0 {
+		result["nanos"] = nanos
+	}
+
+	return result
+}
+
+// formatNanos formats fractional part of seconds with 0, 3, 6 or 9 digits.
+func formatNanos(nanos int32) string {
+	switch {
+	case nanos == 0:
+		return ""
+	case nanos%1e6 == 0:
+		return fmt.Sprintf(".%03d", nanos/1e6)
+	case nanos%1e3 == 0:
+		return fmt.Sprintf(".%06d", nanos/1e3)
+	}
+
+	return fmt.Sprintf(".%09d", nanos)
+}
+
+func formatTimestamp(seconds int64, nanos int32) (string, error) {
+	if seconds < minTimestampSeconds || seconds > maxTimestampSeconds {
+		return "", errors.Errorf("timestamp seconds %d are out of range", seconds)
+	}
+	if nanos < 0 || nanos >= 1e9 {
+		return "", errors.Errorf("timestamp nanos %d are out of range", nanos)
+	}
+
+	return time.Unix(seconds, int64(nanos)).UTC().Format("2006-01-02T15:04:05") + formatNanos(nanos) + "Z", nil
+}
+
+func parseTimestamp(value interface{}) (int64, int32, error) {
+	var t time.Time
+	switch value := value.(type) {
+	case time.Time:
+		t = value
+	case string:
+		var err error
+		t, err = time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "failed to parse timestamp")
+		}
+	default:
+		return 0, 0, errors.Errorf("can't convert %T to timestamp", value)
+	}
+	if t.Unix() < minTimestampSeconds || t.Unix() > maxTimestampSeconds {
+		return 0, 0, errors.Errorf("timestamp %s is out of range", t)
+	}
+
+	return t.Unix(), int32(t.Nanosecond()), nil
+}
+
+func timestampFromMessage(data map[string]interface{}, _ *Message) (interface{}, error) {
+	seconds, nanos, err := secondsAndNanos(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return formatTimestamp(seconds, nanos)
+}
+
+func timestampToMessage(value interface{}, _ *Message) (map[string]interface{}, error) {
+	if data, ok := value.(map[string]interface{}); ok {
+		return data, nil
+	}
+	seconds, nanos, err := parseTimestamp(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return secondsAndNanosMessage(seconds, nanos), nil
+}
+
+func formatDuration(seconds int64, nanos int32) (string, error) {
+	if seconds < -maxDurationSeconds || seconds > maxDurationSeconds {
+		return "", errors.Errorf("duration seconds %d are out of range", seconds)
+	}
+	if nanos <= -1e9 || nanos >= 1e9 || (seconds > 0 && nanos < 0) || (seconds < 0 && nanos > 0) {
+		return "", errors.Errorf("duration nanos %d are out of range", nanos)
+	}
+	sign := ""
+	if seconds < 0 || nanos < 0 {
+		sign = "-"
+		seconds, nanos = -seconds, -nanos
+	}
+
+	return sign + strconv.FormatInt(seconds, 10) + formatNanos(nanos) + "s", nil
+}
+
+func parseDuration(value interface{}) (int64, int32, error) {
+	switch value := value.(type) {
+	case time.Duration:
+		return int64(value / time.Second), int32(value % time.Second), nil
+	case string:
+		str := value
+		if !strings.HasSuffix(str, "s") {
+			return 0, 0, errors.Errorf("duration %q should have s suffix", value)
+		}
+		str = str[:len(str)-1]
+		negative := strings.HasPrefix(str, "-")
+		if negative {
+			str = str[1:]
+		}
+		secondsPart, nanosPart := str, ""
+		if i := strings.IndexByte(str, '.'); i >= 0 {
+			secondsPart, nanosPart = str[:i], str[i+1:]
+		}
+		if secondsPart == "" || len(nanosPart) > 9 || strings.HasPrefix(secondsPart, "+") {
+			return 0, 0, errors.Errorf("invalid duration %q", value)
+		}
+		seconds, err := strconv.ParseInt(secondsPart, 10, 64)
+		if err != nil || seconds > maxDurationSeconds {
+			return 0, 0, errors.Errorf("invalid duration %q", value)
+		}
+		var nanos int64
+		if nanosPart != "" {
+			nanos, err = strconv.ParseInt(nanosPart+strings.Repeat("0", 9-len(nanosPart)), 10, 32)
+			if err != nil || nanos < 0 {
+				return 0, 0, errors.Errorf("invalid duration %q", value)
+			}
+		}
+		if negative {
+			seconds, nanos = -seconds, -nanos
+		}
+		return seconds, int32(nanos), nil
+	}
+
+	return 0, 0, errors.Errorf("can't convert %T to duration", value)
+}
+
+func durationFromMessage(data map[string]interface{}, _ *Message) (interface{}, error) {
+	seconds, nanos, err := secondsAndNanos(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return formatDuration(seconds, nanos)
+}
+
+func durationToMessage(value interface{}, _ *Message) (map[string]interface{}, error) {
+	if data, ok := value.(map[string]interface{}); ok {
+		return data, nil
+	}
+	seconds, nanos, err := parseDuration(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return secondsAndNanosMessage(seconds, nanos), nil
+}
+
+// wrapperValueType returns type of value field of wrapper message.
+func wrapperValueType(msg *Message) (Type, error) {
+	field, ok := msg.GetFieldByName("value")
+	if !ok {
+		return nil, errors.Errorf("wrapper %s has no value field", msg.GetFullName())
+	}
+
+	return field.GetType(), nil
+}
+
+func wrapperFromMessage(data map[string]interface{}, msg *Message) (interface{}, error) {
+	if value, ok := data["value"]; ok {
+		return value, nil
+	}
+	typ, err := wrapperValueType(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return mapEntryDefaultValue(typ), nil
+}
+
+func wrapperToMessage(value interface{}, _ *Message) (map[string]interface{}, error) {
+	if data, ok := value.(map[string]interface{}); ok {
+		return data, nil
+	}
+
+	return map[string]interface{}{"value": value}, nil
+}
+
+func structFromMessage(data map[string]interface{}, _ *Message) (interface{}, error) {
+	result := make(map[string]interface{})
+	switch fields := data["fields"].(type) {
+	case map[string]interface{}:
+		for key, value := range fields {
+			result[key] = value
+		}
+	case map[interface{}]interface{}:
+		for key, value := range fields {
+			result[fmt.Sprint(key)] = value
+		}
+	}
+
+	return result, nil
+}
+
+func structToMessage(value interface{}, _ *Message) (map[string]interface{}, error) {
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("can't convert %T to struct", value)
+	}
+
+	return map[string]interface{}{"fields": fields}, nil
+}
+
+func valueFromMessage(data map[string]interface{}, _ *Message) (interface{}, error) {
+	for _, name := range []string{"number_value", "string_value", "bool_value", "struct_value", "list_value"} {
+		if value, ok := data[name]; ok {
+			return value, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func valueToMessage(value interface{}, _ *Message) (map[string]interface{}, error) {
+	switch value := value.(type) {
+	case nil:
+		return map[string]interface{}{"null_value": int32(0)}, nil
+	case bool:
+		return map[string]interface{}{"bool_value": value}, nil
+	case string:
+		return map[string]interface{}{"string_value": value}, nil
+	case json.Number:
+		number, err := value.Float64()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse number")
+		}
+		return map[string]interface{}{"number_value": number}, nil
+	case map[string]interface{}:
+		return map[string]interface{}{"struct_value": value}, nil
+	}
+	if list, err := listValueToMessage(value, nil); err == nil {
+		return map[string]interface{}{"list_value": list["values"]}, nil
+	}
+	number, err := float64FromInterface(value)
+	if err != nil {
+		return nil, errors.Errorf("can't convert %T to value", value)
+	}
+
+	return map[string]interface{}{"number_value": number}, nil
+}
+
+func listValueFromMessage(data map[string]interface{}, _ *Message) (interface{}, error) {
+	values, _ := data["values"].([]interface{})
+	if values == nil {
+		values = []interface{}{}
+	}
+
+	return values, nil
+}
+
+func listValueToMessage(value interface{}, _ *Message) (map[string]interface{}, error) {
+	if values, ok := value.([]interface{}); ok {
+		return map[string]interface{}{"values": values}, nil
+	}
+	slice := reflect.ValueOf(value)
+	if slice.Kind() != reflect.Slice || slice.Type().Elem().Kind() == reflect.Uint8 {
+		return nil, errors.Errorf("can't convert %T to list", value)
+	}
+	values := make([]interface{}, slice.Len())
+	for i := range values {
+		values[i] = slice.Index(i).Interface()
+	}
+
+	return map[string]interface{}{"values": values}, nil
+}
+
+func fieldMaskFromMessage(data map[string]interface{}, _ *Message) (interface{}, error) {
+	switch paths := data["paths"].(type) {
+	case []string:
+		return strings.Join(paths, ","), nil
+	case []interface{}:
+		result := make([]string, len(paths))
+		for i, path := range paths {
+			result[i], _ = path.(string)
+		}
+		return strings.Join(result, ","), nil
+	}
+
+	return "", nil
+}
+
+func fieldMaskToMessage(value interface{}, _ *Message) (map[string]interface{}, error) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return value, nil
+	case []string:
+		return map[string]interface{}{"paths": value}, nil
+	case string:
+		if value == "" {
+			return map[string]interface{}{}, nil
+		}
+		return map[string]interface{}{"paths": strings.Split(value, ",")}, nil
+	}
+
+	return nil, errors.Errorf("can't convert %T to field mask", value)
+}
+
+func emptyFromMessage(data map[string]interface{}, _ *Message) (interface{}, error) {
+	return data, nil
+}
+
+func emptyToMessage(value interface{}, _ *Message) (map[string]interface{}, error) {
+	data, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("can't convert %T to empty", value)
+	}
+
+	return data, nil
+}
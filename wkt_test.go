@@ -0,0 +1,207 @@
+package shprotos
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/golang/protobuf/ptypes/empty"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWellKnownTypes(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/wkt.proto", nil, []string{"./testdata"})
+	require.NoError(t, err)
+	msg, ok := parsedFile.Message(TypeName{"WellKnown"})
+	require.True(t, ok)
+
+	structValue := &structpb.Struct{Fields: map[string]*structpb.Value{
+		"a": {Kind: &structpb.Value_NumberValue{NumberValue: 1}},
+		"b": {Kind: &structpb.Value_ListValue{ListValue: &structpb.ListValue{Values: []*structpb.Value{
+			{Kind: &structpb.Value_StringValue{StringValue: "x"}},
+			{Kind: &structpb.Value_NullValue{}},
+		}}}},
+		"c": {Kind: &structpb.Value_StructValue{StructValue: &structpb.Struct{Fields: map[string]*structpb.Value{
+			"d": {Kind: &structpb.Value_BoolValue{BoolValue: true}},
+		}}}},
+	}}
+	fields := []struct {
+		name  string
+		value proto.Message
+	}{
+		{"timestamp", &timestamp.Timestamp{Seconds: 1546300800, Nanos: 500000000}},
+		{"duration", &duration.Duration{Seconds: -1, Nanos: -500000000}},
+		{"int64_value", &wrappers.Int64Value{Value: 1 << 60}},
+		{"string_value", &wrappers.StringValue{}},
+		{"bytes_value", &wrappers.BytesValue{Value: []byte("hi")}},
+		{"bool_value", &wrappers.BoolValue{Value: true}},
+		{"struct", structValue},
+		{"value", &structpb.Value{Kind: &structpb.Value_StringValue{StringValue: "s"}}},
+		{"list", &structpb.ListValue{Values: []*structpb.Value{{Kind: &structpb.Value_NumberValue{NumberValue: 2}}}}},
+		{"empty", &empty.Empty{}},
+		{"timestamps", &timestamp.Timestamp{}},
+		{"timestamps", &timestamp.Timestamp{Seconds: 1, Nanos: 1000}},
+	}
+	buffer := proto.NewBuffer(nil)
+	jsonFields := map[string][]string{}
+	for _, field := range fields {
+		fld, ok := msg.GetFieldByName(field.name)
+		require.True(t, ok)
+		data, err := proto.Marshal(field.value)
+		require.NoError(t, err)
+		require.NoError(t, buffer.EncodeVarint(messageKeyVarint(fld.GetKeyNumber(), WireTypeLengthDelimited)))
+		require.NoError(t, buffer.EncodeRawBytes(data))
+		jsonValue, err := (&jsonpb.Marshaler{}).MarshalToString(field.value)
+		require.NoError(t, err)
+		jsonFields[fld.GetJSONName()] = append(jsonFields[fld.GetJSONName()], jsonValue)
+	}
+	// field mask and map of values are built by hand, as there are no generated types for them
+	fieldMask := proto.NewBuffer(nil)
+	require.NoError(t, fieldMask.EncodeVarint(messageKeyVarint(1, WireTypeLengthDelimited)))
+	require.NoError(t, fieldMask.EncodeStringBytes("a.b_c"))
+	require.NoError(t, fieldMask.EncodeVarint(messageKeyVarint(1, WireTypeLengthDelimited)))
+	require.NoError(t, fieldMask.EncodeStringBytes("d"))
+	require.NoError(t, buffer.EncodeVarint(messageKeyVarint(10, WireTypeLengthDelimited)))
+	require.NoError(t, buffer.EncodeRawBytes(fieldMask.Bytes()))
+	nullValue, err := proto.Marshal(&structpb.Value{Kind: &structpb.Value_NullValue{}})
+	require.NoError(t, err)
+	entry := proto.NewBuffer(nil)
+	require.NoError(t, entry.EncodeVarint(messageKeyVarint(1, WireTypeLengthDelimited)))
+	require.NoError(t, entry.EncodeStringBytes("n"))
+	require.NoError(t, entry.EncodeVarint(messageKeyVarint(2, WireTypeLengthDelimited)))
+	require.NoError(t, entry.EncodeRawBytes(nullValue))
+	require.NoError(t, buffer.EncodeVarint(messageKeyVarint(13, WireTypeLengthDelimited)))
+	require.NoError(t, buffer.EncodeRawBytes(entry.Bytes()))
+
+	expected := map[string]interface{}{
+		"timestamp":    "2019-01-01T00:00:00.500Z",
+		"duration":     "-1.500s",
+		"int64_value":  int64(1 << 60),
+		"string_value": "",
+		"bytes_value":  "aGk=",
+		"bool_value":   true,
+		"struct": map[string]interface{}{
+			"a": float64(1),
+			"b": []interface{}{"x", nil},
+			"c": map[string]interface{}{"d": true},
+		},
+		"value":      "s",
+		"list":       []interface{}{float64(2)},
+		"field_mask": "a.b_c,d",
+		"empty":      map[string]interface{}{},
+		"timestamps": []interface{}{"1970-01-01T00:00:00Z", "1970-01-01T00:00:01.000001Z"},
+		"values":     map[string]interface{}{"n": nil},
+	}
+	res, err := UnmarshalMessage(buffer.Bytes(), msg)
+	require.NoError(t, err)
+	require.Equal(t, expected, res)
+
+	marshalled, err := MarshalMessage(expected, msg)
+	require.NoError(t, err)
+	res, err = UnmarshalMessage(marshalled, msg)
+	require.NoError(t, err)
+	require.Equal(t, expected, res)
+
+	// json representation matches jsonpb one
+	expectedJSON := "{"
+	for name, values := range jsonFields {
+		if len(values) > 1 {
+			expectedJSON += `"` + name + `":[` + values[0] + "," + values[1] + "],"
+		} else {
+			expectedJSON += `"` + name + `":` + values[0] + ","
+		}
+	}
+	expectedJSON += `"fieldMask":"a.bC,d","values":{"n":null}}`
+	jsonData, err := JSONMarshaler{}.Marshal(expected, msg)
+	require.NoError(t, err)
+	require.JSONEq(t, expectedJSON, string(jsonData))
+	res, err = JSONUnmarshaler{}.Unmarshal([]byte(expectedJSON), msg)
+	require.NoError(t, err)
+	require.Equal(t, expected, res)
+}
+
+func TestWellKnownTypesMarshal(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/wkt.proto", nil, []string{"./testdata"})
+	require.NoError(t, err)
+	msg, ok := parsedFile.Message(TypeName{"WellKnown"})
+	require.True(t, ok)
+
+	expected := proto.NewBuffer(nil)
+	for i, value := range []proto.Message{
+		&timestamp.Timestamp{Seconds: 1546300800, Nanos: 500000000},
+		&duration.Duration{Seconds: -1, Nanos: -500000000},
+	} {
+		data, err := proto.Marshal(value)
+		require.NoError(t, err)
+		require.NoError(t, expected.EncodeVarint(messageKeyVarint(uint64(i+1), WireTypeLengthDelimited)))
+		require.NoError(t, expected.EncodeRawBytes(data))
+	}
+	res, err := MarshalMessage(map[string]interface{}{
+		"timestamp": time.Unix(1546300800, 500000000),
+		"duration":  -1500 * time.Millisecond,
+	}, msg)
+	require.NoError(t, err)
+	require.Equal(t, expected.Bytes(), res)
+	res, err = MarshalMessage(map[string]interface{}{
+		"timestamp": "2019-01-01T03:00:00.5+03:00",
+		"duration":  map[string]interface{}{"seconds": -1, "nanos": -500000000},
+	}, msg)
+	require.NoError(t, err)
+	require.Equal(t, expected.Bytes(), res)
+
+	for name, value := range map[string]interface{}{
+		"timestamp":   "2019-01-01",
+		"duration":    "1.5",
+		"int64_value": "one",
+		"struct":      []interface{}{},
+		"list":        "x",
+		"field_mask":  1,
+	} {
+		_, err := MarshalMessage(map[string]interface{}{name: value}, msg)
+		require.IsType(t, &FieldError{}, err, name)
+	}
+	_, err = MarshalMessage(map[string]interface{}{"timestamp": "10000-01-01T00:00:00Z"}, msg)
+	require.Error(t, err)
+}
+
+func TestWellKnownTypesNullValue(t *testing.T) {
+	parser := Parser{}
+	parsedFile, err := parser.Parse("./testdata/wkt.proto", nil, []string{"./testdata"})
+	require.NoError(t, err)
+	msg, ok := parsedFile.Message(TypeName{"WellKnown"})
+	require.True(t, ok)
+
+	// null of Value field is a Value, which holds NullValue
+	res, err := JSONUnmarshaler{}.Unmarshal([]byte(`{"value": null, "struct": null}`), msg)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"value": nil}, res)
+
+	nullValue, err := proto.Marshal(&structpb.Value{Kind: &structpb.Value_NullValue{}})
+	require.NoError(t, err)
+	expected := proto.NewBuffer(nil)
+	require.NoError(t, expected.EncodeVarint(messageKeyVarint(8, WireTypeLengthDelimited)))
+	require.NoError(t, expected.EncodeRawBytes(nullValue))
+	data, err := MarshalMessage(res, msg)
+	require.NoError(t, err)
+	require.Equal(t, expected.Bytes(), data)
+
+	res, err = UnmarshalMessage(data, msg)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"value": nil}, res)
+	jsonData, err := JSONMarshaler{}.Marshal(res, msg)
+	require.NoError(t, err)
+	require.Equal(t, `{"value":null}`, string(jsonData))
+
+	// unset Value field isn't populated with defaults
+	res, err = UnmarshalOptions{EmitDefaults: true}.Unmarshal(nil, msg)
+	require.NoError(t, err)
+	require.NotContains(t, res, "value")
+	require.Contains(t, res, "struct")
+}